@@ -0,0 +1,1477 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ============================================================================
+// TYPES - Conversion Options
+// ============================================================================
+
+// conversionOptions holds the datasource settings that affect frame conversion
+type conversionOptions struct {
+	strictTypes bool           // Fail on unrecognized column types instead of rendering them as strings
+	strictTime  bool           // Fail timeseries without a time column instead of returning them as tables
+	location    *time.Location // Zone of time strings without an explicit offset (UTC when nil)
+
+	lenientNumbers bool // Accepts DOUBLE/FLOAT strings with grouping separators or currency symbols, e.g. "$1,234.5"
+	nullSentinels  bool // Returns Pinot's default null values of numeric dimensions (e.g. INT -2147483648) as nulls
+	geoJSON        bool // Converts string columns holding only WKT geometries to GeoJSON, see convertWKTColumn
+
+	typeOverrides map[string]string // Grafana type of columns by name or Pinot type, see overriddenType
+	stringColumns *regexp.Regexp    // Names of the columns converted as strings, like QueryModel.StringColumns
+	timeFormat    *schemaTimeFormat // Format of the time column of a timeseries, see DataSource.enrichFromSchema
+
+	maxColumns int // Drops the fields beyond this many, with a notice (0 keeps every field)
+}
+
+// rawSketchColumnRegex matches the result columns of Pinot's raw aggregations (e.g.
+// distinctcountrawhll(user) or percentilerawtdigest95(latency)), which return serialized sketches
+// rather than numbers and are always rendered as strings
+var rawSketchColumnRegex = regexp.MustCompile(`(?i)^\s*[a-z]+raw[a-z0-9]*\s*\(`)
+
+// columnFieldTypes maps the Pinot column types recognized by the conversion to their field type
+// Types are matched exactly, so e.g. TIMESTAMP or INT_ARRAY never fall into the INT mapping
+// Arrays, JSON, BYTES and objects are rendered as strings; unrecognized types are too
+var columnFieldTypes = map[string]data.FieldType{
+	"INT":         data.FieldTypeNullableInt64,
+	"LONG":        data.FieldTypeNullableInt64,
+	"FLOAT":       data.FieldTypeNullableFloat64,
+	"DOUBLE":      data.FieldTypeNullableFloat64,
+	"BIG_DECIMAL": data.FieldTypeNullableFloat64,
+	"BOOLEAN":     data.FieldTypeNullableBool,
+	"TIMESTAMP":   data.FieldTypeNullableTime,
+	"STRING":      data.FieldTypeNullableString,
+	"JSON":        data.FieldTypeNullableString,
+	"BYTES":       data.FieldTypeNullableString,
+
+	"INT_ARRAY":       data.FieldTypeNullableString,
+	"LONG_ARRAY":      data.FieldTypeNullableString,
+	"FLOAT_ARRAY":     data.FieldTypeNullableString,
+	"DOUBLE_ARRAY":    data.FieldTypeNullableString,
+	"BOOLEAN_ARRAY":   data.FieldTypeNullableString,
+	"TIMESTAMP_ARRAY": data.FieldTypeNullableString,
+	"STRING_ARRAY":    data.FieldTypeNullableString,
+	"BYTES_ARRAY":     data.FieldTypeNullableString,
+
+	"OBJECT":  data.FieldTypeNullableString,
+	"UNKNOWN": data.FieldTypeNullableString,
+}
+
+// ============================================================================
+// CONVERSION - Result Table to Data Frames
+// ============================================================================
+
+// convertToDataFrames converts a Pinot broker response into Grafana data frames
+// Fields follow the order of DataSchema.ColumnNames, with one exception: timeseries results
+// become a wide frame with the time field moved first, sorted by time
+func convertToDataFrames(refID string, pinotResp *PinotResponse, qm QueryModel, opts conversionOptions) (data.Frames, error) {
+	if qm.Format == FormatStats {
+		return data.Frames{statsFrame(refID, pinotResp)}, nil
+	}
+	if len(pinotResp.ResultTables) > 1 {
+		return convertResultTables(refID, pinotResp, qm, opts)
+	}
+
+	frame := data.NewFrame(refID)
+	frame.RefID = refID
+
+	resultTable := pinotResp.ResultTable
+	if resultTable == nil {
+		return data.Frames{frame}, nil
+	}
+
+	if qm.ExpandObject {
+		if expanded, ok := expandObjectColumn(resultTable); ok {
+			resultTable = expanded
+		}
+	}
+
+	schema := resultTable.DataSchema
+	columnTypes := schema.ColumnDataTypes
+	inferred := len(columnTypes) == 0 && len(schema.ColumnNames) > 0
+	if inferred {
+		// Without declared types every column would be a string, which breaks numeric panels
+		columnTypes = make([]string, len(schema.ColumnNames))
+		for colIdx := range schema.ColumnNames {
+			columnTypes[colIdx] = inferColumnType(resultTable.Rows, colIdx)
+		}
+	}
+
+	// The columns are converted as their overridden type, while pinotType keeps the declared one
+	converted := DataSchema{ColumnNames: schema.ColumnNames, ColumnDataTypes: make([]string, len(schema.ColumnNames))}
+	for colIdx, columnName := range schema.ColumnNames {
+		columnType := ""
+		if colIdx < len(columnTypes) {
+			columnType = columnTypes[colIdx]
+		}
+		converted.ColumnDataTypes[colIdx] = overriddenType(columnName, columnType, opts.typeOverrides)
+	}
+
+	if opts.strictTypes {
+		if unknown := unrecognizedColumnTypes(converted); len(unknown) > 0 {
+			return nil, fmt.Errorf("unrecognized column types: %s", strings.Join(unknown, ", "))
+		}
+	}
+
+	timeColIdx := -1
+	var notices []data.Notice
+	if qm.Format == FormatTimeSeries {
+		timeColIdx = findTimeColumn(converted, qm.TimeColumn)
+		if timeColIdx < 0 && opts.strictTime {
+			return nil, fmt.Errorf("time column not found in the result, select a TIMESTAMP column or set the time column of the query")
+		}
+		if timeColIdx < 0 {
+			// The rows stay visible as a table rather than failing the whole panel
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "Time column not found in the result, the data is returned as a table; select a TIMESTAMP column or set the time column of the query",
+			})
+		}
+	}
+
+	for colIdx, columnName := range schema.ColumnNames {
+		columnType := ""
+		if colIdx < len(columnTypes) {
+			columnType = columnTypes[colIdx]
+		}
+
+		// The time column of a timeseries is always a time field, whatever its declared type (e.g. LONG epochs)
+		fieldType := converted.ColumnDataTypes[colIdx]
+		if colIdx == timeColIdx {
+			fieldType = "TIMESTAMP"
+		} else if containsFold(qm.StringColumns, columnName) || rawSketchColumnRegex.MatchString(columnName) ||
+			(opts.stringColumns != nil && opts.stringColumns.MatchString(columnName)) {
+			fieldType = "STRING"
+		}
+
+		// The time column keeps its type so the timeseries stays usable; other columns are promoted
+		// to strings when their values do not fit the declared type
+		var field *data.Field
+		var err error
+		if colIdx == timeColIdx && opts.timeFormat != nil {
+			field = opts.timeFormat.convertColumn(columnName, colIdx, resultTable.Rows)
+		} else if field, err = convertColumn(columnName, fieldType, colIdx, resultTable.Rows, colIdx != timeColIdx, opts); err != nil {
+			return nil, err
+		}
+		if opts.geoJSON && colIdx != timeColIdx {
+			convertWKTColumn(field)
+		}
+		if inferred {
+			// Pinot declared no type, so the inferred one is not passed off as pinotType
+			field.Config = &data.FieldConfig{Custom: map[string]interface{}{"inferredType": columnType}}
+		} else if columnType != "" {
+			// Keep the declared Pinot type visible to transforms and the inspector
+			field.Config = &data.FieldConfig{Custom: map[string]interface{}{"pinotType": columnType}}
+		}
+		frame.Fields = append(frame.Fields, field)
+
+		// The raw epoch values stay available for tooltips and joins next to the parsed time
+		if colIdx == timeColIdx && qm.KeepRawTime && createFieldForColumn("", columnType, 0).Type().Numeric() {
+			rawField, err := convertColumn(columnName+"_raw", columnType, colIdx, resultTable.Rows, false, opts)
+			if err != nil {
+				return nil, err
+			}
+			// Hidden from the graph and legend, where the epochs would plot as a series of their own
+			custom := map[string]interface{}{"hideFrom": map[string]interface{}{"viz": true, "legend": true, "tooltip": false}}
+			if field.Config != nil {
+				for key, value := range field.Config.Custom {
+					custom[key] = value // The Pinot or inferred type of the column
+				}
+			}
+			rawField.Config = &data.FieldConfig{Custom: custom}
+			frame.Fields = append(frame.Fields, rawField)
+		}
+	}
+
+	if qm.LegendColumn != "" {
+		if err := applyLegendColumn(frame, qm.LegendColumn, timeColIdx); err != nil {
+			return nil, err
+		}
+	}
+
+	applyColumnAliases(frame, qm.ColumnAliases)
+
+	if timeColIdx >= 0 {
+		frame = toTimeSeriesFrame(frame, timeColIdx)
+	}
+	// Applied once the time field is first, so a timeseries keeps it
+	dropped := limitColumns(frame, opts.maxColumns)
+
+	frames := data.Frames{frame}
+	if timeColIdx >= 0 {
+		if qm.AutoLabels && qm.LegendColumn == "" {
+			labeled, err := splitSeriesByLabel(frame)
+			if err != nil {
+				return nil, err
+			}
+			frames = data.Frames{labeled}
+		} else if qm.LegendColumn == "" {
+			nameValueFields(frame)
+		}
+		if qm.Reduce == ReduceLast {
+			frames = reduceToLast(frames[0])
+		}
+	} else if qm.SplitColumns && frame.Rows() == 1 && len(frame.Fields) > 1 {
+		frames = splitColumnFrames(frame)
+	}
+
+	if dropped > 0 {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("%d columns were dropped, showing the first %d columns; select fewer columns to see them all", dropped, opts.maxColumns),
+		})
+	}
+	if len(notices) > 0 {
+		for _, frame := range frames {
+			frame.AppendNotices(notices...)
+		}
+	}
+
+	return frames, nil
+}
+
+// convertResultTables converts each result table of a response holding several into its own
+// frames, named after the RefID with the table position (e.g. A_1, A_2) as their schemas differ
+func convertResultTables(refID string, pinotResp *PinotResponse, qm QueryModel, opts conversionOptions) (data.Frames, error) {
+	var frames data.Frames
+	for tableIdx, table := range pinotResp.ResultTables {
+		single := *pinotResp
+		single.ResultTable, single.ResultTables = table, nil
+
+		tableFrames, err := convertToDataFrames(refID, &single, qm, opts)
+		if err != nil {
+			return nil, fmt.Errorf("result table %d: %w", tableIdx+1, err)
+		}
+		for _, frame := range tableFrames {
+			frame.Name = fmt.Sprintf("%s_%d", frame.Name, tableIdx+1)
+		}
+		frames = append(frames, tableFrames...)
+	}
+	return frames, nil
+}
+
+// applyColumnAliases sets the display name of the fields whose column has an alias
+// Column names are matched ignoring case; field names are left unchanged
+func applyColumnAliases(frame *data.Frame, aliases map[string]string) {
+	if len(aliases) == 0 {
+		return
+	}
+
+	for _, field := range frame.Fields {
+		for column, alias := range aliases {
+			if !strings.EqualFold(field.Name, column) {
+				continue
+			}
+			config := data.FieldConfig{}
+			if field.Config != nil {
+				config = *field.Config
+			}
+			config.DisplayName = alias
+			field.Config = &config
+			break
+		}
+	}
+}
+
+// nameValueFields names the series of a wide timeseries after their column, so every value field
+// of a matrix result (e.g. SELECT ts, p50, p95, p99) gets a meaningful legend whatever the frame
+// name. Display names already set, e.g. by column aliases, are kept
+func nameValueFields(frame *data.Frame) {
+	for _, field := range frame.Fields[1:] {
+		if !field.Type().Numeric() {
+			continue
+		}
+		config := data.FieldConfig{}
+		if field.Config != nil {
+			config = *field.Config
+		}
+		if config.DisplayName == "" && config.DisplayNameFromDS == "" {
+			config.DisplayNameFromDS = field.Name
+			field.Config = &config
+		}
+	}
+}
+
+// reduceToLast reduces a time-sorted timeseries to its last data point per series, so stat and
+// gauge panels get only what they display. As the series of a wide frame may end at different
+// times, each becomes a frame holding its last non-null value with the time of that value; a frame
+// with non-numeric fields (e.g. label columns) is reduced to its last row.
+func reduceToLast(frame *data.Frame) data.Frames {
+	if frame.Rows() == 0 || len(frame.Fields) < 2 {
+		return data.Frames{frame}
+	}
+
+	for _, field := range frame.Fields[1:] {
+		if !field.Type().Numeric() {
+			fields := make([]*data.Field, len(frame.Fields))
+			for fieldIdx, field := range frame.Fields {
+				fields[fieldIdx] = fieldRow(field, frame.Rows()-1)
+			}
+			return data.Frames{reducedFrame(frame, fields...)}
+		}
+	}
+
+	timeField := frame.Fields[0]
+	frames := make(data.Frames, 0, len(frame.Fields)-1)
+	for _, field := range frame.Fields[1:] {
+		rowIdx := field.Len() - 1
+		for ; rowIdx >= 0; rowIdx-- {
+			if _, ok := field.ConcreteAt(rowIdx); ok {
+				break
+			}
+		}
+		// A series without any value keeps its fields, without rows
+		frames = append(frames, reducedFrame(frame, fieldRow(timeField, rowIdx), fieldRow(field, rowIdx)))
+	}
+	return frames
+}
+
+// reducedFrame returns a frame with the fields and the name, RefID and a copy of the meta of frame
+func reducedFrame(frame *data.Frame, fields ...*data.Field) *data.Frame {
+	reduced := data.NewFrame(frame.Name, fields...)
+	reduced.RefID = frame.RefID
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		reduced.Meta = &meta
+	}
+	return reduced
+}
+
+// fieldRow returns a copy of the field holding only its value at rowIdx, or no value when rowIdx is negative
+func fieldRow(field *data.Field, rowIdx int) *data.Field {
+	row := data.NewFieldFromFieldType(field.Type(), 0)
+	row.Name = field.Name
+	row.Labels = field.Labels
+	row.Config = field.Config
+	if rowIdx >= 0 {
+		row.Append(field.At(rowIdx))
+	}
+	return row
+}
+
+// limitColumns drops the fields beyond maxColumns, so extremely wide results (e.g. SELECT * on a
+// wide table) do not freeze the browser, and returns the number of dropped fields
+func limitColumns(frame *data.Frame, maxColumns int) int {
+	if maxColumns <= 0 || len(frame.Fields) <= maxColumns {
+		return 0
+	}
+
+	dropped := len(frame.Fields) - maxColumns
+	frame.Fields = frame.Fields[:maxColumns]
+	return dropped
+}
+
+// containsFold reports whether the list holds the value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSeriesByLabel turns a time-sorted timeseries with a single string column into a wide frame
+// with a value field per distinct value of that column, labeled with it (e.g. SELECT ts, host, cpu
+// gives a cpu field per host). Frames with no or several string columns are returned unchanged.
+func splitSeriesByLabel(frame *data.Frame) (*data.Frame, error) {
+	schema := frame.TimeSeriesSchema()
+	if schema.Type != data.TimeSeriesTypeLong || len(schema.FactorIndices) != 1 {
+		return frame, nil
+	}
+	labelField := frame.Fields[schema.FactorIndices[0]]
+	if labelField.Type() != data.FieldTypeNullableString && labelField.Type() != data.FieldTypeString {
+		return frame, nil
+	}
+
+	wide, err := data.LongToWide(frame, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split series by %s: %w", labelField.Name, err)
+	}
+	wide.RefID = frame.RefID
+
+	// Keep the field configs, such as the Pinot type, dropped by the conversion
+	for _, field := range wide.Fields {
+		if longField, _ := frame.FieldByName(field.Name); longField != nil {
+			field.Config = longField.Config
+		}
+	}
+	return wide, nil
+}
+
+// splitColumnFrames returns a frame per field of a single-row result (e.g. SELECT COUNT(*), AVG(x)),
+// each named and displayed after its column
+func splitColumnFrames(frame *data.Frame) data.Frames {
+	frames := make(data.Frames, 0, len(frame.Fields))
+	for _, field := range frame.Fields {
+		if field.Config == nil {
+			field.Config = &data.FieldConfig{}
+		}
+		field.Config.DisplayNameFromDS = field.Name
+
+		columnFrame := data.NewFrame(field.Name, field)
+		columnFrame.RefID = frame.RefID
+		frames = append(frames, columnFrame)
+	}
+	return frames
+}
+
+// applyLegendColumn names the numeric value fields after the first value of the legend column
+// (e.g. a host name), as a label and, for a single value field, as its display name
+func applyLegendColumn(frame *data.Frame, legendColumn string, timeColIdx int) error {
+	legendIdx := -1
+	for idx, field := range frame.Fields {
+		if strings.EqualFold(field.Name, legendColumn) {
+			legendIdx = idx
+			break
+		}
+	}
+	if legendIdx < 0 {
+		return fmt.Errorf("legend column %q not found in the result", legendColumn)
+	}
+
+	legendField := frame.Fields[legendIdx]
+	if legendField.Len() == 0 {
+		return nil
+	}
+	value, ok := legendField.ConcreteAt(0)
+	if !ok {
+		return nil
+	}
+	legend := fmt.Sprintf("%v", value)
+
+	var valueFields []*data.Field
+	for idx, field := range frame.Fields {
+		if idx != legendIdx && idx != timeColIdx && field.Type().Numeric() {
+			valueFields = append(valueFields, field)
+		}
+	}
+	for _, field := range valueFields {
+		field.Labels = data.Labels{legendField.Name: legend}
+		if len(valueFields) == 1 {
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.DisplayNameFromDS = legend
+		}
+	}
+	return nil
+}
+
+// convertColumn builds the field of a result column from the values at colIdx of each row
+// When promote is set, a column whose values do not all convert to the declared type becomes a
+// string field holding every value, rather than a field with nulls in place of the odd values
+func convertColumn(name, columnType string, colIdx int, rows [][]interface{}, promote bool, opts conversionOptions) (*data.Field, error) {
+	field := createFieldForColumn(name, columnType, len(rows))
+	for rowIdx, row := range rows {
+		if colIdx >= len(row) {
+			continue
+		}
+		if opts.nullSentinels && isNullSentinel(columnType, row[colIdx]) {
+			continue
+		}
+		err := setFieldValue(field, rowIdx, row[colIdx], opts)
+		if err == nil {
+			continue
+		}
+		// A serialized sketch in a numeric column would otherwise silently render as null
+		if errors.Is(err, errSerializedValue) {
+			return nil, fmt.Errorf("column %q: %w", name, err)
+		}
+		// Fractional values in an integer column keep their fraction as floats
+		if promote && errors.Is(err, errFractionalValue) && field.Type() == data.FieldTypeNullableInt64 {
+			backend.Logger.Debug("Promoting integer column with fractional values to float", "field", name, "type", columnType)
+			return convertColumn(name, "DOUBLE", colIdx, rows, promote, opts)
+		}
+		// A declared TIMESTAMP always yields times, unparsable values stay null
+		if promote && field.Type() != data.FieldTypeNullableString && field.Type() != data.FieldTypeNullableTime {
+			backend.Logger.Debug("Promoting heterogeneous column to string", "field", name, "type", columnType, "error", err)
+			return convertColumn(name, "STRING", colIdx, rows, false, opts)
+		}
+		backend.Logger.Warn("Failed to convert value", "field", name, "type", columnType, "error", err)
+	}
+	return field, nil
+}
+
+// isNullSentinel reports whether the value is the default null value Pinot stores for a missing
+// numeric dimension: the minimum INT or LONG, or negative infinity for FLOAT and DOUBLE
+func isNullSentinel(columnType string, value interface{}) bool {
+	switch strings.ToUpper(strings.TrimSpace(columnType)) {
+	case "INT":
+		v, err := convertToInt64(value)
+		return err == nil && v == math.MinInt32
+	case "LONG":
+		v, err := convertToInt64(value)
+		return err == nil && v == math.MinInt64
+	case "FLOAT", "DOUBLE":
+		v, err := convertToFloat64(value)
+		return err == nil && math.IsInf(v, -1)
+	default:
+		return false
+	}
+}
+
+// expandObjectColumn flattens a result with a single object column into a column per object key
+// It reports false, keeping the column as JSON strings, unless every row holds an object (or null)
+// with the same keys
+func expandObjectColumn(resultTable *ResultTable) (*ResultTable, bool) {
+	if len(resultTable.DataSchema.ColumnNames) != 1 {
+		return nil, false
+	}
+
+	var keys []string
+	objects := make([]map[string]interface{}, len(resultTable.Rows))
+	for rowIdx, row := range resultTable.Rows {
+		if len(row) == 0 || row[0] == nil {
+			continue
+		}
+		obj, ok := row[0].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if keys == nil {
+			keys = make([]string, 0, len(obj))
+			for key := range obj {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		} else if !hasKeys(obj, keys) {
+			return nil, false
+		}
+		objects[rowIdx] = obj
+	}
+	if len(keys) == 0 {
+		return nil, false
+	}
+
+	expanded := &ResultTable{
+		DataSchema: DataSchema{ColumnNames: keys, ColumnDataTypes: make([]string, len(keys))},
+		Rows:       make([][]interface{}, len(objects)),
+	}
+	for rowIdx, obj := range objects {
+		row := make([]interface{}, len(keys))
+		for colIdx, key := range keys {
+			row[colIdx] = obj[key] // A nil object leaves the row empty
+		}
+		expanded.Rows[rowIdx] = row
+	}
+	for colIdx := range keys {
+		// Expanded numbers stay DOUBLE like the JSON numbers they come from, integral or not
+		columnType := inferColumnType(expanded.Rows, colIdx)
+		if columnType == "LONG" {
+			columnType = "DOUBLE"
+		}
+		expanded.DataSchema.ColumnDataTypes[colIdx] = columnType
+	}
+
+	return expanded, true
+}
+
+// hasKeys reports whether the object has exactly the given keys
+func hasKeys(obj map[string]interface{}, keys []string) bool {
+	if len(obj) != len(keys) {
+		return false
+	}
+	for _, key := range keys {
+		if _, ok := obj[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// inferColumnType derives a Pinot type for a column without a declared type from its values
+// Integers become LONG, other numbers DOUBLE and booleans BOOLEAN when consistent; anything else is a STRING
+func inferColumnType(rows [][]interface{}, colIdx int) string {
+	inferred := ""
+	for _, row := range rows {
+		if colIdx >= len(row) {
+			continue
+		}
+		var valueType string
+		switch v := row[colIdx].(type) {
+		case nil:
+			continue
+		case json.Number:
+			valueType = "DOUBLE"
+			if _, err := v.Int64(); err == nil {
+				valueType = "LONG"
+			}
+		case float64:
+			valueType = "DOUBLE"
+		case bool:
+			valueType = "BOOLEAN"
+		default:
+			return "STRING"
+		}
+		// Integer and fractional numbers together make a DOUBLE column
+		if inferred == "LONG" && valueType == "DOUBLE" || inferred == "DOUBLE" && valueType == "LONG" {
+			inferred = "DOUBLE"
+			continue
+		}
+		if inferred != "" && inferred != valueType {
+			return "STRING"
+		}
+		inferred = valueType
+	}
+	if inferred == "" {
+		return "STRING"
+	}
+	return inferred
+}
+
+// splitFrames splits every frame into frames of at most maxRows rows, in row order, so Grafana can
+// start rendering large results sooner. Splitting is disabled when maxRows is not positive.
+func splitFrames(frames data.Frames, maxRows int) data.Frames {
+	if maxRows <= 0 {
+		return frames
+	}
+
+	split := make(data.Frames, 0, len(frames))
+	for _, frame := range frames {
+		rows := frame.Rows()
+		if rows <= maxRows {
+			split = append(split, frame)
+			continue
+		}
+		for start := 0; start < rows; start += maxRows {
+			split = append(split, sliceFrame(frame, start, min(start+maxRows, rows)))
+		}
+	}
+	return split
+}
+
+// sliceFrame copies the rows [start, end) of the frame into a new frame with the same fields and meta
+func sliceFrame(frame *data.Frame, start, end int) *data.Frame {
+	chunk := data.NewFrame(frame.Name)
+	chunk.RefID = frame.RefID
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		chunk.Meta = &meta
+	}
+
+	for _, field := range frame.Fields {
+		chunkField := data.NewFieldFromFieldType(field.Type(), end-start)
+		chunkField.Name = field.Name
+		chunkField.Labels = field.Labels
+		chunkField.Config = field.Config
+		for idx := start; idx < end; idx++ {
+			chunkField.Set(idx-start, field.At(idx))
+		}
+		chunk.Fields = append(chunk.Fields, chunkField)
+	}
+	return chunk
+}
+
+// unrecognizedColumnTypes lists the columns whose declared type is not a known Pinot type
+func unrecognizedColumnTypes(schema DataSchema) []string {
+	var unknown []string
+	for idx, columnType := range schema.ColumnDataTypes {
+		if idx >= len(schema.ColumnNames) || columnType == "" {
+			continue
+		}
+		if _, ok := columnFieldTypes[strings.ToUpper(columnType)]; !ok {
+			unknown = append(unknown, fmt.Sprintf("%s (%s)", schema.ColumnNames[idx], columnType))
+		}
+	}
+	return unknown
+}
+
+// findTimeColumn returns the index of the timeseries time column, or -1 when there is none
+// An explicit column name wins; otherwise the first TIMESTAMP column is used
+func findTimeColumn(schema DataSchema, timeColumn string) int {
+	if timeColumn != "" {
+		for idx, name := range schema.ColumnNames {
+			if strings.EqualFold(name, timeColumn) {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	for idx, columnType := range schema.ColumnDataTypes {
+		if idx < len(schema.ColumnNames) && strings.EqualFold(columnType, "TIMESTAMP") {
+			return idx
+		}
+	}
+	return -1
+}
+
+// toTimeSeriesFrame moves the time field first and sorts the rows by ascending time
+// The remaining fields keep their projection order. The frame is typed as a long timeseries when
+// string fields remain, as a wide one otherwise
+func toTimeSeriesFrame(frame *data.Frame, timeColIdx int) *data.Frame {
+	fields := make([]*data.Field, 0, len(frame.Fields))
+	fields = append(fields, frame.Fields[timeColIdx])
+	for idx, field := range frame.Fields {
+		if idx != timeColIdx {
+			fields = append(fields, field)
+		}
+	}
+
+	timeField := fields[0]
+	order := make([]int, timeField.Len())
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := timeField.At(order[i]).(*time.Time), timeField.At(order[j]).(*time.Time)
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+
+	sorted := data.NewFrame(frame.Name)
+	sorted.RefID = frame.RefID
+	for _, field := range fields {
+		sortedField := data.NewFieldFromFieldType(field.Type(), field.Len())
+		sortedField.Name = field.Name
+		sortedField.Labels = field.Labels
+		sortedField.Config = field.Config
+		for newIdx, oldIdx := range order {
+			sortedField.Set(newIdx, field.At(oldIdx))
+		}
+		sorted.Fields = append(sorted.Fields, sortedField)
+	}
+	sorted.Meta = &data.FrameMeta{Type: data.FrameTypeTimeSeriesWide}
+	for _, field := range sorted.Fields[1:] {
+		// String columns are labels of the rows, which makes a long frame (see splitSeriesByLabel)
+		if field.Type() == data.FieldTypeNullableString || field.Type() == data.FieldTypeString {
+			sorted.Meta.Type = data.FrameTypeTimeSeriesLong
+			break
+		}
+	}
+
+	return sorted
+}
+
+// overrideColumnTypes maps the Grafana types of the datasource type overrides to the Pinot type
+// whose conversion produces them
+var overrideColumnTypes = map[string]string{
+	"time":    "TIMESTAMP",
+	"string":  "STRING",
+	"number":  "DOUBLE",
+	"boolean": "BOOLEAN",
+}
+
+// overriddenType returns the Pinot type a column is converted as: the type of its column name
+// override, else of its Pinot type override (both matched ignoring case, see lookupFold), else its Pinot type
+func overriddenType(name, columnType string, overrides map[string]string) string {
+	for _, key := range []string{name, strings.TrimSpace(columnType)} {
+		if key == "" {
+			continue
+		}
+		if grafanaType, ok := lookupFold(overrides, key); ok {
+			if pinotType, ok := overrideColumnTypes[strings.ToLower(grafanaType)]; ok {
+				return pinotType
+			}
+		}
+	}
+	return columnType
+}
+
+// lookupFold returns the value of the exact key, else of the first key in sorted order matching it
+// ignoring case, so keys differing only by case (e.g. Ts and ts) resolve the same way every time
+func lookupFold(values map[string]string, key string) (string, bool) {
+	if value, ok := values[key]; ok {
+		return value, true
+	}
+	keys := make([]string, 0, len(values))
+	for candidate := range values {
+		keys = append(keys, candidate)
+	}
+	sort.Strings(keys)
+	for _, candidate := range keys {
+		if strings.EqualFold(candidate, key) {
+			return values[candidate], true
+		}
+	}
+	return "", false
+}
+
+// validateTypeOverrides checks that the type overrides only target supported Grafana types
+func validateTypeOverrides(overrides map[string]string) error {
+	for key, grafanaType := range overrides {
+		if _, ok := overrideColumnTypes[strings.ToLower(grafanaType)]; !ok {
+			return fmt.Errorf("invalid type override %q for %q, expected time, string, number or boolean", grafanaType, key)
+		}
+	}
+	return nil
+}
+
+// createFieldForColumn creates a nullable field matching the Pinot column type
+func createFieldForColumn(name, columnType string, rowCount int) *data.Field {
+	fieldType, ok := columnFieldTypes[strings.ToUpper(strings.TrimSpace(columnType))]
+	if !ok {
+		fieldType = data.FieldTypeNullableString
+	}
+
+	field := data.NewFieldFromFieldType(fieldType, rowCount)
+	field.Name = name
+	return field
+}
+
+// setFieldValue converts a raw row value and stores it in the field
+// Values that cannot be converted are left as null and the conversion error is returned
+func setFieldValue(field *data.Field, rowIdx int, value interface{}, opts conversionOptions) error {
+	if value == nil {
+		return nil
+	}
+
+	var err error
+	switch field.Type() {
+	case data.FieldTypeNullableInt64:
+		var v int64
+		if v, err = convertToInt64(value); err == nil {
+			field.Set(rowIdx, &v)
+		}
+	case data.FieldTypeNullableFloat64:
+		var v float64
+		v, err = convertToFloat64(value)
+		if err != nil && opts.lenientNumbers {
+			v, err = parseLenientFloat(value)
+		}
+		if err == nil {
+			field.Set(rowIdx, &v)
+		}
+	case data.FieldTypeNullableBool:
+		var v bool
+		if v, err = convertToBool(value); err == nil {
+			field.Set(rowIdx, &v)
+		}
+	case data.FieldTypeNullableTime:
+		var v time.Time
+		if v, err = convertToTime(value, opts.location); err == nil {
+			field.Set(rowIdx, &v)
+		}
+	default:
+		v := convertToString(value)
+		field.Set(rowIdx, &v)
+	}
+
+	return err
+}
+
+// ============================================================================
+// CONVERSION - Schema Time Formats
+// ============================================================================
+
+// schemaTimeFormat is the format of a dateTime column declared in a Pinot table schema
+type schemaTimeFormat struct {
+	unit     time.Duration  // Epoch unit, e.g. time.Second for 1:SECONDS:EPOCH (0 for date patterns)
+	layout   string         // Go layout of a SIMPLE_DATE_FORMAT pattern
+	location *time.Location // Zone of a SIMPLE_DATE_FORMAT pattern (UTC when nil)
+}
+
+// schemaTimeUnits maps the Pinot time units to their duration
+var schemaTimeUnits = map[string]time.Duration{
+	"NANOSECONDS":  time.Nanosecond,
+	"MICROSECONDS": time.Microsecond,
+	"MILLISECONDS": time.Millisecond,
+	"SECONDS":      time.Second,
+	"MINUTES":      time.Minute,
+	"HOURS":        time.Hour,
+	"DAYS":         24 * time.Hour,
+}
+
+// parseSchemaTimeFormat parses a dateTime field spec format, either in the colon-separated form
+// (e.g. 1:SECONDS:EPOCH, 1:DAYS:SIMPLE_DATE_FORMAT:yyyyMMdd) or the pipe-separated one
+// (e.g. EPOCH|SECONDS|1, SIMPLE_DATE_FORMAT|yyyy-MM-dd|America/New_York). TIMESTAMP is epoch milliseconds
+func parseSchemaTimeFormat(format string) (schemaTimeFormat, error) {
+	var kind, unit, size, pattern, zone string
+	if strings.Contains(format, "|") {
+		parts := strings.Split(format, "|")
+		kind = parts[0]
+		switch strings.ToUpper(kind) {
+		case "EPOCH":
+			unit, size = "MILLISECONDS", "1"
+			if len(parts) > 1 {
+				unit = parts[1]
+			}
+			if len(parts) > 2 {
+				size = parts[2]
+			}
+		case "SIMPLE_DATE_FORMAT":
+			if len(parts) > 1 {
+				pattern = parts[1]
+			}
+			if len(parts) > 2 {
+				zone = parts[2]
+			}
+		}
+	} else if parts := strings.SplitN(format, ":", 4); len(parts) >= 3 {
+		size, unit, kind = parts[0], parts[1], parts[2]
+		if len(parts) == 4 {
+			pattern = parts[3]
+		}
+	} else {
+		kind = format
+	}
+
+	switch strings.ToUpper(strings.TrimSpace(kind)) {
+	case "TIMESTAMP":
+		return schemaTimeFormat{unit: time.Millisecond}, nil
+	case "EPOCH":
+		duration, ok := schemaTimeUnits[strings.ToUpper(strings.TrimSpace(unit))]
+		count, err := strconv.Atoi(strings.TrimSpace(size))
+		if !ok || err != nil || count <= 0 {
+			return schemaTimeFormat{}, fmt.Errorf("unsupported time format %q", format)
+		}
+		return schemaTimeFormat{unit: time.Duration(count) * duration}, nil
+	case "SIMPLE_DATE_FORMAT":
+		layout, err := javaDateLayout(pattern)
+		if err != nil {
+			return schemaTimeFormat{}, fmt.Errorf("unsupported time format %q: %w", format, err)
+		}
+		result := schemaTimeFormat{layout: layout}
+		if zone != "" {
+			if result.location, err = time.LoadLocation(zone); err != nil {
+				return schemaTimeFormat{}, fmt.Errorf("unsupported time format %q: %w", format, err)
+			}
+		}
+		return result, nil
+	default:
+		return schemaTimeFormat{}, fmt.Errorf("unsupported time format %q", format)
+	}
+}
+
+// javaDateTokenRegex matches the quoted literals, supported letter groups and any other letter of a Java date pattern
+var javaDateTokenRegex = regexp.MustCompile(`'[^']*'|yyyy|yy|MM|dd|HH|hh|mm|ss|SSS|XXX|[A-Za-z]`)
+
+// javaDateTokens maps the supported Java date pattern letters to their Go layout
+var javaDateTokens = map[string]string{
+	"yyyy": "2006",
+	"yy":   "06",
+	"MM":   "01",
+	"dd":   "02",
+	"HH":   "15",
+	"hh":   "03",
+	"a":    "PM",
+	"mm":   "04",
+	"ss":   "05",
+	"SSS":  "000",
+	"Z":    "-0700",
+	"XXX":  "Z07:00",
+}
+
+// javaDateLayout converts a Java SimpleDateFormat pattern (e.g. yyyy-MM-dd'T'HH:mm:ss) to a Go layout
+func javaDateLayout(pattern string) (string, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return "", fmt.Errorf("missing date pattern")
+	}
+
+	var unsupported error
+	layout := javaDateTokenRegex.ReplaceAllStringFunc(pattern, func(token string) string {
+		if token == "''" {
+			return "'" // Escaped quote
+		}
+		if strings.HasPrefix(token, "'") {
+			return strings.Trim(token, "'")
+		}
+		if layout, ok := javaDateTokens[token]; ok {
+			return layout
+		}
+		unsupported = fmt.Errorf("unsupported date pattern letters %q", token)
+		return token
+	})
+	return layout, unsupported
+}
+
+// parse converts a raw value of the column, an epoch count or a date string (or number, e.g. 20231114)
+func (f schemaTimeFormat) parse(value interface{}) (time.Time, error) {
+	if f.layout == "" {
+		count, err := convertToEpoch(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if f.unit >= time.Millisecond {
+			return time.UnixMilli(count * f.unit.Milliseconds()).UTC(), nil
+		}
+		return time.Unix(0, count*int64(f.unit)).UTC(), nil
+	}
+
+	location := f.location
+	if location == nil {
+		location = time.UTC
+	}
+	text := convertToString(value)
+	if number, ok := value.(float64); ok {
+		// JSON numbers such as 20231114 would otherwise be formatted in exponent notation
+		text = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	parsed, err := time.ParseInLocation(f.layout, text, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parsed.UTC(), nil
+}
+
+// convertColumn builds the time field of the column at colIdx, leaving unparsable values as nulls
+func (f schemaTimeFormat) convertColumn(name string, colIdx int, rows [][]interface{}) *data.Field {
+	field := data.NewFieldFromFieldType(data.FieldTypeNullableTime, len(rows))
+	field.Name = name
+	for rowIdx, row := range rows {
+		if colIdx >= len(row) || row[colIdx] == nil {
+			continue
+		}
+		v, err := f.parse(row[colIdx])
+		if err != nil {
+			backend.Logger.Warn("Failed to convert value", "field", name, "type", "TIMESTAMP", "error", err)
+			continue
+		}
+		field.Set(rowIdx, &v)
+	}
+	return field
+}
+
+// ============================================================================
+// CONVERSION - Execution Statistics
+// ============================================================================
+
+// statsFrame returns a single-row frame of the execution statistics of the response,
+// e.g. for dashboards tracking broker latency
+func statsFrame(refID string, pinotResp *PinotResponse) *data.Frame {
+	timeUsed := data.NewField("timeUsedMs", nil, []int64{pinotResp.TimeUsedMs})
+	timeUsed.Config = &data.FieldConfig{Unit: "ms"}
+
+	frame := data.NewFrame(refID,
+		timeUsed,
+		data.NewField("numDocsScanned", nil, []int64{pinotResp.NumDocsScanned}),
+		data.NewField("totalDocs", nil, []int64{pinotResp.TotalDocs}),
+		data.NewField("numSegmentsQueried", nil, []int64{pinotResp.NumSegmentsQueried}),
+	)
+	frame.RefID = refID
+	return frame
+}
+
+// ============================================================================
+// CONVERSION - Geospatial
+// ============================================================================
+
+// wktRegex matches a WKT geometry, such as the result of ST_AsText, optionally prefixed with an
+// EWKT SRID and carrying Z/M dimensions: it captures the geometry type and its coordinates
+var wktRegex = regexp.MustCompile(`(?is)^\s*(?:SRID=\d+;)?\s*(POINT|LINESTRING|POLYGON|MULTIPOINT|MULTILINESTRING|MULTIPOLYGON)\s*(?:ZM|Z|M)?\s*(\(.*\))\s*$`)
+
+// geoJSONTypes maps the WKT geometry types to their GeoJSON type and coordinates nesting
+var geoJSONTypes = map[string]struct {
+	name  string
+	depth int
+}{
+	"POINT":           {"Point", 0},
+	"LINESTRING":      {"LineString", 1},
+	"MULTIPOINT":      {"MultiPoint", 1},
+	"POLYGON":         {"Polygon", 2},
+	"MULTILINESTRING": {"MultiLineString", 2},
+	"MULTIPOLYGON":    {"MultiPolygon", 3},
+}
+
+// geoJSONGeometry is a GeoJSON geometry object
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// convertWKTColumn replaces the values of a string field with their GeoJSON geometry when every
+// non-null value is a WKT geometry, so the Geomap panel can read them
+// Other columns, including partially geometric ones, are left unchanged and false is returned
+func convertWKTColumn(field *data.Field) bool {
+	if field.Type() != data.FieldTypeNullableString {
+		return false
+	}
+
+	converted := make([]string, field.Len())
+	found := false
+	for rowIdx := range field.Len() {
+		value, ok := field.ConcreteAt(rowIdx)
+		if !ok {
+			continue
+		}
+		geoJSON, err := wktToGeoJSON(value.(string))
+		if err != nil {
+			return false
+		}
+		converted[rowIdx] = geoJSON
+		found = true
+	}
+	if !found {
+		return false
+	}
+
+	for rowIdx, geoJSON := range converted {
+		if _, ok := field.ConcreteAt(rowIdx); ok {
+			field.SetConcrete(rowIdx, geoJSON)
+		}
+	}
+	return true
+}
+
+// wktToGeoJSON converts a WKT geometry (e.g. POINT (-122.4 37.8)) to a GeoJSON geometry
+// Measures are dropped, as GeoJSON positions hold at most an elevation
+func wktToGeoJSON(wkt string) (string, error) {
+	match := wktRegex.FindStringSubmatch(wkt)
+	if match == nil {
+		return "", fmt.Errorf("not a WKT geometry: %q", wkt)
+	}
+	geometryType := geoJSONTypes[strings.ToUpper(match[1])]
+
+	coordinates, rest, err := parseWKTGroup(match[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid WKT geometry %q: %w", wkt, err)
+	}
+	if strings.TrimSpace(rest) != "" {
+		return "", fmt.Errorf("invalid WKT geometry %q: unexpected %q", wkt, rest)
+	}
+
+	var geometry interface{} = coordinates
+	switch strings.ToUpper(match[1]) {
+	case "POINT":
+		if len(coordinates) != 1 {
+			return "", fmt.Errorf("invalid WKT geometry %q: a point has a single position", wkt)
+		}
+		geometry = coordinates[0]
+	case "MULTIPOINT":
+		// Points may be written with or without their own parentheses, e.g. MULTIPOINT ((1 2), (3 4))
+		for i, point := range coordinates {
+			if group, ok := point.([]interface{}); ok && len(group) == 1 {
+				coordinates[i] = group[0]
+			}
+		}
+	}
+	if wktDepth(geometry) != geometryType.depth {
+		return "", fmt.Errorf("invalid WKT geometry %q: unexpected nesting of the coordinates", wkt)
+	}
+
+	b, err := json.Marshal(geoJSONGeometry{Type: geometryType.name, Coordinates: geometry})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// parseWKTGroup parses a parenthesized WKT list of positions or nested lists, returning the
+// remaining text. Positions become []float64 and lists []interface{}
+func parseWKTGroup(s string) ([]interface{}, string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "(") {
+		return nil, s, fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+
+	var items []interface{}
+	for {
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, "(") {
+			group, rest, err := parseWKTGroup(s)
+			if err != nil {
+				return nil, rest, err
+			}
+			items, s = append(items, group), rest
+		} else {
+			end := strings.IndexAny(s, ",)")
+			if end < 0 {
+				return nil, s, fmt.Errorf("unterminated list at %q", s)
+			}
+			position, err := parseWKTPosition(s[:end])
+			if err != nil {
+				return nil, s, err
+			}
+			items, s = append(items, position), s[end:]
+		}
+
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case strings.HasPrefix(s, ")"):
+			return items, s[1:], nil
+		default:
+			return nil, s, fmt.Errorf("expected , or ) at %q", s)
+		}
+	}
+}
+
+// parseWKTPosition parses the 2 to 4 space-separated ordinates of a WKT position, keeping the first 3
+func parseWKTPosition(s string) ([]float64, error) {
+	ordinates := strings.Fields(s)
+	if len(ordinates) < 2 || len(ordinates) > 4 {
+		return nil, fmt.Errorf("invalid position %q", strings.TrimSpace(s))
+	}
+
+	position := make([]float64, 0, 3)
+	for _, ordinate := range ordinates[:min(len(ordinates), 3)] {
+		v, err := strconv.ParseFloat(ordinate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid position %q", strings.TrimSpace(s))
+		}
+		position = append(position, v)
+	}
+	return position, nil
+}
+
+// wktDepth returns the number of list levels above the positions of parsed WKT coordinates
+// (0 for a single position), following the first element of each list
+func wktDepth(coordinates interface{}) int {
+	group, ok := coordinates.([]interface{})
+	if !ok {
+		return 0
+	}
+	if len(group) == 0 {
+		return 1
+	}
+	return wktDepth(group[0]) + 1
+}
+
+// ============================================================================
+// CONVERSION - Value Converters
+// ============================================================================
+
+// errSerializedValue reports a numeric column holding a nested value, such as a
+// serialized percentile estimate, instead of a plain number
+var errSerializedValue = errors.New("value is a serialized estimate, not a number")
+
+// errFractionalValue reports a value with a fractional part converted to an integer, which would
+// otherwise be silently truncated (e.g. 42.7 read as 42)
+var errFractionalValue = errors.New("value has a fractional part")
+
+// convertToInt64 converts a decoded JSON value to int64
+// Only integral values are accepted: 42 and "42.0" convert while 42.7 returns errFractionalValue
+func convertToInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to int64", v.String())
+		}
+		return convertToInt64(f)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("cannot convert %v to int64: %w", v, errFractionalValue)
+		}
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case string:
+		// Numbers extracted from JSON documents (e.g. JSON_EXTRACT_SCALAR(col, '$.x', 'INT')) may
+		// arrive as strings such as "42" or "42.0", converted like numeric JSON values
+		if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, fmt.Errorf("cannot convert %q to int64", v)
+		}
+		return convertToInt64(json.Number(strings.TrimSpace(v)))
+	case []interface{}, map[string]interface{}:
+		return 0, errSerializedValue
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int64", value)
+	}
+}
+
+// convertToEpoch converts a decoded JSON value to an epoch count, dropping the fraction of
+// non-integral values such as AVG(ts) results
+func convertToEpoch(value interface{}) (int64, error) {
+	epoch, err := convertToInt64(value)
+	if !errors.Is(err, errFractionalValue) {
+		return epoch, err
+	}
+	f, err := convertToFloat64(value)
+	if err != nil {
+		return 0, err
+	}
+	return int64(f), nil
+}
+
+// convertToFloat64 converts a decoded JSON value to float64
+func convertToFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to float64", v.String())
+		}
+		return f, nil
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %q to float64", v)
+		}
+		return f, nil
+	case []interface{}, map[string]interface{}:
+		return 0, errSerializedValue
+	default:
+		return 0, fmt.Errorf("cannot convert %T to float64", value)
+	}
+}
+
+// lenientNumberSymbols lists the grouping separators and currency symbols ignored by parseLenientFloat
+const lenientNumberSymbols = ",_ \u00a0$€£¥"
+
+// parseLenientFloat parses a number string after removing grouping separators and currency symbols
+// (e.g. "1,234.56" or "$99.9"); other values are rejected
+func parseLenientFloat(value interface{}) (float64, error) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %T to float64", value)
+	}
+
+	cleaned := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(lenientNumberSymbols, r) {
+			return -1
+		}
+		return r
+	}, s)
+	f, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || cleaned == "" {
+		return 0, fmt.Errorf("cannot convert %q to float64", s)
+	}
+	return f, nil
+}
+
+// convertToBool converts a decoded JSON value to bool
+func convertToBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case json.Number:
+		// Pinot may serialize BOOLEAN columns as 1/0
+		if i, err := v.Int64(); err == nil {
+			return i != 0, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return false, fmt.Errorf("cannot convert %q to bool", v)
+		}
+		return f != 0, nil
+	case float64:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case int:
+		return v != 0, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return false, fmt.Errorf("cannot convert %q to bool", v)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("cannot convert %T to bool", value)
+	}
+}
+
+// timeLayouts lists the string formats accepted for time values, with a T or space separator
+// (Pinot renders TIMESTAMP values as 2021-12-01 10:00:00.0). Fractional seconds of any precision
+// are accepted by every layout with seconds. Layouts without a zone are interpreted in the
+// configured location.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05 Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// convertToTime converts a decoded JSON value to time, always returned in UTC
+// Numeric values are interpreted as epoch timestamps, see epochToTime; time strings
+// without an explicit offset are read in loc (UTC when nil)
+func convertToTime(value interface{}, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	switch v := value.(type) {
+	case json.Number, float64, int64, int:
+		epoch, err := convertToEpoch(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return epochToTime(epoch).UTC(), nil
+	case string:
+		s := strings.TrimSpace(v)
+		if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return epochToTime(epoch).UTC(), nil
+		}
+		// Epochs cast to strings from a DOUBLE render with a fraction, e.g. "1700000000000.0"
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return epochToTime(int64(f)).UTC(), nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot convert %q to time", v)
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to time", value)
+	}
+}
+
+// Magnitude thresholds used to detect the precision of epoch values
+// Milliseconds stay below 1e14 until the year 5138, microseconds below 1e17 until the year 5138
+const (
+	epochMicrosThreshold = 1e14
+	epochNanosThreshold  = 1e17
+)
+
+// epochToTime converts an epoch value in milliseconds, microseconds or nanoseconds to time
+// The precision is inferred from the magnitude of the value
+func epochToTime(epoch int64) time.Time {
+	magnitude := epoch
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+
+	switch {
+	case magnitude >= epochNanosThreshold:
+		return time.Unix(0, epoch)
+	case magnitude >= epochMicrosThreshold:
+		return time.UnixMicro(epoch)
+	default:
+		return time.UnixMilli(epoch)
+	}
+}
+
+// convertToString converts a decoded JSON value to its string representation
+// Arrays and objects are rendered as JSON
+func convertToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case []interface{}, map[string]interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
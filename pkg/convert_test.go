@@ -0,0 +1,1271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+// Frame Conversion Tests
+// ============================================================================
+
+func TestConvertToDataFrames(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"name", "count", "avg", "active", "ts"},
+				ColumnDataTypes: []string{"STRING", "LONG", "DOUBLE", "BOOLEAN", "TIMESTAMP"},
+			},
+			Rows: [][]interface{}{
+				{"a", json.Number("1"), json.Number("1.5"), true, json.Number("1700000000000")},
+				{"b", nil, json.Number("2.5"), false, "2023-11-14 22:13:20"},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+
+	frame := frames[0]
+	require.Len(t, frame.Fields, 5)
+	assert.Equal(t, data.FieldTypeNullableString, frame.Fields[0].Type())
+	assert.Equal(t, data.FieldTypeNullableInt64, frame.Fields[1].Type())
+	assert.Equal(t, data.FieldTypeNullableFloat64, frame.Fields[2].Type())
+	assert.Equal(t, data.FieldTypeNullableBool, frame.Fields[3].Type())
+	assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[4].Type())
+
+	assert.Equal(t, "a", *frame.Fields[0].At(0).(*string))
+	assert.Equal(t, int64(1), *frame.Fields[1].At(0).(*int64))
+	assert.Nil(t, frame.Fields[1].At(1))
+	assert.Equal(t, 2.5, *frame.Fields[2].At(1).(*float64))
+	assert.False(t, *frame.Fields[3].At(1).(*bool))
+	assert.Equal(t, int64(1700000000000), frame.Fields[4].At(0).(*time.Time).UnixMilli())
+	assert.Equal(t, int64(1700000000000), frame.Fields[4].At(1).(*time.Time).UnixMilli())
+}
+
+func TestConvertToDataFrames_PinotTypeMetadata(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"ts", "carrier", "delay", "tags"},
+				ColumnDataTypes: []string{"LONG", "STRING", "DOUBLE", "STRING_ARRAY"},
+			},
+			Rows: [][]interface{}{{json.Number("1700000000000"), "AA", json.Number("1.5"), []interface{}{"x"}}},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTimeSeries, TimeColumn: "ts"}, conversionOptions{})
+	require.NoError(t, err)
+
+	expected := map[string]string{"ts": "LONG", "carrier": "STRING", "delay": "DOUBLE", "tags": "STRING_ARRAY"}
+	for _, field := range frames[0].Fields {
+		require.NotNil(t, field.Config, field.Name)
+		assert.Equal(t, expected[field.Name], field.Config.Custom["pinotType"], field.Name)
+	}
+
+	// The time column keeps its declared type even though it is rendered as time
+	assert.Equal(t, data.FieldTypeNullableTime, frames[0].Fields[0].Type())
+}
+
+func TestConvertToDataFrames_FieldOrder(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"zone", "count", "ts", "avg", "broken", "carrier"},
+				ColumnDataTypes: []string{"STRING", "LONG", "TIMESTAMP", "DOUBLE", "DOUBLE", "STRING"},
+			},
+			Rows: [][]interface{}{
+				{"eu", json.Number("2"), json.Number("1700000060000"), json.Number("1.5"), "n/a", "AA"},
+				{"us", json.Number("1"), json.Number("1700000000000"), json.Number("2.5"), json.Number("3"), "DL"},
+			},
+		},
+	}
+
+	fieldNames := func(frame *data.Frame) []string {
+		names := make([]string, 0, len(frame.Fields))
+		for _, field := range frame.Fields {
+			names = append(names, field.Name)
+		}
+		return names
+	}
+
+	t.Run("table fields match the projection", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTable}, conversionOptions{})
+		require.NoError(t, err)
+
+		// Neither the TIMESTAMP column nor the promoted string column is moved
+		assert.Equal(t, []string{"zone", "count", "ts", "avg", "broken", "carrier"}, fieldNames(frames[0]))
+	})
+
+	t.Run("timeseries moves only the time field first", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTimeSeries}, conversionOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"ts", "zone", "count", "avg", "broken", "carrier"}, fieldNames(frames[0]))
+	})
+}
+
+func TestConvertToDataFrames_ColumnAliases(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"ts", "cnt", "carrier"},
+				ColumnDataTypes: []string{"LONG", "LONG", "STRING"},
+			},
+			Rows: [][]interface{}{{json.Number("1700000000000"), json.Number("3"), "AA"}},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{
+		Format:        FormatTimeSeries,
+		TimeColumn:    "ts",
+		KeepRawTime:   true,
+		ColumnAliases: map[string]string{"CNT": "Count", "ts": "Time", "missing": "Ignored"},
+	}, conversionOptions{})
+	require.NoError(t, err)
+
+	fields := map[string]*data.Field{}
+	for _, field := range frames[0].Fields {
+		fields[field.Name] = field
+	}
+
+	// Field names stay the SQL columns while the display names use the aliases
+	require.Contains(t, fields, "cnt")
+	assert.Equal(t, "Count", fields["cnt"].Config.DisplayName)
+	assert.Equal(t, "LONG", fields["cnt"].Config.Custom["pinotType"])
+	assert.Equal(t, "Time", fields["ts"].Config.DisplayName)
+
+	// The raw time field keeps its own config
+	assert.Equal(t, "", fields["ts_raw"].Config.DisplayName)
+	assert.Equal(t, "", fields["carrier"].Config.DisplayName)
+}
+
+func TestConvertToDataFrames_StringColumns(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"ts", "userId", "clicks"},
+				ColumnDataTypes: []string{"LONG", "LONG", "LONG"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("1700000000000"), json.Number("9007199254740993"), json.Number("3")},
+				{json.Number("1700000060000"), nil, json.Number("5")},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{
+		Format:        FormatTimeSeries,
+		TimeColumn:    "ts",
+		StringColumns: []string{"USERID", "ts"},
+	}, conversionOptions{})
+	require.NoError(t, err)
+
+	frame := frames[0]
+	// The time column of a timeseries is never forced to string
+	assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[0].Type())
+
+	userID := frame.Fields[1]
+	require.Equal(t, data.FieldTypeNullableString, userID.Type())
+	assert.Equal(t, "9007199254740993", *userID.At(0).(*string))
+	assert.Nil(t, userID.At(1))
+	assert.Equal(t, "LONG", userID.Config.Custom["pinotType"])
+
+	assert.Equal(t, data.FieldTypeNullableInt64, frame.Fields[2].Type())
+}
+
+func TestReduceToLast_LabelColumns(t *testing.T) {
+	first, last := time.UnixMilli(1700000000000), time.UnixMilli(1700000060000)
+	web1, web2, cpu := "web-1", "web-2", 0.25
+	frame := data.NewFrame("A",
+		data.NewField("ts", nil, []*time.Time{&first, &last}),
+		data.NewField("host", nil, []*string{&web1, &web2}),
+		data.NewField("cpu", nil, []*float64{&cpu, nil}),
+	)
+
+	// The whole last row is kept, so the values stay paired with their time and label
+	frames := reduceToLast(frame)
+	require.Len(t, frames, 1)
+	require.Equal(t, 1, frames[0].Rows())
+	assert.Equal(t, int64(1700000060000), frames[0].Fields[0].At(0).(*time.Time).UnixMilli())
+	assert.Equal(t, "web-2", *frames[0].Fields[1].At(0).(*string))
+	assert.Nil(t, frames[0].Fields[2].At(0))
+}
+
+func TestConvertToDataFrames_StringColumnPattern(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"order_id", "customer_id", "id_prefix", "amount"},
+				ColumnDataTypes: []string{"LONG", "INT", "LONG", "DOUBLE"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("9007199254740993"), json.Number("42"), json.Number("7"), json.Number("12.5")},
+				{nil, json.Number("43"), json.Number("8"), json.Number("3")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pattern  *regexp.Regexp
+		expected []data.FieldType
+	}{
+		{
+			name:     "converts matching columns as strings",
+			pattern:  regexp.MustCompile(`.*_id$`),
+			expected: []data.FieldType{data.FieldTypeNullableString, data.FieldTypeNullableString, data.FieldTypeNullableInt64, data.FieldTypeNullableFloat64},
+		},
+		{
+			name:     "keeps the declared types without a pattern",
+			expected: []data.FieldType{data.FieldTypeNullableInt64, data.FieldTypeNullableInt64, data.FieldTypeNullableInt64, data.FieldTypeNullableFloat64},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{stringColumns: tt.pattern})
+			require.NoError(t, err)
+
+			frame := frames[0]
+			for idx, fieldType := range tt.expected {
+				assert.Equal(t, fieldType, frame.Fields[idx].Type(), frame.Fields[idx].Name)
+			}
+			if tt.pattern != nil {
+				// Large IDs keep every digit instead of going through float64
+				assert.Equal(t, "9007199254740993", *frame.Fields[0].At(0).(*string))
+				assert.Nil(t, frame.Fields[0].At(1))
+				assert.Equal(t, "LONG", frame.Fields[0].Config.Custom["pinotType"])
+			}
+		})
+	}
+}
+
+func TestConvertToDataFrames_ExpandObject(t *testing.T) {
+	newResponse := func(rows ...interface{}) *PinotResponse {
+		resp := &PinotResponse{
+			ResultTable: &ResultTable{
+				DataSchema: DataSchema{
+					ColumnNames:     []string{"payload"},
+					ColumnDataTypes: []string{"JSON"},
+				},
+			},
+		}
+		for _, row := range rows {
+			resp.ResultTable.Rows = append(resp.ResultTable.Rows, []interface{}{row})
+		}
+		return resp
+	}
+
+	t.Run("expands uniform objects into fields", func(t *testing.T) {
+		pinotResp := newResponse(
+			map[string]interface{}{"carrier": "AA", "flights": json.Number("10"), "active": true},
+			map[string]interface{}{"carrier": "DL", "flights": json.Number("20"), "active": false},
+			nil,
+		)
+
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{ExpandObject: true}, conversionOptions{})
+		require.NoError(t, err)
+		require.Len(t, frames, 1)
+
+		frame := frames[0]
+		require.Len(t, frame.Fields, 3)
+		assert.Equal(t, "active", frame.Fields[0].Name)
+		assert.Equal(t, "carrier", frame.Fields[1].Name)
+		assert.Equal(t, "flights", frame.Fields[2].Name)
+		assert.Equal(t, data.FieldTypeNullableBool, frame.Fields[0].Type())
+		assert.Equal(t, data.FieldTypeNullableString, frame.Fields[1].Type())
+		assert.Equal(t, data.FieldTypeNullableFloat64, frame.Fields[2].Type())
+		assert.Equal(t, 3, frame.Rows())
+		assert.Equal(t, "DL", *frame.Fields[1].At(1).(*string))
+		assert.Equal(t, 20.0, *frame.Fields[2].At(1).(*float64))
+		assert.Nil(t, frame.Fields[1].At(2))
+	})
+
+	t.Run("keeps JSON strings when keys vary", func(t *testing.T) {
+		pinotResp := newResponse(
+			map[string]interface{}{"carrier": "AA"},
+			map[string]interface{}{"origin": "JFK"},
+		)
+
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{ExpandObject: true}, conversionOptions{})
+		require.NoError(t, err)
+		require.Len(t, frames[0].Fields, 1)
+		assert.Equal(t, "payload", frames[0].Fields[0].Name)
+		assert.Equal(t, `{"carrier":"AA"}`, *frames[0].Fields[0].At(0).(*string))
+	})
+
+	t.Run("keeps objects when the option is off", func(t *testing.T) {
+		pinotResp := newResponse(map[string]interface{}{"carrier": "AA"})
+
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+		require.NoError(t, err)
+		require.Len(t, frames[0].Fields, 1)
+		assert.Equal(t, "payload", frames[0].Fields[0].Name)
+	})
+}
+
+func TestCreateFieldForColumn(t *testing.T) {
+	tests := []struct {
+		columnType string
+		expected   data.FieldType
+	}{
+		{"INT", data.FieldTypeNullableInt64},
+		{"LONG", data.FieldTypeNullableInt64},
+		{"FLOAT", data.FieldTypeNullableFloat64},
+		{"DOUBLE", data.FieldTypeNullableFloat64},
+		{"BIG_DECIMAL", data.FieldTypeNullableFloat64},
+		{"BOOLEAN", data.FieldTypeNullableBool},
+		{"TIMESTAMP", data.FieldTypeNullableTime},
+		{"timestamp", data.FieldTypeNullableTime},
+		{"STRING", data.FieldTypeNullableString},
+		{"JSON", data.FieldTypeNullableString},
+		{"BYTES", data.FieldTypeNullableString},
+		{"INT_ARRAY", data.FieldTypeNullableString},
+		{"LONG_ARRAY", data.FieldTypeNullableString},
+		{"TIMESTAMP_ARRAY", data.FieldTypeNullableString},
+		{"OBJECT", data.FieldTypeNullableString},
+		{"BIG_INT", data.FieldTypeNullableString},
+		{"POINT", data.FieldTypeNullableString},
+		{"", data.FieldTypeNullableString},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.columnType, func(t *testing.T) {
+			field := createFieldForColumn("col", tt.columnType, 2)
+			assert.Equal(t, "col", field.Name)
+			assert.Equal(t, tt.expected, field.Type())
+			assert.Equal(t, 2, field.Len())
+		})
+	}
+
+	// Every recognized type is covered by the mapping used for the fields
+	for columnType, fieldType := range columnFieldTypes {
+		assert.Equal(t, fieldType, createFieldForColumn("col", columnType, 0).Type(), columnType)
+		assert.True(t, fieldType.Nullable(), columnType)
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	rows := make([][]interface{}, 25)
+	for idx := range rows {
+		rows[idx] = []interface{}{json.Number(strconv.Itoa(1700000000000 + idx*1000)), json.Number(strconv.Itoa(idx))}
+	}
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"ts", "value"},
+				ColumnDataTypes: []string{"TIMESTAMP", "LONG"},
+			},
+			Rows: rows,
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTimeSeries}, conversionOptions{})
+	require.NoError(t, err)
+	original := frames[0]
+
+	split := splitFrames(frames, 10)
+	require.Len(t, split, 3)
+	assert.Equal(t, 10, split[0].Rows())
+	assert.Equal(t, 10, split[1].Rows())
+	assert.Equal(t, 5, split[2].Rows())
+
+	// Concatenating the chunks gives back the original frame
+	var concatenated [][]interface{}
+	for _, chunk := range split {
+		assert.Equal(t, "A", chunk.RefID)
+		assert.Equal(t, data.FrameTypeTimeSeriesWide, chunk.Meta.Type)
+		assert.Equal(t, original.Fields[1].Config, chunk.Fields[1].Config)
+		for rowIdx := 0; rowIdx < chunk.Rows(); rowIdx++ {
+			concatenated = append(concatenated, chunk.RowCopy(rowIdx))
+		}
+	}
+	require.Len(t, concatenated, original.Rows())
+	for rowIdx, row := range concatenated {
+		assert.Equal(t, original.RowCopy(rowIdx), row)
+	}
+
+	// Frames within the limit, or without a limit, are kept as is
+	assert.Same(t, original, splitFrames(frames, 25)[0])
+	assert.Same(t, original, splitFrames(frames, 0)[0])
+}
+
+func TestConvertToDataFrames_MissingColumnTypes(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames: []string{"carrier", "flights", "delay", "cancelled", "empty"},
+			},
+			Rows: [][]interface{}{
+				{"AA", nil, json.Number("1"), true, nil},
+				{"DL", json.Number("20"), json.Number("2.5"), false, nil},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{strictTypes: true})
+	require.NoError(t, err)
+
+	fields := frames[0].Fields
+	require.Len(t, fields, 5)
+	assert.Equal(t, data.FieldTypeNullableString, fields[0].Type())
+	assert.Equal(t, data.FieldTypeNullableInt64, fields[1].Type())
+	assert.Equal(t, int64(20), *fields[1].At(1).(*int64))
+	assert.Equal(t, data.FieldTypeNullableFloat64, fields[2].Type())
+	assert.Equal(t, 1.0, *fields[2].At(0).(*float64))
+	assert.Equal(t, data.FieldTypeNullableBool, fields[3].Type())
+	assert.Equal(t, data.FieldTypeNullableString, fields[4].Type())
+
+	// The inferred types are not reported as declared Pinot types
+	for _, field := range fields {
+		assert.NotContains(t, field.Config.Custom, "pinotType", field.Name)
+	}
+	assert.Equal(t, "LONG", fields[1].Config.Custom["inferredType"])
+	assert.Equal(t, "DOUBLE", fields[2].Config.Custom["inferredType"])
+
+	// The response itself is left untouched
+	assert.Nil(t, pinotResp.ResultTable.DataSchema.ColumnDataTypes)
+}
+
+func TestConvertToDataFrames_NoResultTable(t *testing.T) {
+	frames, err := convertToDataFrames("A", &PinotResponse{}, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Empty(t, frames[0].Fields)
+}
+
+func TestConvertToDataFrames_MalformedRows(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"name", "count"},
+				ColumnDataTypes: []string{"STRING", "LONG"},
+			},
+			Rows: [][]interface{}{
+				{"a", json.Number("1")},
+				{"b"},
+				nil,
+				{"c", json.Number("3"), "extra"},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	require.Len(t, frames[0].Fields, 2)
+	assert.Equal(t, 4, frames[0].Rows())
+	assert.Nil(t, frames[0].Fields[1].At(1))
+	assert.Nil(t, frames[0].Fields[0].At(2))
+	assert.Equal(t, int64(3), *frames[0].Fields[1].At(3).(*int64))
+}
+
+func TestConvertToString(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"string", "hello", "hello"},
+		{"number", json.Number("42"), "42"},
+		{"bool", true, "true"},
+		{"array", []interface{}{json.Number("1"), json.Number("2")}, "[1,2]"},
+		{"object", map[string]interface{}{"k": "v"}, `{"k":"v"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, convertToString(tt.value))
+		})
+	}
+}
+
+func TestConvertToBool(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       interface{}
+		expected    bool
+		expectError bool
+	}{
+		{"bool", true, true, false},
+		{"numeric true", json.Number("1"), true, false},
+		{"numeric false", json.Number("0"), false, false},
+		{"numeric float", json.Number("1.0"), true, false},
+		{"invalid number", json.Number("yes"), false, true},
+		{"string", "false", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := convertToBool(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, b)
+		})
+	}
+}
+
+func TestConvertToDataFrames_HeterogeneousColumn(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"ts", "delay", "flights"},
+				ColumnDataTypes: []string{"TIMESTAMP", "DOUBLE", "LONG"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("1700000000000"), json.Number("1"), json.Number("10")},
+				{json.Number("1700000060000"), "n/a", json.Number("20")},
+				{"not a time", json.Number("2.5"), nil},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTimeSeries, TimeColumn: "ts"}, conversionOptions{})
+	require.NoError(t, err)
+	fields := frames[0].Fields
+
+	// The mixed column is promoted to strings, keeping every value and its declared type
+	assert.Equal(t, data.FieldTypeNullableString, fields[1].Type())
+	assert.Equal(t, "1", *fields[1].At(0).(*string))
+	assert.Equal(t, "n/a", *fields[1].At(1).(*string))
+	assert.Equal(t, "2.5", *fields[1].At(2).(*string))
+	assert.Equal(t, "DOUBLE", fields[1].Config.Custom["pinotType"])
+
+	// Homogeneous columns keep their type
+	assert.Equal(t, data.FieldTypeNullableInt64, fields[2].Type())
+	assert.Nil(t, fields[2].At(2))
+
+	// The timeseries time column is never promoted, unparsable values stay null
+	assert.Equal(t, data.FieldTypeNullableTime, fields[0].Type())
+	assert.Nil(t, fields[0].At(2))
+}
+
+func TestConvertToDataFrames_JSONExtractScalar(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"jsonextractscalar(payload,'$.count','INT')", "jsonextractscalar(payload,'$.ratio','DOUBLE')"},
+				ColumnDataTypes: []string{"INT", "DOUBLE"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("42"), json.Number("0.5")},
+				{"7", "0.25"},
+				{"8.0", "1e-1"},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	fields := frames[0].Fields
+
+	// The declared types drive the fields, whether the values arrive as numbers or numeric strings
+	require.Equal(t, data.FieldTypeNullableInt64, fields[0].Type())
+	assert.Equal(t, int64(42), *fields[0].At(0).(*int64))
+	assert.Equal(t, int64(7), *fields[0].At(1).(*int64))
+	assert.Equal(t, int64(8), *fields[0].At(2).(*int64))
+
+	require.Equal(t, data.FieldTypeNullableFloat64, fields[1].Type())
+	assert.Equal(t, 0.25, *fields[1].At(1).(*float64))
+	assert.Equal(t, 0.1, *fields[1].At(2).(*float64))
+}
+
+func TestConvertToDataFrames_FractionalIntegers(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "json number", value: json.Number("42.7")},
+		{name: "numeric string", value: "42.7"},
+		{name: "float", value: 42.7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinotResp := &PinotResponse{
+				ResultTable: &ResultTable{
+					DataSchema: DataSchema{ColumnNames: []string{"count"}, ColumnDataTypes: []string{"LONG"}},
+					Rows:       [][]interface{}{{json.Number("7")}, {tt.value}},
+				},
+			}
+
+			frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+			require.NoError(t, err)
+
+			// The fraction is kept rather than truncated to 42
+			field := frames[0].Fields[0]
+			require.Equal(t, data.FieldTypeNullableFloat64, field.Type())
+			assert.Equal(t, 7.0, *field.At(0).(*float64))
+			assert.Equal(t, 42.7, *field.At(1).(*float64))
+			assert.Equal(t, "LONG", field.Config.Custom["pinotType"])
+		})
+	}
+
+	_, err := convertToInt64(json.Number("42.7"))
+	assert.ErrorIs(t, err, errFractionalValue)
+
+	// Epochs keep converting, dropping the fraction
+	result, err := convertToTime(json.Number("1700000000000.7"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1700000000000), result.UnixMilli())
+}
+
+func TestConvertToDataFrames_MaxColumns(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"a", "b", "c", "ts", "e"},
+				ColumnDataTypes: []string{"INT", "INT", "INT", "TIMESTAMP", "INT"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("1"), json.Number("2"), json.Number("3"), json.Number("1700000000000"), json.Number("5")},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		qm       QueryModel
+		max      int
+		expected []string
+		notice   string
+	}{
+		{
+			name:     "drops the columns beyond the cap",
+			max:      2,
+			expected: []string{"a", "b"},
+			notice:   "3 columns were dropped, showing the first 2 columns",
+		},
+		{
+			name:     "keeps the timeseries time field",
+			qm:       QueryModel{Format: FormatTimeSeries, TimeColumn: "ts"},
+			max:      2,
+			expected: []string{"ts", "a"},
+			notice:   "3 columns were dropped",
+		},
+		{
+			name:     "keeps every column under the cap",
+			max:      5,
+			expected: []string{"a", "b", "c", "ts", "e"},
+		},
+		{
+			name:     "keeps every column without a cap",
+			expected: []string{"a", "b", "c", "ts", "e"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := convertToDataFrames("A", pinotResp, tt.qm, conversionOptions{maxColumns: tt.max})
+			require.NoError(t, err)
+			require.Len(t, frames, 1)
+
+			names := make([]string, 0, len(frames[0].Fields))
+			for _, field := range frames[0].Fields {
+				names = append(names, field.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+
+			if tt.notice == "" {
+				assert.Nil(t, frames[0].Meta)
+				return
+			}
+			require.NotNil(t, frames[0].Meta)
+			require.Len(t, frames[0].Meta.Notices, 1)
+			assert.Equal(t, data.NoticeSeverityWarning, frames[0].Meta.Notices[0].Severity)
+			assert.Contains(t, frames[0].Meta.Notices[0].Text, tt.notice)
+		})
+	}
+}
+
+func TestConvertToDataFrames_NumericBooleans(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"cancelled"},
+				ColumnDataTypes: []string{"BOOLEAN"},
+			},
+			Rows: [][]interface{}{{json.Number("1")}, {json.Number("0")}},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, true, *frames[0].Fields[0].At(0).(*bool))
+	assert.Equal(t, false, *frames[0].Fields[0].At(1).(*bool))
+}
+
+func TestConvertToTime_EpochPrecision(t *testing.T) {
+	expected := time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected time.Time
+	}{
+		{"epoch milliseconds", json.Number("1700000000123"), expected.Truncate(time.Millisecond)},
+		{"epoch microseconds", json.Number("1700000000123456"), expected.Truncate(time.Microsecond)},
+		{"epoch nanoseconds", json.Number("1700000000123456789"), expected},
+		{"epoch microseconds as string", "1700000000123456", expected.Truncate(time.Microsecond)},
+		{"epoch nanoseconds as string", "1700000000123456789", expected},
+		{"epoch milliseconds as float", float64(1700000000123), expected.Truncate(time.Millisecond)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := convertToTime(tt.value, nil)
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(result), "expected %v, got %v", tt.expected, result)
+		})
+	}
+}
+
+func TestConvertToTime_StringLayouts(t *testing.T) {
+	base := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+	}{
+		{"pinot timestamp with tenths", "2021-12-01 10:00:00.0", base},
+		{"space separated milliseconds", "2021-12-01 10:00:00.123", base.Add(123 * time.Millisecond)},
+		{"space separated microseconds", "2021-12-01 10:00:00.123456", base.Add(123456 * time.Microsecond)},
+		{"space separated nanoseconds", "2021-12-01 10:00:00.123456789", base.Add(123456789)},
+		{"space separated without fraction", "2021-12-01 10:00:00", base},
+		{"space separated minutes", "2021-12-01 10:00", base},
+		{"T separated without zone", "2021-12-01T10:00:00", base},
+		{"T separated microseconds without zone", "2021-12-01T10:00:00.123456", base.Add(123456 * time.Microsecond)},
+		{"T separated minutes", "2021-12-01T10:00", base},
+		{"T separated with Z", "2021-12-01T10:00:00.5Z", base.Add(500 * time.Millisecond)},
+		{"T separated with compact offset", "2021-12-01T11:00:00+0100", base},
+		{"space separated with offset", "2021-12-01 11:00:00.25+01:00", base.Add(250 * time.Millisecond)},
+		{"space separated with compact offset", "2021-12-01 11:00:00+0100", base},
+		{"space separated with spaced offset", "2021-12-01 11:00:00 +01:00", base},
+		{"date only", "2021-12-01", base.Truncate(24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := convertToTime(tt.value, nil)
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(result), "expected %v, got %v", tt.expected, result)
+		})
+	}
+
+	t.Run("TIMESTAMP column parses mixed layouts", func(t *testing.T) {
+		pinotResp := &PinotResponse{
+			ResultTable: &ResultTable{
+				DataSchema: DataSchema{ColumnNames: []string{"ts"}, ColumnDataTypes: []string{"TIMESTAMP"}},
+				Rows: [][]interface{}{
+					{"2021-12-01 10:00:00.0"},
+					{"2021-12-01T10:00:00.123456"},
+					{"2021-12-01 10:00:00.123456789"},
+				},
+			},
+		}
+
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+		require.NoError(t, err)
+
+		field := frames[0].Fields[0]
+		require.Equal(t, data.FieldTypeNullableTime, field.Type())
+		assert.Equal(t, base, *field.At(0).(*time.Time))
+		assert.Equal(t, base.Add(123456*time.Microsecond), *field.At(1).(*time.Time))
+		assert.Equal(t, base.Add(123456789), *field.At(2).(*time.Time))
+	})
+
+	_, err := convertToTime("01/12/2021 10:00", nil)
+	assert.Error(t, err)
+}
+
+func TestConvertToDataFrames_TimestampValues(t *testing.T) {
+	expected := time.UnixMilli(1700000000000).UTC()
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected *time.Time
+	}{
+		{name: "json number epoch millis", value: json.Number("1700000000000"), expected: &expected},
+		{name: "int64 epoch millis", value: int64(1700000000000), expected: &expected},
+		{name: "float64 epoch millis", value: float64(1700000000000), expected: &expected},
+		{name: "string epoch millis", value: "1700000000000", expected: &expected},
+		{name: "string epoch millis with spaces", value: " 1700000000000 ", expected: &expected},
+		{name: "string epoch millis with fraction", value: "1700000000000.0", expected: &expected},
+		{name: "string datetime", value: "2023-11-14 22:13:20.0", expected: &expected},
+		{name: "unparsable string stays null", value: "not a time"},
+		{name: "null", value: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinotResp := &PinotResponse{
+				ResultTable: &ResultTable{
+					DataSchema: DataSchema{ColumnNames: []string{"ts", "value"}, ColumnDataTypes: []string{"TIMESTAMP", "LONG"}},
+					Rows: [][]interface{}{
+						{json.Number("1700000000000"), json.Number("1")},
+						{tt.value, json.Number("2")},
+					},
+				},
+			}
+
+			// A table query, the column is not forced to time as a timeseries time column
+			frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTable}, conversionOptions{})
+			require.NoError(t, err)
+
+			field := frames[0].Fields[0]
+			require.Equal(t, data.FieldTypeNullableTime, field.Type())
+			assert.Equal(t, "TIMESTAMP", field.Config.Custom["pinotType"])
+			if tt.expected == nil {
+				assert.Nil(t, field.At(1))
+				return
+			}
+			require.NotNil(t, field.At(1))
+			assert.True(t, tt.expected.Equal(*field.At(1).(*time.Time)), "got %v", field.At(1))
+		})
+	}
+}
+
+func TestConvertToDataFrames_LenientNumbers(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{ColumnNames: []string{"revenue"}, ColumnDataTypes: []string{"DOUBLE"}},
+			Rows: [][]interface{}{
+				{"1,234.56"},
+				{"$99.9"},
+				{"-€1 000.5"},
+				{json.Number("12.5")},
+			},
+		},
+	}
+
+	t.Run("strict parsing promotes the column to string", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, data.FieldTypeNullableString, frames[0].Fields[0].Type())
+	})
+
+	t.Run("lenient parsing strips separators and symbols", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{lenientNumbers: true})
+		require.NoError(t, err)
+
+		field := frames[0].Fields[0]
+		require.Equal(t, data.FieldTypeNullableFloat64, field.Type())
+		assert.Equal(t, 1234.56, *field.At(0).(*float64))
+		assert.Equal(t, 99.9, *field.At(1).(*float64))
+		assert.Equal(t, -1000.5, *field.At(2).(*float64))
+		assert.Equal(t, 12.5, *field.At(3).(*float64))
+	})
+}
+
+func TestConvertToDataFrames_NullSentinels(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"intDim", "longDim", "doubleDim", "longMetric"},
+				ColumnDataTypes: []string{"INT", "LONG", "DOUBLE", "LONG"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("-2147483648"), json.Number("-9223372036854775808"), "-Infinity", json.Number("-2147483648")},
+				{json.Number("7"), json.Number("8"), json.Number("1.5"), json.Number("9")},
+			},
+		},
+	}
+
+	t.Run("sentinels are kept by default", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+		require.NoError(t, err)
+
+		fields := frames[0].Fields
+		assert.Equal(t, int64(math.MinInt32), *fields[0].At(0).(*int64))
+		assert.Equal(t, int64(math.MinInt64), *fields[1].At(0).(*int64))
+		assert.True(t, math.IsInf(*fields[2].At(0).(*float64), -1))
+	})
+
+	t.Run("sentinels become nulls", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{nullSentinels: true})
+		require.NoError(t, err)
+
+		fields := frames[0].Fields
+		assert.Nil(t, fields[0].At(0))
+		assert.Nil(t, fields[1].At(0))
+		assert.Nil(t, fields[2].At(0))
+		// The INT sentinel is a regular value of a LONG column
+		assert.Equal(t, int64(math.MinInt32), *fields[3].At(0).(*int64))
+
+		assert.Equal(t, int64(7), *fields[0].At(1).(*int64))
+		assert.Equal(t, int64(8), *fields[1].At(1).(*int64))
+		assert.Equal(t, 1.5, *fields[2].At(1).(*float64))
+	})
+}
+
+func TestParseLenientFloat(t *testing.T) {
+	tests := []struct {
+		value       interface{}
+		expected    float64
+		expectError bool
+	}{
+		{"1,234.56", 1234.56, false},
+		{"$99.9", 99.9, false},
+		{" £1,000 ", 1000, false},
+		{"1_000_000", 1000000, false},
+		{"n/a", 0, true},
+		{"$", 0, true},
+		{"12.5%", 0, true},
+		{true, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.value), func(t *testing.T) {
+			result, err := parseLenientFloat(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestConvertToDataFrames_DistinctCountColumns(t *testing.T) {
+	tests := []struct {
+		name      string
+		column    string
+		typ       string
+		value     interface{}
+		fieldType data.FieldType
+		expected  interface{}
+	}{
+		{"distinct count as number", "distinctcount(user)", "INT", json.Number("42"), data.FieldTypeNullableInt64, int64(42)},
+		{"distinct count as string", "distinctcount(user)", "INT", "42", data.FieldTypeNullableInt64, int64(42)},
+		{"HLL distinct count as string", "distinctcounthll(user)", "LONG", "1024", data.FieldTypeNullableInt64, int64(1024)},
+		{"raw HLL sketch declared as bytes", "distinctcountrawhll(user)", "BYTES", "0000000e000000000000", data.FieldTypeNullableString, "0000000e000000000000"},
+		{"raw HLL sketch declared as long", "distinctcountrawhll(user)", "LONG", "00000010", data.FieldTypeNullableString, "00000010"},
+		{"raw HLL sketch with digits only", "DISTINCTCOUNTRAWHLL(user)", "STRING", "00000010", data.FieldTypeNullableString, "00000010"},
+		{"raw theta sketch", "distinctcountrawthetasketch(user)", "OBJECT", "AQMDAAAazJM=", data.FieldTypeNullableString, "AQMDAAAazJM="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinotResp := &PinotResponse{
+				ResultTable: &ResultTable{
+					DataSchema: DataSchema{ColumnNames: []string{tt.column}, ColumnDataTypes: []string{tt.typ}},
+					Rows:       [][]interface{}{{tt.value}},
+				},
+			}
+
+			frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+			require.NoError(t, err)
+
+			field := frames[0].Fields[0]
+			require.Equal(t, tt.fieldType, field.Type())
+			value, ok := field.ConcreteAt(0)
+			require.True(t, ok)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+
+	// Without declared types a digits-only sketch would otherwise be inferred as a number
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{ColumnNames: []string{"distinctcountrawhll(user)"}},
+			Rows:       [][]interface{}{{json.Number("10")}},
+		},
+	}
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, data.FieldTypeNullableString, frames[0].Fields[0].Type())
+}
+
+func TestConvertToDataFrames_PercentileColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		columnType  string
+		value       interface{}
+		expected    interface{}
+		expectError bool
+	}{
+		{"percentile as double", "DOUBLE", json.Number("95.5"), 95.5, false},
+		{"percentile tdigest as double string", "DOUBLE", "42.25", 42.25, false},
+		{"percentile estimate as long", "LONG", json.Number("120"), int64(120), false},
+		{"percentile estimate as long string", "LONG", "120", int64(120), false},
+		{"serialized estimate object", "DOUBLE", map[string]interface{}{"centroids": []interface{}{}}, nil, true},
+		{"serialized estimate array", "LONG", []interface{}{json.Number("1"), json.Number("2")}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinotResp := &PinotResponse{
+				ResultTable: &ResultTable{
+					DataSchema: DataSchema{
+						ColumnNames:     []string{"percentiletdigest95(latency)"},
+						ColumnDataTypes: []string{tt.columnType},
+					},
+					Rows: [][]interface{}{{tt.value}},
+				},
+			}
+
+			frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, errSerializedValue)
+				assert.Contains(t, err.Error(), "percentiletdigest95(latency)")
+				return
+			}
+
+			require.NoError(t, err)
+			value := frames[0].Fields[0].At(0)
+			switch expected := tt.expected.(type) {
+			case float64:
+				assert.Equal(t, expected, *value.(*float64))
+			case int64:
+				assert.Equal(t, expected, *value.(*int64))
+			}
+		})
+	}
+}
+
+func TestConvertToDataFrames_StrictTypes(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"name", "shape"},
+				ColumnDataTypes: []string{"STRING", "HYPERSHAPE"},
+			},
+			Rows: [][]interface{}{{"a", "cube"}},
+		},
+	}
+
+	t.Run("lenient mode renders unknown types as strings", func(t *testing.T) {
+		frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+		require.NoError(t, err)
+		require.Len(t, frames[0].Fields, 2)
+		assert.Equal(t, data.FieldTypeNullableString, frames[0].Fields[1].Type())
+		assert.Equal(t, "cube", *frames[0].Fields[1].At(0).(*string))
+	})
+
+	t.Run("strict mode lists unrecognized types", func(t *testing.T) {
+		_, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{strictTypes: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unrecognized column types: shape (HYPERSHAPE)")
+	})
+
+	t.Run("strict mode accepts known types", func(t *testing.T) {
+		known := &PinotResponse{
+			ResultTable: &ResultTable{
+				DataSchema: DataSchema{
+					ColumnNames:     []string{"name", "tags"},
+					ColumnDataTypes: []string{"STRING", "STRING_ARRAY"},
+				},
+				Rows: [][]interface{}{{"a", []interface{}{"x", "y"}}},
+			},
+		}
+		_, err := convertToDataFrames("A", known, QueryModel{}, conversionOptions{strictTypes: true})
+		require.NoError(t, err)
+	})
+}
+
+func TestConvertToTime_UTC(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		location *time.Location
+		expected time.Time
+	}{
+		{"epoch milliseconds", json.Number("1700000000000"), nil, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+		{"epoch milliseconds ignore the configured zone", json.Number("1700000000000"), newYork, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+		{"naive string defaults to UTC", "2023-11-14 22:13:20", nil, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+		{"naive string uses the configured zone", "2023-11-14 17:13:20", newYork, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+		{"explicit offset wins over the configured zone", "2023-11-14T23:13:20+01:00", newYork, time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := convertToTime(tt.value, tt.location)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+			assert.Equal(t, time.UTC, result.Location())
+		})
+	}
+}
+
+func TestParseSchemaTimeFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		value    interface{}
+		expected time.Time
+		wantErr  bool
+	}{
+		{name: "epoch seconds", format: "1:SECONDS:EPOCH", value: float64(1700000000), expected: time.Unix(1700000000, 0)},
+		{name: "epoch buckets", format: "5:MINUTES:EPOCH", value: float64(2), expected: time.Unix(600, 0)},
+		{name: "pipe epoch", format: "EPOCH|MILLISECONDS|1", value: "1700000000000", expected: time.Unix(1700000000, 0)},
+		{name: "timestamp", format: "TIMESTAMP", value: float64(1700000000000), expected: time.Unix(1700000000, 0)},
+		{name: "date numbers", format: "1:DAYS:SIMPLE_DATE_FORMAT:yyyyMMdd", value: float64(20231114), expected: time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{name: "date strings with zone", format: "SIMPLE_DATE_FORMAT|yyyy-MM-dd'T'HH:mm|Asia/Kolkata", value: "2023-11-14T05:30", expected: time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)},
+		{name: "unknown unit", format: "1:WEEKS:EPOCH", wantErr: true},
+		{name: "unsupported pattern", format: "1:DAYS:SIMPLE_DATE_FORMAT:EEE", wantErr: true},
+		{name: "unknown format", format: "ISO8601", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := parseSchemaTimeFormat(tt.format)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			parsed, err := format.parse(tt.value)
+			require.NoError(t, err)
+			assert.True(t, tt.expected.Equal(parsed), "expected %s, got %s", tt.expected, parsed)
+		})
+	}
+}
+
+func TestWKTToGeoJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		wkt      string
+		expected string
+		errorMsg string
+	}{
+		{"point", "POINT (-122.4194 37.7749)", `{"type":"Point","coordinates":[-122.4194,37.7749]}`, ""},
+		{"point with elevation and SRID", "SRID=4326;point z(1 2 3)", `{"type":"Point","coordinates":[1,2,3]}`, ""},
+		{"line string", "LINESTRING (30 10, 10 30, 40 40)", `{"type":"LineString","coordinates":[[30,10],[10,30],[40,40]]}`, ""},
+		{"polygon", "POLYGON ((30 10, 40 40, 20 40, 30 10))", `{"type":"Polygon","coordinates":[[[30,10],[40,40],[20,40],[30,10]]]}`, ""},
+		{"multi point with parenthesized points", "MULTIPOINT ((10 40), (40 30))", `{"type":"MultiPoint","coordinates":[[10,40],[40,30]]}`, ""},
+		{"multi polygon", "MULTIPOLYGON (((1 1, 2 2, 1 2, 1 1)))", `{"type":"MultiPolygon","coordinates":[[[[1,1],[2,2],[1,2],[1,1]]]]}`, ""},
+		{"not a geometry", "POINTS OF INTEREST", "", "not a WKT geometry"},
+		{"invalid position", "POINT (1)", "", "invalid position"},
+		{"wrong nesting", "POLYGON (30 10, 40 40)", "", "unexpected nesting"},
+		{"trailing text", "POINT (1 2) extra", "", "not a WKT geometry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := wktToGeoJSON(tt.wkt)
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, result)
+		})
+	}
+}
+
+func TestConvertToDataFrames_GeoJSON(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"city", "location"},
+				ColumnDataTypes: []string{"STRING", "STRING"},
+			},
+			Rows: [][]interface{}{
+				{"San Francisco", "POINT (-122.4194 37.7749)"},
+				{"Unknown", nil},
+			},
+		},
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{geoJSON: true})
+	require.NoError(t, err)
+	location := frames[0].Fields[1]
+	require.Equal(t, data.FieldTypeNullableString, location.Type())
+	value, ok := location.ConcreteAt(0)
+	require.True(t, ok)
+	assert.JSONEq(t, `{"type":"Point","coordinates":[-122.4194,37.7749]}`, value.(string))
+	_, ok = location.ConcreteAt(1)
+	assert.False(t, ok)
+	city, _ := frames[0].Fields[0].ConcreteAt(0)
+	assert.Equal(t, "San Francisco", city)
+
+	// Without the setting the WKT is passed through unchanged
+	frames, err = convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{})
+	require.NoError(t, err)
+	value, _ = frames[0].Fields[1].ConcreteAt(0)
+	assert.Equal(t, "POINT (-122.4194 37.7749)", value)
+
+	// A column that only partly holds geometries is left unchanged
+	pinotResp.ResultTable.Rows[1][1] = "somewhere"
+	frames, err = convertToDataFrames("A", pinotResp, QueryModel{}, conversionOptions{geoJSON: true})
+	require.NoError(t, err)
+	value, _ = frames[0].Fields[1].ConcreteAt(0)
+	assert.Equal(t, "POINT (-122.4194 37.7749)", value)
+}
+
+func TestOverriddenType_CaseVariants(t *testing.T) {
+	overrides := map[string]string{"Ts": "string", "ts": "time", "TS": "number", "Visits": "string", "VISITS": "number"}
+
+	// Repeated as the map iteration order varies between runs
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, "TIMESTAMP", overriddenType("ts", "LONG", overrides), "the exact key wins")
+		assert.Equal(t, "STRING", overriddenType("Ts", "LONG", overrides), "the exact key wins")
+		assert.Equal(t, "DOUBLE", overriddenType("tS", "LONG", overrides), "the first sorted key matching ignoring case")
+		assert.Equal(t, "DOUBLE", overriddenType("visits", "INT", overrides), "the first sorted key matching ignoring case")
+	}
+}
+
+func TestConvertToDataFrames_TypeOverrides(t *testing.T) {
+	pinotResp := &PinotResponse{
+		ResultTable: &ResultTable{
+			DataSchema: DataSchema{
+				ColumnNames:     []string{"user_id", "visits", "ts"},
+				ColumnDataTypes: []string{"INT", "INT", "LONG"},
+			},
+			Rows: [][]interface{}{
+				{json.Number("42"), json.Number("3"), json.Number("1700000000000")},
+			},
+		},
+	}
+	opts := conversionOptions{typeOverrides: map[string]string{"INT": "number", "USER_ID": "string", "long": "time"}}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{Format: FormatTimeSeries}, opts)
+	require.NoError(t, err)
+	frame := frames[0]
+
+	// The LONG epochs are detected as the time column
+	require.Equal(t, "ts", frame.Fields[0].Name)
+	assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[0].Type())
+	timeValue, _ := frame.Fields[0].ConcreteAt(0)
+	assert.Equal(t, time.UnixMilli(1700000000000).UTC(), timeValue)
+
+	// The column name override wins over the INT override
+	userID := frame.Fields[1]
+	assert.Equal(t, data.FieldTypeNullableString, userID.Type())
+	value, _ := userID.ConcreteAt(0)
+	assert.Equal(t, "42", value)
+	assert.Equal(t, "INT", userID.Config.Custom["pinotType"])
+
+	visits := frame.Fields[2]
+	assert.Equal(t, data.FieldTypeNullableFloat64, visits.Type())
+	value, _ = visits.ConcreteAt(0)
+	assert.Equal(t, 3.0, value)
+
+	assert.NoError(t, validateTypeOverrides(opts.typeOverrides))
+	assert.EqualError(t, validateTypeOverrides(map[string]string{"LONG": "date"}), `invalid type override "date" for "LONG", expected time, string, number or boolean`)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// SQL GUARD - Read-Only Statements
+// ============================================================================
+
+// ErrReadOnlyQuery is returned when a statement other than a read query is sent while writes are not allowed
+var ErrReadOnlyQuery = errors.New("only read queries are allowed")
+
+// readStatementKeywords lists the leading keywords of the statements allowed on a read-only datasource
+// WITH statements are also checked by the statement following their common table expressions
+var readStatementKeywords = []string{"SELECT", "EXPLAIN", "SET", "WITH"}
+
+// checkReadOnly verifies that every statement of the SQL is a read query
+// Comments are ignored and statements are split on semicolons outside string literals,
+// so a write hidden behind a SET option (e.g. SET a = 1; DROP TABLE t) is rejected too
+func checkReadOnly(sql string) error {
+	for _, statement := range splitStatements(stripComments(sql)) {
+		keyword := leadingKeyword(statement)
+		if keyword == "" {
+			continue
+		}
+		if !isReadKeyword(keyword) {
+			return fmt.Errorf("%w, found %s statement", ErrReadOnlyQuery, keyword)
+		}
+		if keyword != "WITH" {
+			continue
+		}
+		main := withMainKeyword(statement)
+		if main == "" {
+			return fmt.Errorf("%w, found WITH statement without a query", ErrReadOnlyQuery)
+		}
+		if main != "SELECT" {
+			return fmt.Errorf("%w, found WITH ... %s statement", ErrReadOnlyQuery, main)
+		}
+	}
+	return nil
+}
+
+// withMainKeyword returns the leading keyword of the statement following the common table
+// expressions of a WITH statement, e.g. DELETE for WITH old AS (SELECT ...) DELETE FROM t
+// It is empty when the common table expressions are not followed by a statement
+func withMainKeyword(statement string) string {
+	depth := 0
+	for i := 0; i < len(statement); i++ {
+		switch statement[i] {
+		case '\'', '"':
+			i = literalEnd(statement, i) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth != 0 {
+				continue
+			}
+			// Another expression or the AS of a column list, e.g. WITH t (a, b) AS (...), follows
+			rest := strings.TrimLeft(statement[i+1:], " \t\r\n")
+			if keyword := leadingKeyword(rest); keyword != "AS" && !strings.HasPrefix(rest, ",") {
+				return keyword
+			}
+		}
+	}
+	return ""
+}
+
+// isReadKeyword reports whether a statement starting with the keyword is allowed
+func isReadKeyword(keyword string) bool {
+	for _, allowed := range readStatementKeywords {
+		if keyword == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// leadingKeyword returns the first word of the statement in upper case, skipping opening parentheses
+func leadingKeyword(statement string) string {
+	statement = strings.TrimLeft(statement, " \t\r\n(")
+	end := strings.IndexFunc(statement, func(r rune) bool {
+		return !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+	})
+	if end < 0 {
+		end = len(statement)
+	}
+	return strings.ToUpper(statement[:end])
+}
+
+// stripComments replaces -- line comments and /* */ block comments outside string literals and
+// quoted identifiers with spaces
+func stripComments(sql string) string {
+	var sb strings.Builder
+	sb.Grow(len(sql))
+
+	for i := 0; i < len(sql); i++ {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			end := literalEnd(sql, i)
+			sb.WriteString(sql[i:end])
+			i = end - 1
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return sb.String()
+			}
+			sb.WriteByte(' ')
+			i += end - 1
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return sb.String()
+			}
+			sb.WriteByte(' ')
+			i += end + 3
+		default:
+			sb.WriteByte(sql[i])
+		}
+	}
+	return sb.String()
+}
+
+// splitStatements splits the SQL on semicolons outside string literals and quoted identifiers
+func splitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	for i := 0; i < len(sql); i++ {
+		switch sql[i] {
+		case '\'', '"':
+			i = literalEnd(sql, i) - 1
+		case ';':
+			statements = append(statements, sql[start:i])
+			start = i + 1
+		}
+	}
+	return append(statements, sql[start:])
+}
+
+// literalEnd returns the offset just past the string literal ('...') or quoted identifier ("...")
+// opening at start, handling doubled quote escapes
+func literalEnd(sql string, start int) int {
+	end, _ := quotedEnd(sql, start)
+	return end
+}
+
+// quotedEnd returns the offset just past the quoted text opening at start, and whether its closing
+// quote was found (the end of the SQL is returned otherwise)
+func quotedEnd(sql string, start int) (int, bool) {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1, true
+	}
+	return len(sql), false
+}
+
+// ============================================================================
+// SQL GUARD - Mandatory Filter
+// ============================================================================
+
+// ErrMandatoryFilter is returned when the mandatory filter of the datasource cannot be applied to a query
+var ErrMandatoryFilter = errors.New("the mandatory filter of the datasource cannot be applied")
+
+var (
+	whereKeywordRegex  = regexp.MustCompile(`(?i)\bWHERE\b`)
+	joinKeywordRegex   = regexp.MustCompile(`(?i)\bJOIN\b`)
+	clauseKeywordRegex = regexp.MustCompile(`(?i)\b(GROUP\s+BY|HAVING|ORDER\s+BY|LIMIT|OPTION)\b`)
+	setOperatorRegex   = regexp.MustCompile(`(?i)\b(UNION|INTERSECT|EXCEPT)\b`)
+)
+
+// applyMandatoryFilter ANDs the filter into the WHERE clause of every SELECT reading a table,
+// including subqueries, e.g. SELECT a FROM t WHERE b = 1 OR c = 2 becomes
+// SELECT a FROM t WHERE (account_id = 42) AND (b = 1 OR c = 2). SELECTs from a subquery or a
+// common table expression are left to the filtered SELECTs they read from. Comments are removed
+// first so none can swallow the filter. Queries whose tables cannot all be located, or that join
+// tables, are rejected rather than sent unfiltered.
+func applyMandatoryFilter(sql, filter string) (string, error) {
+	if filter == "" {
+		return sql, nil
+	}
+	sql = stripComments(sql)
+
+	scope := scanSQLScope(sql)
+	if match := findInScope(joinKeywordRegex, sql, scope); match != nil {
+		return "", fmt.Errorf("%w to a JOIN", ErrMandatoryFilter)
+	}
+
+	cteNames := map[string]bool{}
+	for _, match := range cteNameRegex.FindAllStringSubmatch(sql, -1) {
+		cteNames[strings.ToLower(match[1])] = true
+	}
+
+	refs := map[int]tableReference{}
+	for _, ref := range findTableReferences(sql) {
+		refs[ref.start] = ref
+	}
+
+	// Every FROM must read a located table or a subquery; rewrite from the end so earlier offsets stay valid
+	froms := fromKeywordRegex.FindAllStringIndex(sql, -1)
+	for i := len(froms) - 1; i >= 0; i-- {
+		if !scope[froms[i][0]] {
+			continue
+		}
+		start := froms[i][1] + len(sql[froms[i][1]:]) - len(strings.TrimLeft(sql[froms[i][1]:], " \t\r\n"))
+		if start < len(sql) && sql[start] == '(' {
+			continue
+		}
+		quoted := start < len(sql) && sql[start] == '"'
+		if quoted {
+			start++
+		}
+		ref, ok := refs[start]
+		if !ok {
+			return "", fmt.Errorf("%w, the table read by FROM at offset %d was not recognized", ErrMandatoryFilter, froms[i][0])
+		}
+		if cteNames[strings.ToLower(ref.name)] {
+			continue
+		}
+
+		// The clauses are scanned from past the closing quote of a quoted table
+		tableEnd := ref.end
+		if quoted {
+			tableEnd++
+		}
+		var err error
+		if sql, err = filterSelect(sql, tableEnd, filter); err != nil {
+			return "", err
+		}
+	}
+	return sql, nil
+}
+
+// filterSelect adds the filter to the SELECT whose FROM table ends at the offset: the clauses up to
+// the end of the SELECT (a closing parenthesis, a semicolon, a set operator such as UNION or the end
+// of the SQL) are scanned for a WHERE, which gets the filter, or the clause before which a WHERE is inserted
+func filterSelect(sql string, tableEnd int, filter string) (string, error) {
+	end := len(sql)
+	depth := 0
+scan:
+	for i := tableEnd; i < len(sql); i++ {
+		switch sql[i] {
+		case '\'', '"':
+			i = literalEnd(sql, i) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = i
+				break scan
+			}
+			depth--
+		case ';':
+			if depth == 0 {
+				end = i
+				break scan
+			}
+		}
+	}
+
+	clauses := sql[tableEnd:end]
+	topLevel := scanTopLevel(clauses)
+	if match := findTopLevel(setOperatorRegex, clauses, topLevel); match != nil {
+		end = tableEnd + match[0]
+		clauses, topLevel = clauses[:match[0]], topLevel[:match[0]]
+	}
+	where := findTopLevel(whereKeywordRegex, clauses, topLevel)
+
+	conditionStart := 0
+	if where != nil {
+		conditionStart = where[1]
+	}
+	conditionEnd := len(strings.TrimRight(clauses, " \t\r\n"))
+	for _, match := range clauseKeywordRegex.FindAllStringIndex(clauses, -1) {
+		if match[0] >= conditionStart && topLevel[match[0]] {
+			conditionEnd = match[0]
+			break
+		}
+	}
+
+	// Anything but an alias between the table and its WHERE, such as FROM a, b, is another table
+	tableClause := clauses[:conditionEnd]
+	if where != nil {
+		tableClause = clauses[:where[0]]
+	}
+	for i := range tableClause {
+		if tableClause[i] == ',' && topLevel[i] {
+			return "", fmt.Errorf("%w to a query reading several tables", ErrMandatoryFilter)
+		}
+	}
+
+	var rewritten string
+	if where == nil {
+		rewritten = strings.TrimRight(clauses[:conditionEnd], " \t\r\n") + " WHERE (" + filter + ")"
+	} else {
+		condition := strings.TrimSpace(clauses[conditionStart:conditionEnd])
+		rewritten = clauses[:where[1]] + " (" + filter + ") AND (" + condition + ")"
+	}
+	if rest := strings.TrimSpace(clauses[conditionEnd:]); rest != "" {
+		rewritten += " " + rest
+	}
+	if end < len(sql) && sql[end] != ')' && sql[end] != ';' {
+		rewritten += " " // Before a set operator
+	}
+	return sql[:tableEnd] + rewritten + sql[end:], nil
+}
+
+// findInScope returns the first match of the regex in a query scope, see scanSQLScope
+func findInScope(re *regexp.Regexp, sql string, scope []bool) []int {
+	for _, match := range re.FindAllStringIndex(sql, -1) {
+		if scope[match[0]] {
+			return match
+		}
+	}
+	return nil
+}
+
+// validateMandatoryFilter checks that the filter is a single predicate that cannot close the
+// WHERE clause it is added to: balanced parentheses and string literals, and no statement end
+func validateMandatoryFilter(filter string) error {
+	if filter == "" {
+		return nil
+	}
+
+	depth := 0
+	for i := 0; i < len(filter); i++ {
+		switch filter[i] {
+		case '\'', '"':
+			end, closed := quotedEnd(filter, i)
+			if !closed && filter[i] == '"' {
+				return fmt.Errorf("invalid mandatory filter %q: unterminated quoted identifier", filter)
+			}
+			if !closed {
+				return fmt.Errorf("invalid mandatory filter %q: unterminated string literal", filter)
+			}
+			i = end - 1
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return fmt.Errorf("invalid mandatory filter %q: unbalanced parentheses", filter)
+			}
+		case ';':
+			return fmt.Errorf("invalid mandatory filter %q: semicolons are not allowed", filter)
+		case '-', '/':
+			if strings.HasPrefix(filter[i:], "--") || strings.HasPrefix(filter[i:], "/*") {
+				return fmt.Errorf("invalid mandatory filter %q: comments are not allowed", filter)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("invalid mandatory filter %q: unbalanced parentheses", filter)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		name        string
+		sql         string
+		expectError bool
+		errorMsg    string
+	}{
+		{name: "allows SELECT", sql: "SELECT * FROM airlineStats"},
+		{name: "allows lowercase select", sql: "  select 1"},
+		{name: "allows EXPLAIN", sql: "EXPLAIN PLAN FOR SELECT * FROM airlineStats"},
+		{name: "allows SET options before a query", sql: "SET useMultistageEngine = true; SELECT * FROM airlineStats;"},
+		{name: "allows leading comments", sql: "-- daily flights\n/* owner: ops */ SELECT * FROM airlineStats"},
+		{name: "allows parenthesized queries", sql: "(SELECT 1)"},
+		{name: "allows keywords inside literals", sql: "SELECT * FROM t WHERE note = 'x; DROP TABLE t'"},
+		{name: "allows keywords inside comments", sql: "SELECT 1 -- ; DROP TABLE t"},
+		{name: "allows semicolons inside quoted identifiers", sql: `SELECT "a; DROP TABLE t" FROM t`},
+		{name: "ignores empty SQL", sql: "  ;  "},
+		{name: "allows WITH queries", sql: "WITH recent AS (SELECT * FROM t WHERE ts > 0), top AS (SELECT a FROM recent) SELECT * FROM top"},
+		{name: "allows WITH queries with column lists", sql: "with recent (a, b) as (select a, b from t) (select a from recent)"},
+		{name: "allows WITH queries with parentheses in literals", sql: "WITH r AS (SELECT ')' AS c FROM t) SELECT c FROM r"},
+		{name: "rejects WITH INSERT", sql: "WITH staged AS (SELECT * FROM staging) INSERT INTO t SELECT * FROM staged", expectError: true, errorMsg: "found WITH ... INSERT statement"},
+		{name: "rejects WITH DELETE", sql: "WITH old AS (SELECT id FROM t) DELETE FROM t WHERE id IN (SELECT id FROM old)", expectError: true, errorMsg: "found WITH ... DELETE statement"},
+		{name: "rejects WITH without a query", sql: "WITH old AS (SELECT id FROM t)", expectError: true, errorMsg: "found WITH statement without a query"},
+		{name: "rejects DROP", sql: "DROP TABLE airlineStats", expectError: true, errorMsg: "only read queries are allowed, found DROP statement"},
+		{name: "rejects INSERT", sql: "insert into airlineStats select * from staging", expectError: true, errorMsg: "found INSERT statement"},
+		{name: "rejects writes after a SET option", sql: "SET a = 1; DELETE FROM t", expectError: true, errorMsg: "found DELETE statement"},
+		{name: "rejects writes hidden behind a comment", sql: "/* SELECT */ DROP TABLE t", expectError: true, errorMsg: "found DROP statement"},
+		{name: "rejects writes after a query", sql: "SELECT 1; DROP TABLE t", expectError: true, errorMsg: "found DROP statement"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReadOnly(tt.sql)
+			if tt.expectError {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, ErrReadOnlyQuery)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestApplyMandatoryFilter(t *testing.T) {
+	const filter = "account_id = 42"
+
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+		errorMsg string
+	}{
+		{
+			name:     "adds a WHERE clause",
+			sql:      "SELECT a FROM events",
+			expected: "SELECT a FROM events WHERE (account_id = 42)",
+		},
+		{
+			name:     "adds a WHERE clause before the other clauses",
+			sql:      "SELECT carrier, COUNT(*) FROM events e GROUP BY carrier ORDER BY carrier LIMIT 10",
+			expected: "SELECT carrier, COUNT(*) FROM events e WHERE (account_id = 42) GROUP BY carrier ORDER BY carrier LIMIT 10",
+		},
+		{
+			name:     "wraps an existing condition",
+			sql:      "SELECT a FROM events WHERE b = 1 OR c = 2 LIMIT 10",
+			expected: "SELECT a FROM events WHERE (account_id = 42) AND (b = 1 OR c = 2) LIMIT 10",
+		},
+		{
+			name:     "filters quoted tables",
+			sql:      `SELECT COUNT(*) FROM "events" LIMIT 1`,
+			expected: `SELECT COUNT(*) FROM "events" WHERE (account_id = 42) LIMIT 1`,
+		},
+		{
+			name:     "filters subqueries of the condition",
+			sql:      "SELECT a FROM events WHERE b IN (SELECT b FROM users WHERE active = true) AND c > 0",
+			expected: "SELECT a FROM events WHERE (account_id = 42) AND (b IN (SELECT b FROM users WHERE (account_id = 42) AND (active = true)) AND c > 0)",
+		},
+		{
+			name:     "filters the table of a FROM subquery",
+			sql:      "SELECT b, total FROM (SELECT b, SUM(c) AS total FROM events GROUP BY b) WHERE total > 10",
+			expected: "SELECT b, total FROM (SELECT b, SUM(c) AS total FROM events WHERE (account_id = 42) GROUP BY b) WHERE total > 10",
+		},
+		{
+			name:     "filters common table expressions",
+			sql:      "WITH recent AS (SELECT a FROM events WHERE ts > 0) SELECT a FROM recent",
+			expected: "WITH recent AS (SELECT a FROM events WHERE (account_id = 42) AND (ts > 0)) SELECT a FROM recent",
+		},
+		{
+			name:     "filters every SELECT of a union",
+			sql:      "SELECT a FROM events UNION ALL SELECT a FROM archive WHERE a > 1",
+			expected: "SELECT a FROM events WHERE (account_id = 42) UNION ALL SELECT a FROM archive WHERE (account_id = 42) AND (a > 1)",
+		},
+		{
+			name:     "keeps SET options",
+			sql:      "SET timeoutMs = 1000; SELECT a FROM events;",
+			expected: "SET timeoutMs = 1000; SELECT a FROM events WHERE (account_id = 42);",
+		},
+		{
+			name:     "ignores keywords in literals and functions",
+			sql:      "SELECT EXTRACT(DAY FROM ts) FROM events WHERE note = 'x FROM y LIMIT 1' OPTION(timeoutMs=1000)",
+			expected: "SELECT EXTRACT(DAY FROM ts) FROM events WHERE (account_id = 42) AND (note = 'x FROM y LIMIT 1') OPTION(timeoutMs=1000)",
+		},
+		{
+			name:     "removes comments that would swallow the filter",
+			sql:      "SELECT a FROM events -- all rows",
+			expected: "SELECT a FROM events WHERE (account_id = 42)",
+		},
+		{
+			name:     "ignores keywords and parentheses in quoted aliases",
+			sql:      `SELECT * FROM t AS "aWHERE" WHERE x IN (1)`,
+			expected: `SELECT * FROM t AS "aWHERE" WHERE (account_id = 42) AND (x IN (1))`,
+		},
+		{
+			name:     "ignores a WHERE and an open parenthesis in a quoted alias",
+			sql:      `SELECT * FROM t AS "a WHERE (" WHERE x IN (1)`,
+			expected: `SELECT * FROM t AS "a WHERE (" WHERE (account_id = 42) AND (x IN (1))`,
+		},
+		{
+			name:     "ignores escaped quotes and literal quotes in quoted aliases",
+			sql:      `SELECT * FROM t AS "a"" WHERE ' )" LIMIT 5`,
+			expected: `SELECT * FROM t AS "a"" WHERE ' )" WHERE (account_id = 42) LIMIT 5`,
+		},
+		{
+			name:     "filters quoted tables followed by a WHERE",
+			sql:      `SELECT a FROM "events" WHERE a > 1`,
+			expected: `SELECT a FROM "events" WHERE (account_id = 42) AND (a > 1)`,
+		},
+		{
+			name:     "rejects quoted table names with keywords",
+			sql:      `SELECT a FROM "my WHERE (table" WHERE a > 1`,
+			errorMsg: "was not recognized",
+		},
+		{
+			name:     "ignores keywords, parentheses and quotes in quoted columns",
+			sql:      `SELECT "it's ) WHERE", "FROM x" FROM events WHERE "col""WHERE (" = 1 ORDER BY "LIMIT"`,
+			expected: `SELECT "it's ) WHERE", "FROM x" FROM events WHERE (account_id = 42) AND ("col""WHERE (" = 1) ORDER BY "LIMIT"`,
+		},
+		{
+			name:     "keeps comment markers in quoted identifiers",
+			sql:      `SELECT "a--b" FROM events`,
+			expected: `SELECT "a--b" FROM events WHERE (account_id = 42)`,
+		},
+		{
+			name:     "leaves queries without tables",
+			sql:      "SELECT 1",
+			expected: "SELECT 1",
+		},
+		{
+			name:     "rejects joins",
+			sql:      "SELECT a FROM events JOIN users ON events.u = users.u",
+			errorMsg: "cannot be applied to a JOIN",
+		},
+		{
+			name:     "rejects several tables",
+			sql:      "SELECT a FROM events, users WHERE events.u = users.u",
+			errorMsg: "cannot be applied to a query reading several tables",
+		},
+		{
+			name:     "rejects unrecognized tables",
+			sql:      `SELECT a FROM "events`,
+			errorMsg: "was not recognized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMandatoryFilter(tt.sql, filter)
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, ErrMandatoryFilter)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestValidateMandatoryFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		errorMsg string
+	}{
+		{filter: ""},
+		{filter: "account_id = 42"},
+		{filter: "(region = 'eu' OR region = 'it''s') AND active = true"},
+		{filter: "note = 'a;b -- c'"},
+		{filter: "account_id = 42) OR (1 = 1", errorMsg: "unbalanced parentheses"},
+		{filter: "(account_id = 42", errorMsg: "unbalanced parentheses"},
+		{filter: "region = 'eu", errorMsg: "unterminated string literal"},
+		{filter: `"it's" = 1`},
+		{filter: `"account_id = 42`, errorMsg: "unterminated quoted identifier"},
+		{filter: "account_id = 42; DROP TABLE t", errorMsg: "semicolons are not allowed"},
+		{filter: "account_id = 42 --", errorMsg: "comments are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			err := validateMandatoryFilter(tt.filter)
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
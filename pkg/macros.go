@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ============================================================================
+// TYPES - Macro Context
+// ============================================================================
+
+// macroContext holds the query details used to expand macros
+type macroContext struct {
+	timeRange      backend.TimeRange
+	interval       time.Duration // Bucket size of the interval macros, see computeInterval
+	hideTimeFilter bool          // Neutralizes the time macros so the query runs without time constraints
+	database       string        // Database prefixed to the table of $__table, see DataSourceConfig.DefaultDatabase
+	timezone       string        // IANA zone aligning the calendar buckets of $__timeGroup (UTC when empty)
+	offset         int64         // Page of $__offset and $__limit, see QueryModel.Offset
+	limit          int64
+}
+
+// calendarUnits maps the abbreviated calendar intervals of $__timeGroup to their DATETRUNC unit
+// Abbreviations are case-sensitive, as 1m is a minute and 1M a month
+var calendarUnits = map[string]string{
+	"1d": "DAY",
+	"1w": "WEEK",
+	"1M": "MONTH",
+	"1Q": "QUARTER",
+	"1y": "YEAR",
+}
+
+// calendarUnit returns the DATETRUNC unit of a calendar interval, either abbreviated (e.g. 1M)
+// or named in any case (e.g. month)
+func calendarUnit(interval string) (string, bool) {
+	if unit, ok := calendarUnits[interval]; ok {
+		return unit, true
+	}
+	for _, unit := range calendarUnits {
+		if strings.EqualFold(interval, unit) {
+			return unit, true
+		}
+	}
+	return "", false
+}
+
+// DefaultInterval is the bucket size used when neither the data points nor Grafana's interval are known
+const DefaultInterval = time.Minute
+
+// ============================================================================
+// MACROS - Expansion
+// ============================================================================
+
+var (
+	timeFilterMacro = regexp.MustCompile(`\$__timeFilter\(([^)]*)\)`)
+	timeGroupMacro  = regexp.MustCompile(`\$__timeGroup\(([^)]*)\)`)
+	timeFromMacro   = regexp.MustCompile(`\$__timeFrom\b`)
+	timeToMacro     = regexp.MustCompile(`\$__timeTo\b`)
+	timeFromFormat  = regexp.MustCompile(`\$__timeFrom\(([^)]*)\)`)
+	timeToFormat    = regexp.MustCompile(`\$__timeTo\(([^)]*)\)`)
+	timeFromRounded = regexp.MustCompile(`\$__timeFromRounded\b`)
+	timeToRounded   = regexp.MustCompile(`\$__timeToRounded\b`)
+	intervalMsMacro = regexp.MustCompile(`\$__interval_ms\b`)
+	intervalMacro   = regexp.MustCompile(`\$__interval\b`)
+	tableMacro      = regexp.MustCompile(`\$__table\(([^)]*)\)`)
+	offsetMacro     = regexp.MustCompile(`\$__offset\b`)
+	limitMacro      = regexp.MustCompile(`\$__limit\b`)
+)
+
+// applyMacros expands the Grafana macros in the SQL using the query time range
+// Time bounds are rendered as epoch milliseconds, matching Pinot's native time representation
+//
+// Supported macros:
+//   - $__timeFilter(column): column >= <from> AND column <= <to>
+//   - $__timeFilter(column, 'pattern'): the same with the bounds as date strings, see formatTimeBound
+//   - $__timeFrom: start of the time range
+//   - $__timeTo: end of the time range
+//   - $__timeFrom('pattern') / $__timeTo('pattern'): the bounds as date strings, see formatTimeBound
+//   - $__timeFromRounded / $__timeToRounded: the bounds rounded down/up to a multiple of the interval
+//   - $__timeGroup(column[, interval]): column bucketed by the interval, as epoch milliseconds;
+//     calendar intervals (1d, 1w, 1M, 1Q, 1y or day, week, ...) truncate in the query timezone
+//   - $__interval: the interval as a duration (e.g. 30s, 5m)
+//   - $__interval_ms: the interval in milliseconds
+//   - $__table(name): the table qualified with the default database, unless already qualified
+//   - $__offset / $__limit: the page of the query, e.g. LIMIT $__offset, $__limit
+//
+// When hideTimeFilter is set, $__timeFilter becomes an always-true predicate and
+// the bounds span the whole epoch range
+func applyMacros(sql string, mc macroContext) (string, error) {
+	intervalMs := mc.interval.Milliseconds()
+	from := strconv.FormatInt(mc.timeRange.From.UnixMilli(), 10)
+	to := strconv.FormatInt(mc.timeRange.To.UnixMilli(), 10)
+	fromRounded := strconv.FormatInt(roundDown(mc.timeRange.From.UnixMilli(), intervalMs), 10)
+	toRounded := strconv.FormatInt(roundUp(mc.timeRange.To.UnixMilli(), intervalMs), 10)
+	if mc.hideTimeFilter {
+		from = "0"
+		to = strconv.FormatInt(math.MaxInt64, 10)
+		fromRounded, toRounded = from, to
+	}
+
+	var macroErr error
+	sql = timeFilterMacro.ReplaceAllStringFunc(sql, func(match string) string {
+		args := strings.SplitN(timeFilterMacro.FindStringSubmatch(match)[1], ",", 2)
+		column := strings.TrimSpace(args[0])
+		if column == "" {
+			macroErr = fmt.Errorf("macro $__timeFilter requires a time column argument")
+			return match
+		}
+		if mc.hideTimeFilter {
+			return "1 = 1"
+		}
+		if len(args) == 2 {
+			start, err := formatTimeBound(mc.timeRange.From, args[1], mc.timezone)
+			if err != nil {
+				macroErr = fmt.Errorf("macro $__timeFilter: %w", err)
+				return match
+			}
+			end, _ := formatTimeBound(mc.timeRange.To, args[1], mc.timezone)
+			return fmt.Sprintf("%s >= %s AND %s <= %s", column, start, column, end)
+		}
+		return fmt.Sprintf("%s >= %s AND %s <= %s", column, from, column, to)
+	})
+	if macroErr != nil {
+		return "", macroErr
+	}
+
+	for _, macro := range []struct {
+		name  string
+		regex *regexp.Regexp
+		bound time.Time
+	}{
+		{"$__timeFrom", timeFromFormat, mc.timeRange.From},
+		{"$__timeTo", timeToFormat, mc.timeRange.To},
+	} {
+		// Without a time filter the bounds span from the epoch to the last year of four-digit dates
+		bound := macro.bound
+		if mc.hideTimeFilter {
+			bound = time.UnixMilli(0)
+			if macro.name == "$__timeTo" {
+				bound = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+			}
+		}
+		sql = macro.regex.ReplaceAllStringFunc(sql, func(match string) string {
+			literal, err := formatTimeBound(bound, macro.regex.FindStringSubmatch(match)[1], mc.timezone)
+			if err != nil {
+				macroErr = fmt.Errorf("macro %s: %w", macro.name, err)
+				return match
+			}
+			return literal
+		})
+		if macroErr != nil {
+			return "", macroErr
+		}
+	}
+
+	sql = timeGroupMacro.ReplaceAllStringFunc(sql, func(match string) string {
+		args := strings.Split(timeGroupMacro.FindStringSubmatch(match)[1], ",")
+		column := strings.TrimSpace(args[0])
+		if column == "" || len(args) > 2 {
+			macroErr = fmt.Errorf("macro $__timeGroup requires a time column and an optional interval argument")
+			return match
+		}
+
+		interval := mc.interval
+		if len(args) == 2 {
+			raw := strings.Trim(strings.TrimSpace(args[1]), "'")
+			if unit, ok := calendarUnit(raw); ok {
+				timezone := mc.timezone
+				if timezone == "" {
+					timezone = "UTC"
+				}
+				return fmt.Sprintf("DATETRUNC('%s', %s, 'MILLISECONDS', %s, 'MILLISECONDS')", unit, column, quoteLiteral(timezone))
+			}
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < time.Millisecond {
+				macroErr = fmt.Errorf("macro $__timeGroup has an invalid interval %q", raw)
+				return match
+			}
+			interval = parsed
+		}
+		return fmt.Sprintf("DATETIMECONVERT(%s, '1:MILLISECONDS:EPOCH', '1:MILLISECONDS:EPOCH', '%d:MILLISECONDS')", column, interval.Milliseconds())
+	})
+	if macroErr != nil {
+		return "", macroErr
+	}
+
+	sql = tableMacro.ReplaceAllStringFunc(sql, func(match string) string {
+		table := strings.TrimSpace(tableMacro.FindStringSubmatch(match)[1])
+		if table == "" {
+			macroErr = fmt.Errorf("macro $__table requires a table argument")
+			return match
+		}
+		return qualifyTable(table, mc.database)
+	})
+	if macroErr != nil {
+		return "", macroErr
+	}
+
+	if limitMacro.MatchString(sql) && mc.limit <= 0 {
+		return "", fmt.Errorf("macro $__limit requires the limit of the query")
+	}
+	sql = offsetMacro.ReplaceAllString(sql, strconv.FormatInt(mc.offset, 10))
+	sql = limitMacro.ReplaceAllString(sql, strconv.FormatInt(mc.limit, 10))
+
+	sql = timeFromRounded.ReplaceAllString(sql, fromRounded)
+	sql = timeToRounded.ReplaceAllString(sql, toRounded)
+	sql = timeFromMacro.ReplaceAllString(sql, from)
+	sql = timeToMacro.ReplaceAllString(sql, to)
+	sql = intervalMsMacro.ReplaceAllString(sql, strconv.FormatInt(mc.interval.Milliseconds(), 10))
+	sql = intervalMacro.ReplaceAllString(sql, formatInterval(mc.interval))
+
+	return sql, nil
+}
+
+// ============================================================================
+// MACROS - String Literals
+// ============================================================================
+
+// formatTimeBound renders a time bound as a SQL string literal for columns stored as date strings
+// (SIMPLE_DATE_FORMAT), e.g. '20231114' for the pattern 'yyyyMMdd'. The pattern argument is a
+// SQL string literal holding a Java date pattern; the bound is formatted in the timezone (UTC when empty)
+func formatTimeBound(bound time.Time, patternArg string, timezone string) (string, error) {
+	pattern, ok := unquoteLiteral(patternArg)
+	if !ok {
+		return "", fmt.Errorf("date pattern %s must be a single-quoted string", strings.TrimSpace(patternArg))
+	}
+	layout, err := javaDateLayout(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid date pattern %q: %w", pattern, err)
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return "", fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+	}
+	return quoteLiteral(bound.In(location).Format(layout)), nil
+}
+
+// quoteLiteral renders the value as a single-quoted SQL string literal, doubling embedded quotes
+// so the value cannot end the literal
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// unquoteLiteral returns the value of a single-quoted SQL string literal, undoing doubled quotes
+// It fails on unquoted values and on lone quotes inside the literal
+func unquoteLiteral(literal string) (string, bool) {
+	literal = strings.TrimSpace(literal)
+	if len(literal) < 2 || literal[0] != '\'' || literal[len(literal)-1] != '\'' {
+		return "", false
+	}
+	inner := literal[1 : len(literal)-1]
+	if strings.Count(strings.ReplaceAll(inner, "''", ""), "'") > 0 {
+		return "", false
+	}
+	return strings.ReplaceAll(inner, "''", "'"), true
+}
+
+// ============================================================================
+// MACROS - Interval
+// ============================================================================
+
+// computeInterval derives the bucket size of the interval macros from the time range and the
+// number of points the panel can display, so a panel gets roughly maxDataPoints buckets.
+// The result is rounded up to whole milliseconds and is never finer than Grafana's own interval;
+// without data points the Grafana interval is used as is.
+func computeInterval(timeRange backend.TimeRange, maxDataPoints int64, grafanaInterval time.Duration) time.Duration {
+	span := timeRange.To.Sub(timeRange.From)
+	if maxDataPoints <= 0 || span <= 0 {
+		if grafanaInterval > 0 {
+			return grafanaInterval
+		}
+		return DefaultInterval
+	}
+
+	interval := (span + time.Duration(maxDataPoints) - 1) / time.Duration(maxDataPoints)
+	interval = ((interval + time.Millisecond - 1) / time.Millisecond) * time.Millisecond
+	return max(interval, grafanaInterval)
+}
+
+// roundDown returns the largest multiple of the step not above the value (the value when step is not positive)
+func roundDown(value, step int64) int64 {
+	if step <= 0 {
+		return value
+	}
+	rounded := value - value%step
+	if rounded > value {
+		rounded -= step
+	}
+	return rounded
+}
+
+// roundUp returns the smallest multiple of the step not below the value (the value when step is not positive)
+func roundUp(value, step int64) int64 {
+	rounded := roundDown(value, step)
+	if rounded < value {
+		rounded += step
+	}
+	return rounded
+}
+
+// formatInterval renders the interval in the largest unit that divides it evenly (e.g. 5m, 30s, 1500ms)
+func formatInterval(interval time.Duration) string {
+	units := []struct {
+		size   time.Duration
+		suffix string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+	}
+	for _, unit := range units {
+		if interval >= unit.size && interval%unit.size == 0 {
+			return fmt.Sprintf("%d%s", interval/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dms", interval.Milliseconds())
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+// Macro Tests
+// ============================================================================
+
+func TestApplyMacros(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.UnixMilli(1700000000000),
+		To:   time.UnixMilli(1700003600000),
+	}
+
+	tests := []struct {
+		name        string
+		sql         string
+		expected    string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "leaves SQL without macros untouched",
+			sql:      "SELECT * FROM airlineStats",
+			expected: "SELECT * FROM airlineStats",
+		},
+		{
+			name:     "expands time filter",
+			sql:      "SELECT * FROM airlineStats WHERE $__timeFilter(ts)",
+			expected: "SELECT * FROM airlineStats WHERE ts >= 1700000000000 AND ts <= 1700003600000",
+		},
+		{
+			name:     "expands time bounds",
+			sql:      "SELECT * FROM airlineStats WHERE ts BETWEEN $__timeFrom AND $__timeTo",
+			expected: "SELECT * FROM airlineStats WHERE ts BETWEEN 1700000000000 AND 1700003600000",
+		},
+		{
+			name:     "expands time bounds rounded to the interval",
+			sql:      "SELECT * FROM airlineStats WHERE ts >= $__timeFromRounded AND ts < $__timeToRounded AND ts <= $__timeTo",
+			expected: "SELECT * FROM airlineStats WHERE ts >= 1699999980000 AND ts < 1700003610000 AND ts <= 1700003600000",
+		},
+		{
+			name:     "expands interval",
+			sql:      "SELECT $__interval_ms AS step, '$__interval' AS label FROM airlineStats",
+			expected: "SELECT 30000 AS step, '30s' AS label FROM airlineStats",
+		},
+		{
+			name:     "expands time group with the interval",
+			sql:      "SELECT $__timeGroup(ts) AS time, COUNT(*) FROM airlineStats GROUP BY $__timeGroup(ts)",
+			expected: "SELECT DATETIMECONVERT(ts, '1:MILLISECONDS:EPOCH', '1:MILLISECONDS:EPOCH', '30000:MILLISECONDS') AS time, COUNT(*) FROM airlineStats GROUP BY DATETIMECONVERT(ts, '1:MILLISECONDS:EPOCH', '1:MILLISECONDS:EPOCH', '30000:MILLISECONDS')",
+		},
+		{
+			name:     "expands time group with an explicit interval",
+			sql:      "SELECT $__timeGroup(ts, '5m') FROM airlineStats",
+			expected: "SELECT DATETIMECONVERT(ts, '1:MILLISECONDS:EPOCH', '1:MILLISECONDS:EPOCH', '300000:MILLISECONDS') FROM airlineStats",
+		},
+		{
+			name:        "fails on time group with an invalid interval",
+			sql:         "SELECT $__timeGroup(ts, 'often') FROM airlineStats",
+			expectError: true,
+			errorMsg:    `invalid interval "often"`,
+		},
+		{
+			name:        "fails on time group without column",
+			sql:         "SELECT $__timeGroup() FROM airlineStats",
+			expectError: true,
+			errorMsg:    "requires a time column",
+		},
+		{
+			name:        "fails on time filter without column",
+			sql:         "SELECT * FROM airlineStats WHERE $__timeFilter()",
+			expectError: true,
+			errorMsg:    "requires a time column argument",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMacros(tt.sql, macroContext{timeRange: timeRange, interval: 30 * time.Second})
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestComputeInterval(t *testing.T) {
+	hour := backend.TimeRange{From: time.UnixMilli(1700000000000), To: time.UnixMilli(1700003600000)}
+	day := backend.TimeRange{From: time.UnixMilli(1700000000000), To: time.UnixMilli(1700086400000)}
+
+	tests := []struct {
+		name            string
+		timeRange       backend.TimeRange
+		maxDataPoints   int64
+		grafanaInterval time.Duration
+		expected        time.Duration
+	}{
+		{"one hour over 120 points", hour, 120, 0, 30 * time.Second},
+		{"one hour over 1000 points", hour, 1000, 0, 3600 * time.Millisecond},
+		{"one day over 1440 points", day, 1440, 0, time.Minute},
+		{"rounds up to whole milliseconds", hour, 7, 0, 514286 * time.Millisecond},
+		{"never finer than the Grafana interval", hour, 10000, time.Second, time.Second},
+		{"never below one millisecond", backend.TimeRange{From: time.UnixMilli(0), To: time.UnixMilli(10)}, 1000, 0, time.Millisecond},
+		{"uses the Grafana interval without data points", hour, 0, 15 * time.Second, 15 * time.Second},
+		{"uses the default without data points or Grafana interval", hour, 0, 0, DefaultInterval},
+		{"uses the default for an empty range", backend.TimeRange{}, 1000, 0, DefaultInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, computeInterval(tt.timeRange, tt.maxDataPoints, tt.grafanaInterval))
+		})
+	}
+}
+
+func TestRoundToInterval(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        int64
+		step         int64
+		expectedDown int64
+		expectedUp   int64
+	}{
+		{"between multiples", 1700000012345, 60000, 1699999980000, 1700000040000},
+		{"on a multiple", 1700000040000, 60000, 1700000040000, 1700000040000},
+		{"negative value", -1500, 1000, -2000, -1000},
+		{"without step", 1700000012345, 0, 1700000012345, 1700000012345},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedDown, roundDown(tt.value, tt.step))
+			assert.Equal(t, tt.expectedUp, roundUp(tt.value, tt.step))
+		})
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	assert.Equal(t, "1d", formatInterval(24*time.Hour))
+	assert.Equal(t, "2h", formatInterval(2*time.Hour))
+	assert.Equal(t, "90m", formatInterval(90*time.Minute))
+	assert.Equal(t, "30s", formatInterval(30*time.Second))
+	assert.Equal(t, "1500ms", formatInterval(1500*time.Millisecond))
+}
+
+func TestApplyMacros_HideTimeFilter(t *testing.T) {
+	mc := macroContext{
+		timeRange: backend.TimeRange{
+			From: time.UnixMilli(1700000000000),
+			To:   time.UnixMilli(1700003600000),
+		},
+		hideTimeFilter: true,
+	}
+
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+	}{
+		{
+			name:     "replaces time filter with an always-true predicate",
+			sql:      "SELECT * FROM airlineStats WHERE $__timeFilter(ts) AND carrier = 'AA'",
+			expected: "SELECT * FROM airlineStats WHERE 1 = 1 AND carrier = 'AA'",
+		},
+		{
+			name:     "widens time bounds to the whole epoch range",
+			sql:      "SELECT * FROM airlineStats WHERE ts BETWEEN $__timeFrom AND $__timeTo",
+			expected: "SELECT * FROM airlineStats WHERE ts BETWEEN 0 AND 9223372036854775807",
+		},
+		{
+			name:     "widens rounded time bounds to the whole epoch range",
+			sql:      "SELECT * FROM airlineStats WHERE ts BETWEEN $__timeFromRounded AND $__timeToRounded",
+			expected: "SELECT * FROM airlineStats WHERE ts BETWEEN 0 AND 9223372036854775807",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMacros(tt.sql, mc)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+			assert.NotContains(t, result, "1700000000000")
+		})
+	}
+}
+
+func TestApplyMacros_Table(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		database string
+		expected string
+		errorMsg string
+	}{
+		{"qualifies the table", "SELECT * FROM $__table(events)", "analytics", "SELECT * FROM analytics.events", ""},
+		{"keeps a qualified table", "SELECT * FROM $__table(other.events)", "analytics", "SELECT * FROM other.events", ""},
+		{"keeps the table without a database", "SELECT * FROM $__table(events)", "", "SELECT * FROM events", ""},
+		{"requires a table", "SELECT * FROM $__table()", "analytics", "", "macro $__table requires a table argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMacros(tt.sql, macroContext{database: tt.database, interval: time.Minute})
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestApplyMacros_TimeGroupCalendar(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		timezone string
+		expected string
+	}{
+		{
+			name:     "truncates days in the timezone",
+			sql:      "SELECT $__timeGroup(ts, '1d') FROM airlineStats",
+			timezone: "Europe/Berlin",
+			expected: "SELECT DATETRUNC('DAY', ts, 'MILLISECONDS', 'Europe/Berlin', 'MILLISECONDS') FROM airlineStats",
+		},
+		{
+			name:     "accepts named units",
+			sql:      "SELECT $__timeGroup(ts, 'Week') FROM airlineStats",
+			timezone: "America/New_York",
+			expected: "SELECT DATETRUNC('WEEK', ts, 'MILLISECONDS', 'America/New_York', 'MILLISECONDS') FROM airlineStats",
+		},
+		{
+			name:     "distinguishes months from minutes",
+			sql:      "SELECT $__timeGroup(ts, '1M'), $__timeGroup(ts, '1m') FROM airlineStats",
+			timezone: "Asia/Tokyo",
+			expected: "SELECT DATETRUNC('MONTH', ts, 'MILLISECONDS', 'Asia/Tokyo', 'MILLISECONDS'), DATETIMECONVERT(ts, '1:MILLISECONDS:EPOCH', '1:MILLISECONDS:EPOCH', '60000:MILLISECONDS') FROM airlineStats",
+		},
+		{
+			name:     "defaults to UTC",
+			sql:      "SELECT $__timeGroup(ts, 'year') FROM airlineStats",
+			expected: "SELECT DATETRUNC('YEAR', ts, 'MILLISECONDS', 'UTC', 'MILLISECONDS') FROM airlineStats",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMacros(tt.sql, macroContext{interval: time.Minute, timezone: tt.timezone})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestApplyMacros_DateStrings(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.UnixMilli(1700000000000),
+		To:   time.UnixMilli(1700003600000),
+	}
+
+	tests := []struct {
+		name     string
+		sql      string
+		timezone string
+		hide     bool
+		expected string
+		errorMsg string
+	}{
+		{
+			name:     "filters with date strings",
+			sql:      "SELECT * FROM events WHERE $__timeFilter(day, 'yyyy-MM-dd')",
+			expected: "SELECT * FROM events WHERE day >= '2023-11-14' AND day <= '2023-11-14'",
+		},
+		{
+			name:     "formats the bounds in the timezone",
+			sql:      "SELECT * FROM events WHERE $__timeFilter(hour, 'yyyyMMddHH')",
+			timezone: "Asia/Kolkata",
+			expected: "SELECT * FROM events WHERE hour >= '2023111503' AND hour <= '2023111504'",
+		},
+		{
+			name:     "expands formatted bounds",
+			sql:      "SELECT * FROM events WHERE ts BETWEEN $__timeFrom('yyyy-MM-dd''T''HH:mm:ss') AND $__timeTo('yyyy-MM-dd''T''HH:mm:ss')",
+			expected: "SELECT * FROM events WHERE ts BETWEEN '2023-11-14T22:13:20' AND '2023-11-14T23:13:20'",
+		},
+		{
+			name:     "escapes quotes of the pattern",
+			sql:      "SELECT * FROM events WHERE $__timeFilter(day, 'yyyy''''MM')",
+			expected: "SELECT * FROM events WHERE day >= '2023''11' AND day <= '2023''11'",
+		},
+		{
+			name:     "widens formatted bounds without a time filter",
+			sql:      "SELECT * FROM events WHERE day BETWEEN $__timeFrom('yyyyMMdd') AND $__timeTo('yyyyMMdd')",
+			hide:     true,
+			expected: "SELECT * FROM events WHERE day BETWEEN '19700101' AND '99991231'",
+		},
+		{
+			name:     "requires a quoted pattern",
+			sql:      "SELECT * FROM events WHERE $__timeFilter(day, yyyyMMdd)",
+			errorMsg: "must be a single-quoted string",
+		},
+		{
+			name:     "rejects lone quotes in the pattern",
+			sql:      "SELECT * FROM events WHERE day = $__timeFrom('yyyy' OR 1=1 OR 'MM')",
+			errorMsg: "must be a single-quoted string",
+		},
+		{
+			name:     "rejects unsupported pattern letters",
+			sql:      "SELECT * FROM events WHERE day = $__timeTo('EEE')",
+			errorMsg: "invalid date pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyMacros(tt.sql, macroContext{timeRange: timeRange, interval: time.Minute, timezone: tt.timezone, hideTimeFilter: tt.hide})
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"2023-11-14", "'2023-11-14'"},
+		{"", "''"},
+		{"O'Hare", "'O''Hare'"},
+		{"' OR '1'='1", "''' OR ''1''=''1'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			literal := quoteLiteral(tt.value)
+			assert.Equal(t, tt.expected, literal)
+
+			// The literal ends only at its last quote and reads back as the value
+			assert.Equal(t, len(literal), literalEnd(literal, 0))
+			unquoted, ok := unquoteLiteral(literal)
+			require.True(t, ok)
+			assert.Equal(t, tt.value, unquoted)
+		})
+	}
+}
+
+func TestApplyMacros_Pagination(t *testing.T) {
+	result, err := applyMacros("SELECT * FROM t LIMIT $__offset, $__limit", macroContext{interval: time.Minute, offset: 200, limit: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM t LIMIT 200, 100", result)
+
+	result, err = applyMacros("SELECT * FROM t LIMIT $__offset, $__limit", macroContext{interval: time.Minute, limit: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM t LIMIT 0, 100", result)
+
+	_, err = applyMacros("SELECT * FROM t LIMIT $__limit", macroContext{interval: time.Minute})
+	assert.EqualError(t, err, "macro $__limit requires the limit of the query")
+}
@@ -1,20 +1,31 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
-	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ============================================================================
@@ -23,6 +34,62 @@ import (
 
 const PluginId = "yesoreyeram-pinot-datasource"
 
+// ErrControllerNotConfigured is returned by metadata operations when no controller URL is set
+var ErrControllerNotConfigured = errors.New("controller client not configured")
+
+// ErrControllerAuthFailed is returned by metadata operations when the controller rejects the credentials (401/403)
+var ErrControllerAuthFailed = errors.New("controller authentication failed")
+
+// StatusError is returned when a Pinot endpoint answers with an unexpected HTTP status
+type StatusError struct {
+	Operation  string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s", e.Operation, e.StatusCode, e.Body)
+}
+
+// isNotFound reports whether the error is a 404 answer of a Pinot endpoint
+func isNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+const (
+	// DefaultQueryTimeout bounds broker queries, which can be long-running analytical scans
+	DefaultQueryTimeout = 60 * time.Second
+
+	// DefaultMetadataTimeout bounds controller metadata calls (tables, schemas), which are expected to be fast
+	DefaultMetadataTimeout = 10 * time.Second
+
+	// DefaultHealthTimeout bounds the broker health probe, so an unresponsive broker fails the health check quickly
+	DefaultHealthTimeout = 10 * time.Second
+
+	// DefaultAccept is the response format requested from Pinot endpoints
+	DefaultAccept = "application/json"
+
+	// CorrelationIDHeader carries the per-query correlation ID to the broker
+	CorrelationIDHeader = "X-Request-Id"
+
+	// DefaultMaxQueryURLLength bounds the URL of GET queries, a common limit of proxies and gateways
+	DefaultMaxQueryURLLength = 8000
+
+	// DefaultSQLFieldName is the field holding the SQL in the broker query payload
+	DefaultSQLFieldName = "sql"
+
+	// DefaultRetryBackoff is the delay before the first query retry, doubled for each further retry
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	// DefaultScanRatioWarningThreshold is the fraction of scanned documents above which a query gets an index warning
+	DefaultScanRatioWarningThreshold = 0.5
+
+	// Transport defaults, matching net/http's DefaultTransport
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultExpectContinueTimeout = 1 * time.Second
+)
+
 // ============================================================================
 // TYPES - Authentication
 // ============================================================================
@@ -46,12 +113,85 @@ type HTTPClientConfig struct {
 	AuthType      AuthType `json:"authType"`
 	TlsSkipVerify bool     `json:"tlsSkipVerify"`
 	UserName      string   `json:"userName"`
+	TimeoutMs     int64    `json:"timeoutMs"` // HTTP client timeout of the endpoint (0 uses the 30s default)
 }
 
 // DataSourceConfig holds the public configuration for the datasource
 type DataSourceConfig struct {
 	Broker     *HTTPClientConfig `json:"broker"`
 	Controller *HTTPClientConfig `json:"controller"`
+
+	// Per-request deadlines in milliseconds (0 uses the defaults)
+	QueryTimeoutMs    int64 `json:"queryTimeoutMs"`
+	MetadataTimeoutMs int64 `json:"metadataTimeoutMs"`
+
+	// Query transport
+	QueryMethod       string `json:"queryMethod"`       // POST (default) or GET, for gateways that block request bodies
+	MaxQueryURLLength int    `json:"maxQueryUrlLength"` // Longest GET query URL before falling back to POST (defaults to DefaultMaxQueryURLLength)
+	SQLFieldName      string `json:"sqlFieldName"`      // Payload field (or GET parameter) holding the SQL, e.g. query for proxies (defaults to sql)
+
+	// Metadata listing
+	PreserveTableOrder bool `json:"preserveTableOrder"` // Lists tables in the controller order instead of alphabetically
+
+	// Query retries, limited to failures where the broker cannot have run the query
+	QueryRetries     int   `json:"queryRetries"`     // Retries of a query after a connection failure or a retryable status (0 disables them)
+	RetryStatusCodes []int `json:"retryStatusCodes"` // Broker statuses retried (defaults to 503)
+
+	// Query safety
+	AllowWriteQueries bool   `json:"allowWriteQueries"` // Allows statements other than SELECT, EXPLAIN and SET
+	MaxSQLLength      int    `json:"maxSqlLength"`      // Rejects queries whose expanded SQL is longer than this many bytes (0 disables the check)
+	MandatoryFilter   string `json:"mandatoryFilter"`   // Predicate ANDed into every query reading a table, e.g. account_id = 42 for row-level security
+
+	// Query options
+	EnableNullHandling bool   `json:"enableNullHandling"` // Sends enableNullHandling=true with every query so the broker returns SQL NULLs
+	BrokerTenant       string `json:"brokerTenant"`       // Sent as the brokerTenant query option of every query, for multi-tenant brokers
+
+	// Query rewriting
+	AutoTimeSeries          bool   `json:"autoTimeSeries"`          // Adds the time column to timeseries queries that do not select it
+	DefaultTimeColumn       string `json:"defaultTimeColumn"`       // Time column used by autoTimeSeries when the query sets none
+	DefaultLimit            int    `json:"defaultLimit"`            // LIMIT appended to SELECT queries without one (0 disables it)
+	DefaultLimitFromCluster bool   `json:"defaultLimitFromCluster"` // Without a defaultLimit, appends the default query limit of the cluster config
+	LimitToMaxDataPoints    bool   `json:"limitToMaxDataPoints"`    // Limits table queries without a LIMIT to the max data points of the panel
+	DefaultDatabase         string `json:"defaultDatabase"`         // Database qualifying bare table references, e.g. events becomes analytics.events
+	KeepComments            bool   `json:"keepComments"`            // Sends SQL comments to the broker instead of removing them before macros and validation
+
+	// Health check
+	HealthCheckTable string `json:"healthCheckTable"` // Table queried by the health check instead of SELECT 1
+
+	// Query diagnostics
+	DebugErrors               bool    `json:"debugErrors"`               // Keeps the Java stack traces of Pinot exceptions in query errors
+	DebugRawResponse          bool    `json:"debugRawResponse"`          // Exposes the raw broker response (up to MaxRawResponseBytes) as pinotRaw in frame meta
+	ScanRatioWarningThreshold float64 `json:"scanRatioWarningThreshold"` // Scanned/total docs ratio above which a notice is attached (defaults to DefaultScanRatioWarningThreshold)
+
+	// Result delivery
+	MaxRowsPerFrame int `json:"maxRowsPerFrame"` // Splits query results into frames of at most this many rows (0 disables splitting)
+	MaxColumns      int `json:"maxColumns"`      // Drops the result columns beyond this many, with a notice (0 keeps every column)
+
+	// Result conversion
+	StrictTypes      bool   `json:"strictTypes"`      // Fail on unrecognized column types instead of rendering them as strings
+	StrictTimeSeries bool   `json:"strictTimeSeries"` // Fail timeseries queries without a time column instead of returning a table with a warning
+	Timezone         string `json:"timezone"`         // IANA zone of time strings without an explicit offset (defaults to UTC)
+
+	LenientNumbers bool `json:"lenientNumbers"` // Parses DOUBLE/FLOAT strings with grouping separators or currency symbols
+	NullSentinels  bool `json:"nullSentinels"`  // Returns Pinot's default null values of numeric columns as nulls
+	GeoJSON        bool `json:"geoJSON"`        // Converts string columns of WKT geometries (e.g. ST_AsText results) to GeoJSON
+
+	// Grafana type (time, string, number or boolean) of columns by name or Pinot type, e.g. LONG epochs as time
+	TypeOverrides map[string]string `json:"typeOverrides,omitempty"`
+
+	// Regular expression of column names converted as strings whatever their type, e.g. .*_id$ for IDs
+	StringColumnPattern string `json:"stringColumnPattern"`
+
+	// Connection warm-up
+	KeepAliveIntervalMs int64 `json:"keepAliveIntervalMs"` // Interval of background broker health pings (0 disables them)
+
+	// Transport timeouts in milliseconds (0 uses the defaults)
+	IdleConnTimeoutMs       int64 `json:"idleConnTimeoutMs"`
+	ResponseHeaderTimeoutMs int64 `json:"responseHeaderTimeoutMs"` // Fails requests whose response headers do not arrive in time (0 waits for the request timeout)
+	ExpectContinueTimeoutMs int64 `json:"expectContinueTimeoutMs"`
+
+	// Protocol
+	DisableHTTP2 bool `json:"disableHttp2"` // Keeps broker and controller connections on HTTP/1.1 for endpoints that misbehave with HTTP/2
 }
 
 // SecureDataSourceConfig holds the secure/encrypted configuration for the datasource
@@ -78,6 +218,18 @@ type HTTPClientBuildConfig struct {
 	Token         string
 	TlsSkipVerify bool
 	Timeout       time.Duration
+	Accept        string // Accept header sent with every request (defaults to DefaultAccept)
+
+	// Transport timeouts
+	IdleConnTimeout       time.Duration // Defaults to DefaultIdleConnTimeout
+	ResponseHeaderTimeout time.Duration // Disabled when zero
+	ExpectContinueTimeout time.Duration // Defaults to DefaultExpectContinueTimeout
+
+	DisableHTTP2 bool // HTTP/2 is attempted by default
+
+	// Transport reused instead of creating one, so clients of the same host share connections
+	// The TLS and transport timeout settings above are ignored when it is set
+	Transport *http.Transport
 }
 
 // HTTPClient wraps http.Client with Pinot-specific authentication and configuration
@@ -87,6 +239,7 @@ type HTTPClient struct {
 	username   string
 	password   string
 	token      string
+	accept     string
 	httpClient *http.Client
 }
 
@@ -113,6 +266,30 @@ type PinotClientOptions struct {
 	ControllerToken         string
 	ControllerTlsSkipVerify bool
 	ControllerTimeout       time.Duration
+
+	// Per-request deadlines
+	QueryTimeout    time.Duration // Deadline for broker queries (defaults to DefaultQueryTimeout)
+	MetadataTimeout time.Duration // Deadline for controller metadata calls (defaults to DefaultMetadataTimeout)
+
+	// Query transport
+	QueryMethod       string // http.MethodPost (default) or http.MethodGet
+	MaxQueryURLLength int    // Defaults to DefaultMaxQueryURLLength
+	SQLFieldName      string // Defaults to DefaultSQLFieldName
+
+	// Metadata listing
+	PreserveTableOrder bool // Keeps the controller order of Tables instead of sorting it
+
+	// Query retries
+	QueryRetries     int           // Retries after a connection failure or a retryable status (0 disables them)
+	RetryStatusCodes []int         // Defaults to 503 Service Unavailable
+	RetryBackoff     time.Duration // Defaults to DefaultRetryBackoff
+
+	// Transport timeouts shared by the broker and controller clients
+	IdleConnTimeout       time.Duration
+	ResponseHeaderTimeout time.Duration // Lets a hung endpoint fail before the request deadline
+	ExpectContinueTimeout time.Duration
+
+	DisableHTTP2 bool
 }
 
 // PinotClient is the main client for interacting with Apache Pinot
@@ -120,6 +297,19 @@ type PinotClientOptions struct {
 type PinotClient struct {
 	brokerClient     *HTTPClient
 	controllerClient *HTTPClient
+	queryTimeout     time.Duration
+	metadataTimeout  time.Duration
+	healthTimeout    time.Duration
+
+	queryMethod       string
+	maxQueryURLLength int
+	sqlFieldName      string
+
+	preserveTableOrder bool
+
+	queryRetries     int
+	retryStatusCodes []int
+	retryBackoff     time.Duration
 }
 
 // TablesResponse represents the response from the tables API
@@ -127,13 +317,122 @@ type TablesResponse struct {
 	Tables []string `json:"tables"`
 }
 
+// UnmarshalJSON accepts both {"tables":[...]} and the {"OFFLINE":[...],"REALTIME":[...]} shape
+// returned by some controller versions. Tables listed under both types are merged, with any
+// _OFFLINE/_REALTIME suffix removed.
+func (r *TablesResponse) UnmarshalJSON(b []byte) error {
+	var resp struct {
+		Tables   *[]string `json:"tables"`
+		Offline  []string  `json:"OFFLINE"`
+		Realtime []string  `json:"REALTIME"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return err
+	}
+	if resp.Tables != nil || (resp.Offline == nil && resp.Realtime == nil) {
+		r.Tables = nil
+		if resp.Tables != nil {
+			r.Tables = *resp.Tables
+		}
+		return nil
+	}
+
+	r.Tables = []string{}
+	seen := map[string]bool{}
+	add := func(names []string, suffix string) {
+		for _, name := range names {
+			name = strings.TrimSuffix(name, suffix)
+			if !seen[name] {
+				seen[name] = true
+				r.Tables = append(r.Tables, name)
+			}
+		}
+	}
+	add(resp.Offline, "_"+TableTypeOffline)
+	add(resp.Realtime, "_"+TableTypeRealtime)
+	return nil
+}
+
+// InstancesResponse represents the response from the instances API
+type InstancesResponse struct {
+	Instances []string `json:"instances"` // Instance IDs such as Broker_host_8099 or Server_host_8098
+}
+
+// TableSchema represents the schema of a table returned by the controller
+type TableSchema struct {
+	SchemaName          string      `json:"schemaName"`
+	DimensionFieldSpecs []FieldSpec `json:"dimensionFieldSpecs"`
+	MetricFieldSpecs    []FieldSpec `json:"metricFieldSpecs"`
+	DateTimeFieldSpecs  []FieldSpec `json:"dateTimeFieldSpecs"`
+}
+
+// TableSize represents the storage size of a table reported by the controller
+type TableSize struct {
+	TableName            string            `json:"tableName"`
+	ReportedSizeInBytes  int64             `json:"reportedSizeInBytes"`
+	EstimatedSizeInBytes int64             `json:"estimatedSizeInBytes"`
+	OfflineSegments      *TableSegmentSize `json:"offlineSegments,omitempty"`
+	RealtimeSegments     *TableSegmentSize `json:"realtimeSegments,omitempty"`
+}
+
+// TableSegmentSize represents the size of the OFFLINE or REALTIME half of a table
+type TableSegmentSize struct {
+	ReportedSizeInBytes  int64 `json:"reportedSizeInBytes"`
+	EstimatedSizeInBytes int64 `json:"estimatedSizeInBytes"`
+	MissingSegments      int   `json:"missingSegments"`
+}
+
+// FieldSpec describes a single column of a table schema
+type FieldSpec struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType"`
+	Format   string `json:"format,omitempty"` // Format of dateTime columns, e.g. 1:SECONDS:EPOCH or SIMPLE_DATE_FORMAT|yyyyMMdd
+}
+
+// ============================================================================
+// TYPES - Pinot API
+// ============================================================================
+
+// PinotAPI is the set of Pinot operations the datasource depends on
+// PinotClient implements it over HTTP; tests can substitute a fake
+type PinotAPI interface {
+	// Broker operations
+	Health(ctx context.Context) error
+	Query(ctx context.Context, sql string) (*http.Response, error)
+	QueryWithOptions(ctx context.Context, sql string, options map[string]interface{}) (*http.Response, error)
+
+	// Controller operations, failing with ErrControllerNotConfigured without a controller
+	Tables(ctx context.Context) ([]string, error)
+	Schemas(ctx context.Context) ([]string, error)
+	TableSchema(ctx context.Context, table string) (*TableSchema, error)
+	TableSize(ctx context.Context, table string) (*TableSize, error)
+	Instances(ctx context.Context) ([]string, error)
+	ClusterConfigs(ctx context.Context) (map[string]interface{}, error)
+}
+
+var _ PinotAPI = (*PinotClient)(nil)
+
 // ============================================================================
 // TYPES - Grafana DataSource
 // ============================================================================
 
 // DataSource implements the Grafana datasource interface
 type DataSource struct {
-	client *PinotClient
+	client   PinotAPI
+	config   DataSourceConfig
+	location *time.Location // Loaded from config.Timezone
+
+	stringColumns *regexp.Regexp // Compiled from config.StringColumnPattern (nil without a pattern)
+
+	// Background keep-alive, see startKeepAlive
+	stopKeepAlive context.CancelFunc
+	keepAliveDone chan struct{}
+
+	// Table schemas fetched for enrichFromSchema queries
+	schemas schemaCache
+
+	// Default query limit read from the cluster config for defaultLimitFromCluster
+	clusterLimit clusterLimitCache
 }
 
 // ============================================================================
@@ -148,17 +447,41 @@ func NewHTTPClient(config HTTPClientBuildConfig) *HTTPClient {
 		timeout = 30 * time.Second
 	}
 
-	// Create TLS configuration
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: config.TlsSkipVerify,
+	accept := config.Accept
+	if accept == "" {
+		accept = DefaultAccept
 	}
 
-	// Create HTTP client with timeout and TLS config
+	idleConnTimeout := config.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = DefaultIdleConnTimeout
+	}
+	expectContinueTimeout := config.ExpectContinueTimeout
+	if expectContinueTimeout == 0 {
+		expectContinueTimeout = DefaultExpectContinueTimeout
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: config.TlsSkipVerify},
+			IdleConnTimeout:       idleConnTimeout,
+			ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+			ExpectContinueTimeout: expectContinueTimeout,
+
+			// A custom transport only attempts HTTP/2 when asked; a non-nil empty
+			// TLSNextProto map keeps it from upgrading TLS connections when disabled
+			ForceAttemptHTTP2: !config.DisableHTTP2,
+		}
+		if config.DisableHTTP2 {
+			transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+
+	// Create HTTP client with timeout and transport
 	httpClient := &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
+		Timeout:   timeout,
+		Transport: transport,
 	}
 
 	return &HTTPClient{
@@ -167,14 +490,18 @@ func NewHTTPClient(config HTTPClientBuildConfig) *HTTPClient {
 		username:   config.Username,
 		password:   config.Password,
 		token:      config.Token,
+		accept:     accept,
 		httpClient: httpClient,
 	}
 }
 
 // doRequest performs an HTTP request with authentication
 func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
-	url := c.url + path
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	endpoint, err := c.requestURL(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -182,6 +509,11 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body io
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	req.Header.Set("Accept", c.accept)
+
+	if correlationID := correlationIDFromContext(ctx); correlationID != "" {
+		req.Header.Set(CorrelationIDHeader, correlationID)
+	}
 
 	c.addAuth(req)
 
@@ -193,6 +525,126 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body io
 	return resp, nil
 }
 
+// requestURL joins the escaped request path, which may carry a query, to the base URL
+// A path prefix of the base URL (e.g. http://gateway/pinot-controller) is kept, and so are its
+// query parameters (e.g. a gateway API key), which come before those of the request
+func (c *HTTPClient) requestURL(path string) (string, error) {
+	base, err := url.Parse(c.url)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+
+	joined := *base
+	joined.Path = strings.TrimRight(base.Path, "/") + ref.Path
+	joined.RawPath = strings.TrimRight(base.EscapedPath(), "/") + ref.EscapedPath()
+	if ref.RawQuery != "" {
+		joined.RawQuery = strings.TrimPrefix(base.RawQuery+"&"+ref.RawQuery, "&")
+	}
+	return joined.String(), nil
+}
+
+// checkContentType verifies that the response media type is one the client accepts
+// Responses without a Content-Type are accepted, as some proxies strip it
+func (c *HTTPClient) checkContentType(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid response content type %q: %w", contentType, err)
+	}
+
+	for _, accepted := range strings.Split(c.accept, ",") {
+		accepted, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if accepted == "*/*" || accepted == mediaType {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(accepted, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected response content type %q, expected %s", mediaType, c.accept)
+}
+
+// correlationIDKey is the context key of the per-query correlation ID
+type correlationIDKey struct{}
+
+// withCorrelationID returns a context carrying a correlation ID for the requests made with it
+// An existing ID is kept; otherwise the upstream trace ID is used, or a new UUID without trace context
+func withCorrelationID(ctx context.Context) (context.Context, string) {
+	if correlationID := correlationIDFromContext(ctx); correlationID != "" {
+		return ctx, correlationID
+	}
+
+	correlationID := uuid.NewString()
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		correlationID = spanContext.TraceID().String()
+	}
+	return context.WithValue(ctx, correlationIDKey{}, correlationID), correlationID
+}
+
+// correlationIDFromContext returns the correlation ID of the context, if any
+func correlationIDFromContext(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
+
+// withTimeout derives a request-scoped context bounded by the given timeout
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// cancelOnClose releases a request-scoped context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the underlying body and cancels the request context
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// utf8BOM is the byte order mark some proxies prepend to JSON responses
+const utf8BOM = "\xEF\xBB\xBF"
+
+// newResponseDecoder returns a JSON decoder of a Pinot response keeping numbers as json.Number
+// Leading whitespace and a UTF-8 byte order mark are skipped
+func newResponseDecoder(r io.Reader) *json.Decoder {
+	buffered := bufio.NewReader(r)
+	for {
+		b, err := buffered.Peek(len(utf8BOM))
+		if err == nil && string(b) == utf8BOM {
+			_, _ = buffered.Discard(len(utf8BOM))
+			continue
+		}
+		if len(b) > 0 && strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			_, _ = buffered.Discard(1)
+			continue
+		}
+		break
+	}
+
+	decoder := json.NewDecoder(buffered)
+	decoder.UseNumber()
+	return decoder
+}
+
 // addAuth adds authentication headers to the HTTP request based on auth type
 func (c *HTTPClient) addAuth(req *http.Request) {
 	switch c.authType {
@@ -227,6 +679,41 @@ func New(opts PinotClientOptions) (*PinotClient, error) {
 	if opts.ControllerTimeout == 0 {
 		opts.ControllerTimeout = 30 * time.Second
 	}
+	if opts.QueryTimeout == 0 {
+		opts.QueryTimeout = DefaultQueryTimeout
+	}
+	if opts.MetadataTimeout == 0 {
+		opts.MetadataTimeout = DefaultMetadataTimeout
+	}
+	if opts.MaxQueryURLLength == 0 {
+		opts.MaxQueryURLLength = DefaultMaxQueryURLLength
+	}
+	opts.SQLFieldName = strings.TrimSpace(opts.SQLFieldName)
+	if opts.SQLFieldName == "" {
+		opts.SQLFieldName = DefaultSQLFieldName
+	}
+	if opts.SQLFieldName == "queryOptions" {
+		return nil, fmt.Errorf("invalid SQL field name %q, it holds the query options", opts.SQLFieldName)
+	}
+	if len(opts.RetryStatusCodes) == 0 {
+		opts.RetryStatusCodes = []int{http.StatusServiceUnavailable}
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+
+	opts.QueryMethod = strings.ToUpper(opts.QueryMethod)
+	if opts.QueryMethod == "" {
+		opts.QueryMethod = http.MethodPost
+	}
+	if opts.QueryMethod != http.MethodPost && opts.QueryMethod != http.MethodGet {
+		return nil, fmt.Errorf("invalid query method %q, expected POST or GET", opts.QueryMethod)
+	}
+
+	// The client timeout is only a backstop; it must not cut a longer query deadline short
+	if opts.QueryTimeout > opts.BrokerTimeout {
+		opts.BrokerTimeout = opts.QueryTimeout
+	}
 
 	// Create broker HTTP client with separate TLS configuration
 	brokerClient := NewHTTPClient(HTTPClientBuildConfig{
@@ -237,11 +724,22 @@ func New(opts PinotClientOptions) (*PinotClient, error) {
 		Token:         opts.BrokerToken,
 		TlsSkipVerify: opts.BrokerTlsSkipVerify,
 		Timeout:       opts.BrokerTimeout,
+
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+		ExpectContinueTimeout: opts.ExpectContinueTimeout,
+		DisableHTTP2:          opts.DisableHTTP2,
 	})
 
 	// Create controller HTTP client with separate TLS configuration (if URL provided)
+	// A controller on the broker host (e.g. behind a gateway, differing by path prefix) shares its connections
 	var controllerClient *HTTPClient
 	if opts.ControllerUrl != "" {
+		var sharedTransport *http.Transport
+		if sameHost(opts.BrokerUrl, opts.ControllerUrl) && opts.BrokerTlsSkipVerify == opts.ControllerTlsSkipVerify {
+			sharedTransport, _ = brokerClient.httpClient.Transport.(*http.Transport)
+		}
+
 		controllerClient = NewHTTPClient(HTTPClientBuildConfig{
 			URL:           opts.ControllerUrl,
 			AuthType:      opts.ControllerAuthType,
@@ -250,21 +748,63 @@ func New(opts PinotClientOptions) (*PinotClient, error) {
 			Token:         opts.ControllerToken,
 			TlsSkipVerify: opts.ControllerTlsSkipVerify,
 			Timeout:       opts.ControllerTimeout,
+
+			IdleConnTimeout:       opts.IdleConnTimeout,
+			ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+			ExpectContinueTimeout: opts.ExpectContinueTimeout,
+			DisableHTTP2:          opts.DisableHTTP2,
+
+			Transport: sharedTransport,
 		})
 	}
 
 	return &PinotClient{
 		brokerClient:     brokerClient,
 		controllerClient: controllerClient,
+		queryTimeout:     opts.QueryTimeout,
+		metadataTimeout:  opts.MetadataTimeout,
+		healthTimeout:    DefaultHealthTimeout,
+
+		queryMethod:       opts.QueryMethod,
+		maxQueryURLLength: opts.MaxQueryURLLength,
+		sqlFieldName:      opts.SQLFieldName,
+
+		preserveTableOrder: opts.PreserveTableOrder,
+
+		queryRetries:     opts.QueryRetries,
+		retryStatusCodes: opts.RetryStatusCodes,
+		retryBackoff:     opts.RetryBackoff,
 	}, nil
 }
 
+// CloseIdleConnections closes the idle connections of the broker and controller transports
+// Connections in use are left open and closed once their request completes
+func (c *PinotClient) CloseIdleConnections() {
+	c.brokerClient.httpClient.CloseIdleConnections()
+	if c.controllerClient != nil {
+		c.controllerClient.httpClient.CloseIdleConnections()
+	}
+}
+
+// sameHost reports whether both URLs have the same scheme and host (including port)
+func sameHost(a, b string) bool {
+	urlA, errA := url.Parse(a)
+	urlB, errB := url.Parse(b)
+	if errA != nil || errB != nil || urlA.Host == "" {
+		return false
+	}
+	return strings.EqualFold(urlA.Scheme, urlB.Scheme) && strings.EqualFold(urlA.Host, urlB.Host)
+}
+
 // ============================================================================
 // PINOT CLIENT - Broker Operations
 // ============================================================================
 
-// Health checks the health of the Pinot broker
+// Health checks the health of the Pinot broker, within the health probe deadline
 func (c *PinotClient) Health(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, c.healthTimeout)
+	defer cancel()
+
 	resp, err := c.brokerClient.doRequest(ctx, "GET", "/health", nil)
 	if err != nil {
 		return fmt.Errorf("failed to connect to Pinot broker: %w", err)
@@ -273,79 +813,334 @@ func (c *PinotClient) Health(ctx context.Context) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("health check failed with status %d: %s", resp.StatusCode, string(body))
+		return &StatusError{Operation: "health check", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	return nil
 }
 
 // Query executes a SQL query against the Pinot broker
+// The query deadline stays active until the returned response body is closed
 func (c *PinotClient) Query(ctx context.Context, sql string) (*http.Response, error) {
-	queryPayload := fmt.Sprintf(`{"sql": "%s"}`, sql)
+	return c.QueryWithOptions(ctx, sql, nil)
+}
 
-	resp, err := c.brokerClient.doRequest(ctx, "POST", "/query/sql", strings.NewReader(queryPayload))
+// QueryWithOptions executes a SQL query with broker query options (e.g. useMultiStageEngine)
+// Failed attempts are retried only when the broker cannot have run the query, see queryRetryable
+func (c *PinotClient) QueryWithOptions(ctx context.Context, sql string, options map[string]interface{}) (*http.Response, error) {
+	method, path, body, err := c.queryRequest(sql, options)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
+	// The payload is kept so every attempt sends the same request
+	var payload []byte
+	if body != nil {
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("failed to read query payload: %w", err)
+		}
+	}
+
+	ctx, cancel := withTimeout(ctx, c.queryTimeout)
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		resp, err = c.brokerClient.doRequest(ctx, method, path, reqBody)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			respBody, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = &StatusError{Operation: "query", StatusCode: resp.StatusCode, Body: string(respBody)}
+		}
+		if err == nil {
+			break
+		}
+		if attempt >= c.queryRetries || !c.queryRetryable(err) || !sleepContext(ctx, c.retryBackoff<<attempt) {
+			cancel()
+			return nil, err
+		}
+		backend.Logger.Debug("Retrying query", "attempt", attempt+1, "error", err)
+	}
+
+	if err := c.brokerClient.checkContentType(resp); err != nil {
 		resp.Body.Close()
-		return nil, fmt.Errorf("query failed with status %d: %s", resp.StatusCode, string(body))
+		cancel()
+		return nil, err
 	}
 
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
 	return resp, nil
 }
 
+// queryRetryable reports whether a failed query attempt can be retried without the risk of running
+// the query twice: the connection to the broker could not be established, or the broker answered
+// with a retryable status such as 503. Timeouts and dropped connections are never retried, as the
+// broker may still be executing the query.
+func (c *PinotClient) queryRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(c.retryStatusCodes, statusErr.StatusCode)
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" && !opErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsTimeout
+}
+
+// sleepContext waits for the duration and reports false when the context ends first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// queryRequest builds the broker request of a query: a JSON body for POST, or the URL-encoded SQL
+// for GET. GET queries whose URL would exceed the length limit fall back to POST.
+func (c *PinotClient) queryRequest(sql string, options map[string]interface{}) (string, string, io.Reader, error) {
+	encodedOptions := encodeQueryOptions(options)
+
+	if c.queryMethod == http.MethodGet {
+		path := "/query/sql?" + url.QueryEscape(c.sqlFieldName) + "=" + url.QueryEscape(sql)
+		if encodedOptions != "" {
+			path += "&queryOptions=" + url.QueryEscape(encodedOptions)
+		}
+		if len(c.brokerClient.url)+len(path) <= c.maxQueryURLLength {
+			return http.MethodGet, path, nil, nil
+		}
+		backend.Logger.Debug("Query URL too long for GET, falling back to POST", "length", len(c.brokerClient.url)+len(path), "limit", c.maxQueryURLLength)
+	}
+
+	// Keep comparison operators readable in the payload instead of HTML-escaping them
+	var queryPayload bytes.Buffer
+	encoder := json.NewEncoder(&queryPayload)
+	encoder.SetEscapeHTML(false)
+	payload := map[string]string{c.sqlFieldName: sql}
+	if encodedOptions != "" {
+		payload["queryOptions"] = encodedOptions
+	}
+	if err := encoder.Encode(payload); err != nil {
+		return "", "", nil, fmt.Errorf("failed to encode query: %w", err)
+	}
+
+	return http.MethodPost, "/query/sql", &queryPayload, nil
+}
+
+// encodeQueryOptions formats query options as the key=value;key=value string expected by the broker
+func encodeQueryOptions(options map[string]interface{}) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, options[key]))
+	}
+	return strings.Join(pairs, ";")
+}
+
 // ============================================================================
 // PINOT CLIENT - Controller Operations
 // ============================================================================
 
-// Tables retrieves the list of tables from the Pinot controller
+// Tables retrieves the list of tables from the Pinot controller, sorted alphabetically ignoring case
+// unless PreserveTableOrder is set
 func (c *PinotClient) Tables(ctx context.Context) ([]string, error) {
+	var tablesResp TablesResponse
+	if err := c.getControllerJSON(ctx, "/tables", "list tables", &tablesResp); err != nil {
+		return nil, err
+	}
+
+	// Misconfigured clusters may list blank or padded names, which would pollute the table pickers
+	tables := tablesResp.Tables[:0]
+	for _, table := range tablesResp.Tables {
+		if table = strings.TrimSpace(table); table != "" {
+			tables = append(tables, table)
+		}
+	}
+
+	// The controller order is unstable, so the table pickers list tables alphabetically by default
+	if !c.preserveTableOrder {
+		slices.SortStableFunc(tables, func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		})
+	}
+	return tables, nil
+}
+
+// Instances retrieves the IDs of the cluster instances (controllers, brokers, servers, minions) from the Pinot controller
+func (c *PinotClient) Instances(ctx context.Context) ([]string, error) {
+	var instancesResp InstancesResponse
+	if err := c.getControllerJSON(ctx, "/instances", "list instances", &instancesResp); err != nil {
+		return nil, err
+	}
+
+	return instancesResp.Instances, nil
+}
+
+// ClusterConfigs retrieves the cluster configuration (e.g. broker query defaults) from the Pinot controller
+func (c *PinotClient) ClusterConfigs(ctx context.Context) (map[string]interface{}, error) {
+	var configs map[string]interface{}
+	if err := c.getControllerJSON(ctx, "/cluster/configs", "get cluster configs", &configs); err != nil {
+		return nil, err
+	}
+
+	return configs, nil
+}
+
+// TableSchema retrieves the schema of a table from the controller
+func (c *PinotClient) TableSchema(ctx context.Context, table string) (*TableSchema, error) {
+	var schema TableSchema
+	if err := c.getControllerJSON(ctx, "/tables/"+url.PathEscape(table)+"/schema", "get table schema", &schema); err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+// TableSize retrieves the storage size of a table from the controller
+func (c *PinotClient) TableSize(ctx context.Context, table string) (*TableSize, error) {
+	var size TableSize
+	if err := c.getControllerJSON(ctx, "/tables/"+url.PathEscape(table)+"/size", "get table size", &size); err != nil {
+		return nil, err
+	}
+
+	return &size, nil
+}
+
+// getControllerJSON performs a metadata GET request on the controller and decodes the JSON response
+func (c *PinotClient) getControllerJSON(ctx context.Context, path, operation string, v interface{}) error {
 	if c.controllerClient == nil {
-		return nil, fmt.Errorf("controller client not configured")
+		return ErrControllerNotConfigured
 	}
 
-	resp, err := c.controllerClient.doRequest(ctx, "GET", "/tables", nil)
+	ctx, cancel := withTimeout(ctx, c.metadataTimeout)
+	defer cancel()
+
+	resp, err := c.controllerClient.doRequest(ctx, "GET", path, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to Pinot controller: %w", err)
+		return fmt.Errorf("failed to connect to Pinot controller: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("list tables failed with status %d: %s", resp.StatusCode, string(body))
+		statusErr := &StatusError{Operation: operation, StatusCode: resp.StatusCode, Body: string(body)}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("%w, check the controller authentication settings: %w", ErrControllerAuthFailed, statusErr)
+		}
+		return statusErr
+	}
+
+	if err := c.controllerClient.checkContentType(resp); err != nil {
+		return err
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var tablesResp TablesResponse
-	if err := json.Unmarshal(body, &tablesResp); err != nil {
-		return nil, fmt.Errorf("failed to parse tables response: %w", err)
+	decoder := newResponseDecoder(bytes.NewReader(body))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", operation, err)
 	}
 
-	return tablesResp.Tables, nil
+	return nil
 }
 
 // Schemas retrieves schema information from the Pinot controller
 // TODO: Implement schema retrieval from controller API
 func (c *PinotClient) Schemas(ctx context.Context) ([]string, error) {
 	if c.controllerClient == nil {
-		return nil, fmt.Errorf("controller client not configured")
+		return nil, ErrControllerNotConfigured
 	}
 
 	// Placeholder for future implementation
 	return []string{}, nil
 }
 
+// looksLikeController reports whether the endpoint serves the controller's table listing
+// It is used to diagnose a controller URL configured as the broker
+func (c *PinotClient) looksLikeController(ctx context.Context, client *HTTPClient) bool {
+	return respondsOK(ctx, client, "GET", "/tables", nil, c.metadataTimeout)
+}
+
+// looksLikeBroker reports whether the endpoint answers the SQL query like a broker, sent the way
+// queries are (see queryRequest). It is used to diagnose a broker URL configured as the controller
+func (c *PinotClient) looksLikeBroker(ctx context.Context, client *HTTPClient, sql string) bool {
+	method, path, body, err := c.queryRequest(sql, nil)
+	if err != nil {
+		return false
+	}
+	return respondsOK(ctx, client, method, path, body, c.metadataTimeout)
+}
+
+// respondsOK reports whether the request succeeds with a 200 status within the timeout
+func respondsOK(ctx context.Context, client *HTTPClient, method, path string, body io.Reader, timeout time.Duration) bool {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := client.doRequest(ctx, method, path, body)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
 // ============================================================================
 // DATASOURCE - Grafana Interface Implementation
 // ============================================================================
 
+// healthCheckQuery returns the query run by the health check: a count on the configured
+// health check table, for clusters where SELECT 1 is not valid, or SELECT 1
+func (ds *DataSource) healthCheckQuery() string {
+	if ds.config.HealthCheckTable == "" {
+		return "SELECT 1"
+	}
+	table := strings.ReplaceAll(ds.config.HealthCheckTable, `"`, `""`)
+	return fmt.Sprintf(`SELECT COUNT(*) FROM "%s" LIMIT 1`, table)
+}
+
+// verifyHealthCheckTable checks with the controller that the health check table has a schema
+// and, when a default time column is configured, that the schema declares it
+func (ds *DataSource) verifyHealthCheckTable(ctx context.Context) (string, error) {
+	table := stripTableType(ds.config.HealthCheckTable)
+	schema, err := ds.client.TableSchema(ctx, table)
+	if isNotFound(err) {
+		return "", fmt.Errorf("health check table %q was not found on the controller", table)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get the schema of health check table %q: %w", table, err)
+	}
+
+	column := ds.config.DefaultTimeColumn
+	if column == "" {
+		return fmt.Sprintf("✓ Health check table %s found", table), nil
+	}
+	for _, specs := range [][]FieldSpec{schema.DateTimeFieldSpecs, schema.DimensionFieldSpecs, schema.MetricFieldSpecs} {
+		for _, spec := range specs {
+			if strings.EqualFold(spec.Name, column) {
+				return fmt.Sprintf("✓ Health check table %s found with time column %s", table, column), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("default time column %q was not found in the schema of table %q", column, table)
+}
+
 // CheckHealth performs a health check on the datasource
 func (ds *DataSource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	var healthMessages []string
@@ -360,23 +1155,38 @@ func (ds *DataSource) CheckHealth(ctx context.Context, req *backend.CheckHealthR
 	healthMessages = append(healthMessages, "✓ Broker health check passed")
 
 	// Test broker query endpoint with a simple query
-	resp, err := ds.client.Query(ctx, "SELECT 1")
-	if err != nil {
+	// Errors are interpreted like those of panel queries, including exceptions in a 200 response
+	// The query is sent unguarded: the mandatory filter targets user queries, and the health check
+	// table may not have its column
+	if _, err := ds.sendQuery(ctx, ds.healthCheckQuery(), nil); err != nil {
+		message := fmt.Sprintf("Broker connected, but query test failed: %v", err)
+		if client, ok := ds.client.(*PinotClient); ok && isNotFound(err) && client.looksLikeController(ctx, client.brokerClient) {
+			message += "\nThe broker URL appears to point to a Pinot controller (usually port 9000), check that the broker and controller URLs are not swapped"
+		}
 		return &backend.CheckHealthResult{
 			Status:  backend.HealthStatusError,
-			Message: fmt.Sprintf("Broker connected, but query test failed: %v", err),
+			Message: message,
 		}, nil
 	}
-	resp.Body.Close()
-	healthMessages = append(healthMessages, "✓ Broker query endpoint verified")
+	if ds.config.HealthCheckTable != "" {
+		healthMessages = append(healthMessages, fmt.Sprintf("✓ Broker query endpoint verified (table %s)", ds.config.HealthCheckTable))
+	} else {
+		healthMessages = append(healthMessages, "✓ Broker query endpoint verified")
+	}
 
 	// Check controller if configured
-	if ds.client.controllerClient != nil {
-		tables, err := ds.client.Tables(ctx)
+	if tables, err := ds.client.Tables(ctx); !errors.Is(err, ErrControllerNotConfigured) {
 		if err != nil {
+			message := fmt.Sprintf("Controller connection failed: %v", err)
+			if errors.Is(err, ErrControllerAuthFailed) {
+				message = fmt.Sprintf("Broker connected, but %v", err)
+			}
+			if client, ok := ds.client.(*PinotClient); ok && isNotFound(err) && client.looksLikeBroker(ctx, client.controllerClient, ds.healthCheckQuery()) {
+				message += "\nThe controller URL appears to point to a Pinot broker (usually port 8099), check that the broker and controller URLs are not swapped"
+			}
 			return &backend.CheckHealthResult{
 				Status:  backend.HealthStatusError,
-				Message: fmt.Sprintf("Controller connection failed: %v", err),
+				Message: message,
 			}, nil
 		}
 		if len(tables) == 0 {
@@ -384,6 +1194,17 @@ func (ds *DataSource) CheckHealth(ctx context.Context, req *backend.CheckHealthR
 		} else {
 			healthMessages = append(healthMessages, fmt.Sprintf("✓ Controller connected (%d tables available)", len(tables)))
 		}
+
+		if ds.config.HealthCheckTable != "" {
+			message, err := ds.verifyHealthCheckTable(ctx)
+			if err != nil {
+				return &backend.CheckHealthResult{
+					Status:  backend.HealthStatusError,
+					Message: fmt.Sprintf("Controller connected, but %v", err),
+				}, nil
+			}
+			healthMessages = append(healthMessages, message)
+		}
 	} else {
 		healthMessages = append(healthMessages, "⚠ Controller URL not configured (metadata operations unavailable)")
 	}
@@ -395,34 +1216,67 @@ func (ds *DataSource) CheckHealth(ctx context.Context, req *backend.CheckHealthR
 }
 
 // QueryData handles query requests from Grafana
-// TODO: Implement actual query execution and data transformation
 func (ds *DataSource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	response := backend.NewQueryDataResponse()
 
 	for _, q := range req.Queries {
-		frame := data.NewFrame(
-			q.QueryType,
-			data.NewField("response", nil, []string{"pinot response"}),
-		).SetMeta(
-			&data.FrameMeta{
-				Notices: []data.Notice{
-					{Text: "Apache Pinot™ query works, but not fully implemented"},
-				},
-			},
-		)
-
-		response.Responses[q.RefID] = backend.DataResponse{
-			Frames: data.Frames{frame},
-			Status: backend.StatusOK,
-		}
+		response.Responses[q.RefID] = ds.executeQuery(ctx, q)
 	}
 
 	return response, nil
 }
 
-// Dispose cleans up resources when the datasource instance is removed
+// Dispose cleans up resources when the datasource instance is removed: the background
+// goroutines are stopped before the idle connections of the client are closed
 func (ds *DataSource) Dispose() {
 	backend.Logger.Debug("disposing plugin instance")
+
+	if ds.stopKeepAlive != nil {
+		ds.stopKeepAlive()
+		<-ds.keepAliveDone
+	}
+	if closer, ok := ds.client.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// ============================================================================
+// DATASOURCE - Keep-Alive
+// ============================================================================
+
+// startKeepAlive periodically pings the broker health endpoint so pooled connections stay warm
+// for low-traffic dashboards; the goroutine runs until Dispose is called
+func (ds *DataSource) startKeepAlive(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	ds.stopKeepAlive = cancel
+	ds.keepAliveDone = done
+
+	go func() {
+		defer close(done)
+
+		for {
+			timer := time.NewTimer(jitter(interval))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				if err := ds.client.Health(ctx); err != nil && ctx.Err() == nil {
+					backend.Logger.Debug("Keep-alive ping failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// jitter spreads the interval by ±10% so instances sharing a broker do not ping in lockstep
+func jitter(interval time.Duration) time.Duration {
+	spread := interval / 5
+	if spread <= 0 {
+		return interval
+	}
+	return interval - spread/2 + rand.N(spread)
 }
 
 // ============================================================================
@@ -478,11 +1332,13 @@ func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInsta
 	brokerAuthType := AuthTypeNone
 	brokerUsername := ""
 	brokerTlsSkipVerify := false
+	var brokerTimeout time.Duration
 	if config.Broker != nil {
 		brokerUrl = config.Broker.Url
 		brokerAuthType = config.Broker.AuthType
 		brokerUsername = config.Broker.UserName
 		brokerTlsSkipVerify = config.Broker.TlsSkipVerify
+		brokerTimeout = time.Duration(config.Broker.TimeoutMs) * time.Millisecond
 	}
 
 	// Extract controller config with defaults
@@ -490,11 +1346,21 @@ func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInsta
 	controllerAuthType := AuthTypeNone
 	controllerUsername := ""
 	controllerTlsSkipVerify := false
+	var controllerTimeout time.Duration
 	if config.Controller != nil {
 		controllerUrl = config.Controller.Url
 		controllerAuthType = config.Controller.AuthType
 		controllerUsername = config.Controller.UserName
 		controllerTlsSkipVerify = config.Controller.TlsSkipVerify
+		controllerTimeout = time.Duration(config.Controller.TimeoutMs) * time.Millisecond
+	}
+
+	// Make insecure TLS configurations visible to operators
+	if brokerTlsSkipVerify {
+		backend.Logger.Warn("TLS certificate verification is disabled", "endpoint", "broker", "url", brokerUrl)
+	}
+	if controllerTlsSkipVerify && controllerUrl != "" {
+		backend.Logger.Warn("TLS certificate verification is disabled", "endpoint", "controller", "url", controllerUrl)
 	}
 
 	// Create Pinot client with separate configurations for broker and controller
@@ -506,7 +1372,7 @@ func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInsta
 		BrokerPassword:      secureConfig.BrokerPassword,
 		BrokerToken:         secureConfig.BrokerToken,
 		BrokerTlsSkipVerify: brokerTlsSkipVerify,
-		BrokerTimeout:       30 * time.Second,
+		BrokerTimeout:       brokerTimeout,
 
 		// Controller configuration
 		ControllerUrl:           controllerUrl,
@@ -515,7 +1381,30 @@ func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInsta
 		ControllerPassword:      secureConfig.ControllerPassword,
 		ControllerToken:         secureConfig.ControllerToken,
 		ControllerTlsSkipVerify: controllerTlsSkipVerify,
-		ControllerTimeout:       30 * time.Second,
+		ControllerTimeout:       controllerTimeout,
+
+		// Per-request deadlines
+		QueryTimeout:    time.Duration(config.QueryTimeoutMs) * time.Millisecond,
+		MetadataTimeout: time.Duration(config.MetadataTimeoutMs) * time.Millisecond,
+
+		// Query transport
+		QueryMethod:       config.QueryMethod,
+		MaxQueryURLLength: config.MaxQueryURLLength,
+		SQLFieldName:      config.SQLFieldName,
+
+		// Metadata listing
+		PreserveTableOrder: config.PreserveTableOrder,
+
+		// Query retries
+		QueryRetries:     config.QueryRetries,
+		RetryStatusCodes: config.RetryStatusCodes,
+
+		// Transport timeouts
+		IdleConnTimeout:       time.Duration(config.IdleConnTimeoutMs) * time.Millisecond,
+		ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutMs) * time.Millisecond,
+		ExpectContinueTimeout: time.Duration(config.ExpectContinueTimeoutMs) * time.Millisecond,
+
+		DisableHTTP2: config.DisableHTTP2,
 	})
 
 	if err != nil {
@@ -523,7 +1412,42 @@ func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInsta
 		return nil, fmt.Errorf("failed to create Pinot client: %w", err)
 	}
 
-	return &DataSource{
-		client: client,
-	}, nil
+	location := time.UTC
+	if config.Timezone != "" {
+		location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			backend.Logger.Error("Invalid timezone", "timezone", config.Timezone, "error", err)
+			return nil, fmt.Errorf("invalid timezone %q: %w", config.Timezone, err)
+		}
+	}
+
+	if err := validateTypeOverrides(config.TypeOverrides); err != nil {
+		backend.Logger.Error("Invalid type overrides", "error", err)
+		return nil, err
+	}
+	var stringColumns *regexp.Regexp
+	if config.StringColumnPattern != "" {
+		if stringColumns, err = regexp.Compile(config.StringColumnPattern); err != nil {
+			backend.Logger.Error("Invalid string column pattern", "pattern", config.StringColumnPattern, "error", err)
+			return nil, fmt.Errorf("invalid string column pattern %q: %w", config.StringColumnPattern, err)
+		}
+	}
+	config.MandatoryFilter = strings.TrimSpace(config.MandatoryFilter)
+	if err := validateMandatoryFilter(config.MandatoryFilter); err != nil {
+		backend.Logger.Error("Invalid mandatory filter", "error", err)
+		return nil, err
+	}
+
+	ds := &DataSource{
+		client:        client,
+		config:        config,
+		location:      location,
+		stringColumns: stringColumns,
+	}
+
+	if config.KeepAliveIntervalMs > 0 {
+		ds.startKeepAlive(time.Duration(config.KeepAliveIntervalMs) * time.Millisecond)
+	}
+
+	return ds, nil
 }
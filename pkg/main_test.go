@@ -3,9 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -66,6 +72,45 @@ func TestNewHTTPClient(t *testing.T) {
 				assert.Equal(t, 30*time.Second, client.httpClient.Timeout)
 			},
 		},
+		{
+			name: "uses default transport timeouts when not specified",
+			config: HTTPClientBuildConfig{
+				URL:      "http://localhost:8099",
+				AuthType: AuthTypeNone,
+			},
+			validate: func(t *testing.T, client *HTTPClient) {
+				transport := client.httpClient.Transport.(*http.Transport)
+				assert.Equal(t, DefaultIdleConnTimeout, transport.IdleConnTimeout)
+				assert.Equal(t, time.Duration(0), transport.ResponseHeaderTimeout)
+				assert.Equal(t, DefaultExpectContinueTimeout, transport.ExpectContinueTimeout)
+			},
+		},
+		{
+			name: "attempts HTTP/2 by default",
+			config: HTTPClientBuildConfig{
+				URL:      "https://localhost:8099",
+				AuthType: AuthTypeNone,
+			},
+			validate: func(t *testing.T, client *HTTPClient) {
+				transport := client.httpClient.Transport.(*http.Transport)
+				assert.True(t, transport.ForceAttemptHTTP2)
+				assert.Nil(t, transport.TLSNextProto)
+			},
+		},
+		{
+			name: "disables HTTP/2 when requested",
+			config: HTTPClientBuildConfig{
+				URL:          "https://localhost:8099",
+				AuthType:     AuthTypeNone,
+				DisableHTTP2: true,
+			},
+			validate: func(t *testing.T, client *HTTPClient) {
+				transport := client.httpClient.Transport.(*http.Transport)
+				assert.False(t, transport.ForceAttemptHTTP2)
+				assert.NotNil(t, transport.TLSNextProto)
+				assert.Empty(t, transport.TLSNextProto)
+			},
+		},
 		{
 			name: "uses custom timeout when specified",
 			config: HTTPClientBuildConfig{
@@ -234,6 +279,103 @@ func TestHTTPClient_doRequest(t *testing.T) {
 	}
 }
 
+func TestHTTPClient_requestURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		path     string
+		expected string
+	}{
+		{"appends the path", "http://controller:9000", "/tables", "http://controller:9000/tables"},
+		{"keeps a path prefix", "http://gateway/pinot-controller", "/tables", "http://gateway/pinot-controller/tables"},
+		{"drops trailing slashes of the prefix", "http://gateway/pinot-controller//", "/tables", "http://gateway/pinot-controller/tables"},
+		{"keeps escaped path segments", "http://gateway/pinot%20controller", "/tables/my%20table/schema", "http://gateway/pinot%20controller/tables/my%20table/schema"},
+		{"keeps the base query", "http://gateway/pinot?apikey=abc", "/tables", "http://gateway/pinot/tables?apikey=abc"},
+		{"merges the queries", "http://gateway/pinot?apikey=abc", "/query/sql?sql=SELECT+1", "http://gateway/pinot/query/sql?apikey=abc&sql=SELECT+1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewHTTPClient(HTTPClientBuildConfig{URL: tt.baseURL})
+			result, err := client.requestURL(tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestHTTPClient_AcceptHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{"defaults to JSON", "", "application/json"},
+		{"uses configured accept header", "text/csv", "text/csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			client := NewHTTPClient(HTTPClientBuildConfig{
+				URL:    "http://test-broker:8099",
+				Accept: tt.accept,
+			})
+			httpmock.ActivateNonDefault(client.httpClient)
+
+			var received string
+			httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+				func(req *http.Request) (*http.Response, error) {
+					received = req.Header.Get("Accept")
+					return httpmock.NewStringResponse(200, "OK"), nil
+				})
+
+			resp, err := client.doRequest(context.Background(), "GET", "/health", nil)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.expected, received)
+		})
+	}
+}
+
+func TestHTTPClient_checkContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		expectError bool
+	}{
+		{"accepts matching media type", "application/json", "application/json", false},
+		{"accepts media type parameters", "application/json", "application/json; charset=utf-8", false},
+		{"accepts missing content type", "application/json", "", false},
+		{"accepts wildcard subtype", "application/*", "application/json", false},
+		{"accepts one of several types", "text/csv, application/json", "application/json", false},
+		{"rejects unexpected media type", "application/json", "text/html", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewHTTPClient(HTTPClientBuildConfig{URL: "http://test-broker:8099", Accept: tt.accept})
+			resp := &http.Response{Header: http.Header{}}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err := client.checkContentType(resp)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "unexpected response content type")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 // ============================================================================
 // PinotClient Tests
 // ============================================================================
@@ -300,6 +442,35 @@ func TestNew(t *testing.T) {
 			expectError: true,
 			errorMsg:    "broker URL is required",
 		},
+		{
+			name: "uses POST queries by default",
+			opts: PinotClientOptions{
+				BrokerUrl: "http://localhost:8099",
+			},
+			validate: func(t *testing.T, client *PinotClient) {
+				assert.Equal(t, http.MethodPost, client.queryMethod)
+				assert.Equal(t, DefaultMaxQueryURLLength, client.maxQueryURLLength)
+			},
+		},
+		{
+			name: "normalizes the query method",
+			opts: PinotClientOptions{
+				BrokerUrl:   "http://localhost:8099",
+				QueryMethod: "get",
+			},
+			validate: func(t *testing.T, client *PinotClient) {
+				assert.Equal(t, http.MethodGet, client.queryMethod)
+			},
+		},
+		{
+			name: "fails with an invalid query method",
+			opts: PinotClientOptions{
+				BrokerUrl:   "http://localhost:8099",
+				QueryMethod: "PUT",
+			},
+			expectError: true,
+			errorMsg:    `invalid query method "PUT"`,
+		},
 		{
 			name: "uses default timeouts",
 			opts: PinotClientOptions{
@@ -308,7 +479,25 @@ func TestNew(t *testing.T) {
 			},
 			expectError: false,
 			validate: func(t *testing.T, client *PinotClient) {
-				assert.Equal(t, 30*time.Second, client.brokerClient.httpClient.Timeout)
+				assert.Equal(t, DefaultQueryTimeout, client.brokerClient.httpClient.Timeout)
+				assert.Equal(t, DefaultQueryTimeout, client.queryTimeout)
+				assert.Equal(t, DefaultMetadataTimeout, client.metadataTimeout)
+				assert.Equal(t, DefaultHealthTimeout, client.healthTimeout)
+			},
+		},
+		{
+			name: "raises broker client timeout to the query timeout",
+			opts: PinotClientOptions{
+				BrokerUrl:       "http://localhost:8099",
+				BrokerAuthType:  AuthTypeNone,
+				QueryTimeout:    60 * time.Second,
+				MetadataTimeout: 5 * time.Second,
+			},
+			expectError: false,
+			validate: func(t *testing.T, client *PinotClient) {
+				assert.Equal(t, 60*time.Second, client.brokerClient.httpClient.Timeout)
+				assert.Equal(t, 60*time.Second, client.queryTimeout)
+				assert.Equal(t, 5*time.Second, client.metadataTimeout)
 			},
 		},
 	}
@@ -333,6 +522,67 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_SharedTransport(t *testing.T) {
+	tests := []struct {
+		name                    string
+		brokerUrl               string
+		controllerUrl           string
+		controllerTlsSkipVerify bool
+		expectShared            bool
+	}{
+		{
+			name:          "shares the transport behind a gateway",
+			brokerUrl:     "https://pinot.example.com/broker",
+			controllerUrl: "https://PINOT.example.com/controller/",
+			expectShared:  true,
+		},
+		{
+			name:          "shares the transport for identical URLs",
+			brokerUrl:     "http://localhost:8099",
+			controllerUrl: "http://localhost:8099",
+			expectShared:  true,
+		},
+		{
+			name:          "separate transports for different ports",
+			brokerUrl:     "http://localhost:8099",
+			controllerUrl: "http://localhost:9000",
+		},
+		{
+			name:          "separate transports for different schemes",
+			brokerUrl:     "http://pinot.example.com",
+			controllerUrl: "https://pinot.example.com",
+		},
+		{
+			name:                    "separate transports for different TLS settings",
+			brokerUrl:               "https://pinot.example.com/broker",
+			controllerUrl:           "https://pinot.example.com/controller",
+			controllerTlsSkipVerify: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(PinotClientOptions{
+				BrokerUrl:               tt.brokerUrl,
+				BrokerAuthType:          AuthTypeNone,
+				ControllerUrl:           tt.controllerUrl,
+				ControllerAuthType:      AuthTypeNone,
+				ControllerTlsSkipVerify: tt.controllerTlsSkipVerify,
+			})
+			require.NoError(t, err)
+
+			brokerTransport := client.brokerClient.httpClient.Transport.(*http.Transport)
+			controllerTransport := client.controllerClient.httpClient.Transport.(*http.Transport)
+			if tt.expectShared {
+				assert.Same(t, brokerTransport, controllerTransport)
+			} else {
+				assert.NotSame(t, brokerTransport, controllerTransport)
+			}
+			assert.Equal(t, tt.controllerTlsSkipVerify, controllerTransport.TLSClientConfig.InsecureSkipVerify)
+		})
+	}
+}
+
 func TestPinotClient_Health(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -405,6 +655,17 @@ func TestPinotClient_Query(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name: "query returning an HTML page",
+			sql:  "SELECT * FROM myTable",
+			setupMock: func() {
+				resp := httpmock.NewStringResponse(200, "<html>login</html>")
+				resp.Header.Set("Content-Type", "text/html")
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", httpmock.ResponderFromResponse(resp))
+			},
+			expectError: true,
+			errorMsg:    "unexpected response content type \"text/html\"",
+		},
 		{
 			name: "query with error response",
 			sql:  "SELECT * FROM nonexistent",
@@ -448,51 +709,23 @@ func TestPinotClient_Query(t *testing.T) {
 	}
 }
 
-func TestPinotClient_Tables(t *testing.T) {
+func TestPinotClient_QueryMethod(t *testing.T) {
 	tests := []struct {
-		name            string
-		hasController   bool
-		setupMock       func()
-		expectedTables  []string
-		expectError     bool
-		errorMsg        string
+		name           string
+		maxURLLength   int
+		sql            string
+		expectedMethod string
 	}{
 		{
-			name:          "retrieves tables successfully",
-			hasController: true,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
-					httpmock.NewStringResponder(200, `{"tables":["table1","table2","table3"]}`))
-			},
-			expectedTables: []string{"table1", "table2", "table3"},
-			expectError:    false,
-		},
-		{
-			name:          "retrieves empty table list",
-			hasController: true,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
-					httpmock.NewStringResponder(200, `{"tables":[]}`))
-			},
-			expectedTables: []string{},
-			expectError:    false,
-		},
-		{
-			name:          "fails when controller not configured",
-			hasController: false,
-			setupMock:     func() {},
-			expectError:   true,
-			errorMsg:      "controller client not configured",
+			name:           "sends the URL-encoded SQL with GET",
+			sql:            "SELECT * FROM airlineStats WHERE carrier = 'AA' AND delay > 10",
+			expectedMethod: http.MethodGet,
 		},
 		{
-			name:          "handles server error",
-			hasController: true,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
-					httpmock.NewStringResponder(500, "Internal Server Error"))
-			},
-			expectError: true,
-			errorMsg:    "list tables failed with status 500",
+			name:           "falls back to POST when the URL is too long",
+			maxURLLength:   64,
+			sql:            "SELECT carrier, origin, destination, delay FROM airlineStats WHERE delay > 10",
+			expectedMethod: http.MethodPost,
 		},
 	}
 
@@ -500,96 +733,779 @@ func TestPinotClient_Tables(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			httpmock.Activate()
 			defer httpmock.DeactivateAndReset()
-			tt.setupMock()
-
-			opts := PinotClientOptions{
-				BrokerUrl:      "http://test-broker:8099",
-				BrokerAuthType: AuthTypeNone,
-			}
-			if tt.hasController {
-				opts.ControllerUrl = "http://test-controller:9000"
-				opts.ControllerAuthType = AuthTypeNone
-			}
 
-			client, err := New(opts)
+			client, err := New(PinotClientOptions{
+				BrokerUrl:         "http://test-broker:8099",
+				BrokerAuthType:    AuthTypeNone,
+				QueryMethod:       http.MethodGet,
+				MaxQueryURLLength: tt.maxURLLength,
+			})
 			require.NoError(t, err)
+			httpmock.ActivateNonDefault(client.brokerClient.httpClient)
 
-			if tt.hasController {
-				// Replace the controller's httpClient with a mock-enabled one
-				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
-			}
-
-			tables, err := client.Tables(context.Background())
-
-			if tt.expectError {
-				assert.Error(t, err)
-				if tt.errorMsg != "" {
-					assert.Contains(t, err.Error(), tt.errorMsg)
-				}
-			} else {
-				assert.NoError(t, err)
-				if len(tt.expectedTables) == 0 {
-					assert.Empty(t, tables)
+			var received string
+			responder := func(req *http.Request) (*http.Response, error) {
+				if req.Method == http.MethodGet {
+					assert.Nil(t, req.Body)
+					received = req.URL.Query().Get("sql")
 				} else {
-					assert.Equal(t, tt.expectedTables, tables)
+					var payload map[string]string
+					require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+					received = payload["sql"]
 				}
+				return httpmock.NewStringResponse(200, `{"resultTable":{}}`), nil
 			}
+			httpmock.RegisterResponder(http.MethodGet, "http://test-broker:8099/query/sql", responder)
+			httpmock.RegisterResponder(http.MethodPost, "http://test-broker:8099/query/sql", responder)
+
+			resp, err := client.Query(context.Background(), tt.sql)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.sql, received)
+			info := httpmock.GetCallCountInfo()
+			assert.Equal(t, 1, info[tt.expectedMethod+" http://test-broker:8099/query/sql"])
 		})
 	}
 }
 
-func TestPinotClient_Schemas(t *testing.T) {
+func TestPinotClient_SQLFieldName(t *testing.T) {
 	tests := []struct {
 		name          string
-		hasController bool
-		expectError   bool
-		errorMsg      string
+		fieldName     string
+		method        string
+		expectedField string
 	}{
-		{
-			name:          "returns empty list when controller configured",
-			hasController: true,
-			expectError:   false,
-		},
-		{
-			name:          "fails when controller not configured",
-			hasController: false,
-			expectError:   true,
-			errorMsg:      "controller client not configured",
-		},
+		{name: "defaults to sql", expectedField: "sql"},
+		{name: "uses the configured field in the body", fieldName: "query", expectedField: "query"},
+		{name: "uses the configured field as the GET parameter", fieldName: "query", method: http.MethodGet, expectedField: "query"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			opts := PinotClientOptions{
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			client, err := New(PinotClientOptions{
 				BrokerUrl:      "http://test-broker:8099",
 				BrokerAuthType: AuthTypeNone,
-			}
-			if tt.hasController {
-				opts.ControllerUrl = "http://test-controller:9000"
-				opts.ControllerAuthType = AuthTypeNone
-			}
-
-			client, err := New(opts)
+				QueryMethod:    tt.method,
+				SQLFieldName:   tt.fieldName,
+			})
 			require.NoError(t, err)
+			httpmock.ActivateNonDefault(client.brokerClient.httpClient)
 
-			schemas, err := client.Schemas(context.Background())
-
-			if tt.expectError {
-				assert.Error(t, err)
-				if tt.errorMsg != "" {
-					assert.Contains(t, err.Error(), tt.errorMsg)
+			var received map[string]string
+			responder := func(req *http.Request) (*http.Response, error) {
+				received = map[string]string{}
+				if req.Method == http.MethodGet {
+					for key := range req.URL.Query() {
+						received[key] = req.URL.Query().Get(key)
+					}
+				} else {
+					require.NoError(t, json.NewDecoder(req.Body).Decode(&received))
 				}
-			} else {
-				assert.NoError(t, err)
-				assert.Empty(t, schemas)
+				return httpmock.NewStringResponse(200, `{"resultTable":{}}`), nil
 			}
+			httpmock.RegisterResponder(http.MethodGet, "http://test-broker:8099/query/sql", responder)
+			httpmock.RegisterResponder(http.MethodPost, "http://test-broker:8099/query/sql", responder)
+
+			resp, err := client.QueryWithOptions(context.Background(), "SELECT 1", map[string]interface{}{"timeoutMs": 1000})
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, map[string]string{tt.expectedField: "SELECT 1", "queryOptions": "timeoutMs=1000"}, received)
 		})
 	}
+
+	t.Run("rejects the query options field", func(t *testing.T) {
+		_, err := New(PinotClientOptions{BrokerUrl: "http://test-broker:8099", SQLFieldName: "queryOptions"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid SQL field name")
+	})
 }
 
-// ============================================================================
-// DataSource Tests
-// ============================================================================
+func TestPinotClient_QueryWithOptions(t *testing.T) {
+	options := map[string]interface{}{"useMultiStageEngine": true, "timeoutMs": 5000}
+
+	t.Run("sends the options in the POST body", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		client, err := New(PinotClientOptions{BrokerUrl: "http://test-broker:8099", BrokerAuthType: AuthTypeNone})
+		require.NoError(t, err)
+		httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+
+		var payload map[string]string
+		httpmock.RegisterResponder(http.MethodPost, "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+			return httpmock.NewStringResponse(200, `{"resultTable":{}}`), nil
+		})
+
+		resp, err := client.QueryWithOptions(context.Background(), "SELECT 1", options)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "timeoutMs=5000;useMultiStageEngine=true", payload["queryOptions"])
+	})
+
+	t.Run("sends the options as a GET parameter", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		client, err := New(PinotClientOptions{BrokerUrl: "http://test-broker:8099", BrokerAuthType: AuthTypeNone, QueryMethod: http.MethodGet})
+		require.NoError(t, err)
+		httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+
+		var received string
+		httpmock.RegisterResponder(http.MethodGet, "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+			received = req.URL.Query().Get("queryOptions")
+			return httpmock.NewStringResponse(200, `{"resultTable":{}}`), nil
+		})
+
+		resp, err := client.QueryWithOptions(context.Background(), "SELECT 1", options)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "timeoutMs=5000;useMultiStageEngine=true", received)
+	})
+}
+
+func TestPinotClient_QueryRetries(t *testing.T) {
+	connRefused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	dialTimeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	readTimeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+
+	tests := []struct {
+		name          string
+		statusCodes   []int
+		failure       func() (*http.Response, error)
+		expectedCalls int
+	}{
+		{
+			name:          "retries a refused connection",
+			failure:       func() (*http.Response, error) { return nil, connRefused },
+			expectedCalls: 3,
+		},
+		{
+			name:          "retries service unavailable",
+			failure:       func() (*http.Response, error) { return httpmock.NewStringResponse(503, "busy"), nil },
+			expectedCalls: 3,
+		},
+		{
+			name:          "does not retry a read timeout",
+			failure:       func() (*http.Response, error) { return nil, readTimeout },
+			expectedCalls: 1,
+		},
+		{
+			name:          "does not retry a dial timeout",
+			failure:       func() (*http.Response, error) { return nil, dialTimeout },
+			expectedCalls: 1,
+		},
+		{
+			name:          "does not retry server errors",
+			failure:       func() (*http.Response, error) { return httpmock.NewStringResponse(500, "failed"), nil },
+			expectedCalls: 1,
+		},
+		{
+			name:          "retries configured status codes",
+			statusCodes:   []int{502},
+			failure:       func() (*http.Response, error) { return httpmock.NewStringResponse(502, "bad gateway"), nil },
+			expectedCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			client, err := New(PinotClientOptions{
+				BrokerUrl:        "http://test-broker:8099",
+				BrokerAuthType:   AuthTypeNone,
+				QueryRetries:     2,
+				RetryStatusCodes: tt.statusCodes,
+				RetryBackoff:     time.Millisecond,
+			})
+			require.NoError(t, err)
+			httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+
+			// Every attempt but the third fails, so a retried query eventually succeeds
+			calls := 0
+			var payloads []string
+			httpmock.RegisterResponder(http.MethodPost, "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+				calls++
+				body, _ := io.ReadAll(req.Body)
+				payloads = append(payloads, string(body))
+				if calls < 3 {
+					return tt.failure()
+				}
+				return httpmock.NewStringResponse(200, `{"resultTable":{}}`), nil
+			})
+
+			resp, err := client.Query(context.Background(), "SELECT 1")
+
+			assert.Equal(t, tt.expectedCalls, calls)
+			if tt.expectedCalls == 3 {
+				require.NoError(t, err)
+				resp.Body.Close()
+				for _, payload := range payloads {
+					assert.JSONEq(t, `{"sql":"SELECT 1"}`, payload)
+				}
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("does not retry by default", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		client, err := New(PinotClientOptions{BrokerUrl: "http://test-broker:8099", BrokerAuthType: AuthTypeNone})
+		require.NoError(t, err)
+		httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+		httpmock.RegisterResponder(http.MethodPost, "http://test-broker:8099/query/sql", httpmock.NewErrorResponder(connRefused))
+
+		_, err = client.Query(context.Background(), "SELECT 1")
+
+		assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+		assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	})
+}
+
+func TestPinotClient_Tables(t *testing.T) {
+	tests := []struct {
+		name            string
+		hasController   bool
+		preserveOrder   bool
+		setupMock       func()
+		expectedTables  []string
+		expectError     bool
+		errorMsg        string
+	}{
+		{
+			name:          "retrieves tables successfully",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":["table1","table2","table3"]}`))
+			},
+			expectedTables: []string{"table1", "table2", "table3"},
+			expectError:    false,
+		},
+		{
+			name:          "retrieves empty table list",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":[]}`))
+			},
+			expectedTables: []string{},
+			expectError:    false,
+		},
+		{
+			name:          "retrieves tables grouped by table type",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"OFFLINE":["table1","table2"],"REALTIME":["table2","table3"]}`))
+			},
+			expectedTables: []string{"table1", "table2", "table3"},
+			expectError:    false,
+		},
+		{
+			name:          "sorts tables ignoring case",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":["orders","Airlines","events","airlineStats"]}`))
+			},
+			expectedTables: []string{"Airlines", "airlineStats", "events", "orders"},
+			expectError:    false,
+		},
+		{
+			name:          "preserves the controller order when configured",
+			hasController: true,
+			preserveOrder: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":["orders","Airlines","events","airlineStats"]}`))
+			},
+			expectedTables: []string{"orders", "Airlines", "events", "airlineStats"},
+			expectError:    false,
+		},
+		{
+			name:          "drops blank table names",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":["table1",""," ","\ttable2 "]}`))
+			},
+			expectedTables: []string{"table1", "table2"},
+			expectError:    false,
+		},
+		{
+			name:          "fails when controller not configured",
+			hasController: false,
+			setupMock:     func() {},
+			expectError:   true,
+			errorMsg:      "controller client not configured",
+		},
+		{
+			name:          "handles server error",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(500, "Internal Server Error"))
+			},
+			expectError: true,
+			errorMsg:    "list tables failed with status 500",
+		},
+		{
+			name:          "reports rejected credentials",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(401, "Unauthorized"))
+			},
+			expectError: true,
+			errorMsg:    "controller authentication failed, check the controller authentication settings: list tables failed with status 401",
+		},
+		{
+			name:          "reports forbidden access",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(403, "Forbidden"))
+			},
+			expectError: true,
+			errorMsg:    "controller authentication failed, check the controller authentication settings: list tables failed with status 403",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			tt.setupMock()
+
+			opts := PinotClientOptions{
+				BrokerUrl:          "http://test-broker:8099",
+				BrokerAuthType:     AuthTypeNone,
+				PreserveTableOrder: tt.preserveOrder,
+			}
+			if tt.hasController {
+				opts.ControllerUrl = "http://test-controller:9000"
+				opts.ControllerAuthType = AuthTypeNone
+			}
+
+			client, err := New(opts)
+			require.NoError(t, err)
+
+			if tt.hasController {
+				// Replace the controller's httpClient with a mock-enabled one
+				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+			}
+
+			tables, err := client.Tables(context.Background())
+
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.errorMsg != "" {
+					assert.Contains(t, err.Error(), tt.errorMsg)
+				}
+			} else {
+				assert.NoError(t, err)
+				if len(tt.expectedTables) == 0 {
+					assert.Empty(t, tables)
+				} else {
+					assert.Equal(t, tt.expectedTables, tables)
+				}
+			}
+		})
+	}
+}
+
+func TestPinotClient_TableSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		hasController bool
+		setupMock     func()
+		expectError   bool
+		errorMsg      string
+		validate      func(t *testing.T, size *TableSize)
+	}{
+		{
+			name:          "retrieves table size successfully",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables/airlineStats/size",
+					httpmock.NewStringResponder(200, `{"tableName":"airlineStats","reportedSizeInBytes":2048,"estimatedSizeInBytes":4096,"offlineSegments":{"reportedSizeInBytes":1024,"estimatedSizeInBytes":2048,"missingSegments":0,"segments":{}},"realtimeSegments":{"reportedSizeInBytes":1024,"estimatedSizeInBytes":2048,"missingSegments":1,"segments":{}}}`))
+			},
+			validate: func(t *testing.T, size *TableSize) {
+				assert.Equal(t, "airlineStats", size.TableName)
+				assert.Equal(t, int64(2048), size.ReportedSizeInBytes)
+				assert.Equal(t, int64(4096), size.EstimatedSizeInBytes)
+				require.NotNil(t, size.OfflineSegments)
+				assert.Equal(t, int64(1024), size.OfflineSegments.ReportedSizeInBytes)
+				require.NotNil(t, size.RealtimeSegments)
+				assert.Equal(t, 1, size.RealtimeSegments.MissingSegments)
+			},
+		},
+		{
+			name:          "fails when controller not configured",
+			hasController: false,
+			setupMock:     func() {},
+			expectError:   true,
+			errorMsg:      "controller client not configured",
+		},
+		{
+			name:          "handles missing table",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables/airlineStats/size",
+					httpmock.NewStringResponder(404, `{"code":404,"error":"Table not found"}`))
+			},
+			expectError: true,
+			errorMsg:    "get table size failed with status 404",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			tt.setupMock()
+
+			opts := PinotClientOptions{
+				BrokerUrl:      "http://test-broker:8099",
+				BrokerAuthType: AuthTypeNone,
+			}
+			if tt.hasController {
+				opts.ControllerUrl = "http://test-controller:9000"
+				opts.ControllerAuthType = AuthTypeNone
+			}
+
+			client, err := New(opts)
+			require.NoError(t, err)
+
+			if tt.hasController {
+				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+			}
+
+			size, err := client.TableSize(context.Background(), "airlineStats")
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+				tt.validate(t, size)
+			}
+		})
+	}
+}
+
+func TestPinotClient_Instances(t *testing.T) {
+	tests := []struct {
+		name              string
+		hasController     bool
+		setupMock         func()
+		expectedInstances []string
+		expectError       bool
+		errorMsg          string
+	}{
+		{
+			name:          "retrieves instances successfully",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/instances",
+					httpmock.NewStringResponder(200, `{"instances":["Controller_pinot-controller_9000","Broker_pinot-broker_8099","Server_pinot-server_8098","Minion_pinot-minion_9514"]}`))
+			},
+			expectedInstances: []string{"Controller_pinot-controller_9000", "Broker_pinot-broker_8099", "Server_pinot-server_8098", "Minion_pinot-minion_9514"},
+		},
+		{
+			name:          "fails when controller not configured",
+			hasController: false,
+			setupMock:     func() {},
+			expectError:   true,
+			errorMsg:      "controller client not configured",
+		},
+		{
+			name:          "handles controller errors",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/instances",
+					httpmock.NewStringResponder(500, "Internal Server Error"))
+			},
+			expectError: true,
+			errorMsg:    "list instances failed with status 500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			tt.setupMock()
+
+			opts := PinotClientOptions{
+				BrokerUrl:      "http://test-broker:8099",
+				BrokerAuthType: AuthTypeNone,
+			}
+			if tt.hasController {
+				opts.ControllerUrl = "http://test-controller:9000"
+				opts.ControllerAuthType = AuthTypeNone
+			}
+
+			client, err := New(opts)
+			require.NoError(t, err)
+
+			if tt.hasController {
+				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+			}
+
+			instances, err := client.Instances(context.Background())
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedInstances, instances)
+			}
+		})
+	}
+}
+
+func TestPinotClient_ControllerAuthFailed(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://test-broker:8099",
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      "http://test-controller:9000",
+		ControllerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	httpmock.RegisterResponder("GET", "http://test-controller:9000/tables/airlineStats/schema",
+		httpmock.NewStringResponder(403, "Forbidden"))
+
+	_, err = client.TableSchema(context.Background(), "airlineStats")
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrControllerAuthFailed)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+}
+
+func TestPinotClient_ControllerResponseBOM(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://test-broker:8099",
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      "http://test-controller:9000",
+		ControllerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+		httpmock.NewStringResponder(200, "\xEF\xBB\xBF"+`{"tables":["airlineStats"]}`))
+	httpmock.RegisterResponder("GET", "http://test-controller:9000/tables/airlineStats/schema",
+		httpmock.NewStringResponder(200, " \n\xEF\xBB\xBF"+`{"schemaName":"airlineStats","dimensionFieldSpecs":[{"name":"carrier","dataType":"STRING"}]}`))
+
+	tables, err := client.Tables(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"airlineStats"}, tables)
+
+	schema, err := client.TableSchema(context.Background(), "airlineStats")
+	require.NoError(t, err)
+	assert.Equal(t, "airlineStats", schema.SchemaName)
+	assert.Equal(t, []FieldSpec{{Name: "carrier", DataType: "STRING"}}, schema.DimensionFieldSpecs)
+}
+
+func TestPinotClient_RequestTimeouts(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:       "http://test-broker:8099",
+		BrokerAuthType:  AuthTypeNone,
+		ControllerUrl:   "http://test-controller:9000",
+		QueryTimeout:    2 * time.Second,
+		MetadataTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	// Both endpoints respond slower than the metadata deadline but well within the query deadline
+	httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+		httpmock.NewStringResponder(200, `{"tables":["table1"]}`).Delay(200*time.Millisecond))
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{},"rows":[]}}`).Delay(200*time.Millisecond))
+
+	t.Run("metadata calls fail fast", func(t *testing.T) {
+		start := time.Now()
+		_, err := client.Tables(context.Background())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("queries get the longer deadline", func(t *testing.T) {
+		resp, err := client.Query(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "resultTable")
+	})
+
+	t.Run("health probes get their own deadline", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+			httpmock.NewStringResponder(200, "OK").Delay(200*time.Millisecond))
+		client.healthTimeout = 50 * time.Millisecond
+
+		start := time.Now()
+		err := client.Health(context.Background())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+}
+
+func TestPinotClient_ResponseHeaderTimeout(t *testing.T) {
+	// A broker that accepts the request but never sends headers; httpmock bypasses the
+	// transport, so a real server is needed to exercise the header timeout
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Consuming the body lets the server notice the client hanging up
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer broker.Close()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:             broker.URL,
+		BrokerAuthType:        AuthTypeNone,
+		QueryTimeout:          10 * time.Second,
+		ResponseHeaderTimeout: 100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = client.Query(context.Background(), "SELECT 1")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestPinotClient_Schemas(t *testing.T) {
+	tests := []struct {
+		name          string
+		hasController bool
+		expectError   bool
+		errorMsg      string
+	}{
+		{
+			name:          "returns empty list when controller configured",
+			hasController: true,
+			expectError:   false,
+		},
+		{
+			name:          "fails when controller not configured",
+			hasController: false,
+			expectError:   true,
+			errorMsg:      "controller client not configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := PinotClientOptions{
+				BrokerUrl:      "http://test-broker:8099",
+				BrokerAuthType: AuthTypeNone,
+			}
+			if tt.hasController {
+				opts.ControllerUrl = "http://test-controller:9000"
+				opts.ControllerAuthType = AuthTypeNone
+			}
+
+			client, err := New(opts)
+			require.NoError(t, err)
+
+			schemas, err := client.Schemas(context.Background())
+
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.errorMsg != "" {
+					assert.Contains(t, err.Error(), tt.errorMsg)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Empty(t, schemas)
+			}
+		})
+	}
+}
+
+func TestPinotClient_PrefixedController(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.RequestURI)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/schema"):
+			_, _ = w.Write([]byte(`{"schemaName":"my table"}`))
+		case strings.HasSuffix(r.URL.Path, "/size"):
+			_, _ = w.Write([]byte(`{"tableName":"my table","reportedSizeInBytes":2048}`))
+		default:
+			_, _ = w.Write([]byte(`{"tables":["my table"]}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://test-broker:8099",
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      server.URL + "/pinot-controller/?apikey=abc",
+		ControllerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+
+	tables, err := client.Tables(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"my table"}, tables)
+	_, err = client.TableSchema(context.Background(), "my table")
+	require.NoError(t, err)
+	size, err := client.TableSize(context.Background(), "my table")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2048), size.ReportedSizeInBytes)
+
+	assert.Equal(t, []string{
+		"/pinot-controller/tables?apikey=abc",
+		"/pinot-controller/tables/my%20table/schema?apikey=abc",
+		"/pinot-controller/tables/my%20table/size?apikey=abc",
+	}, requests)
+}
+
+// ============================================================================
+// DataSource Tests
+// ============================================================================
 
 func TestDataSource_CheckHealth(t *testing.T) {
 	tests := []struct {
@@ -597,111 +1513,471 @@ func TestDataSource_CheckHealth(t *testing.T) {
 		hasController  bool
 		setupMock      func()
 		expectedStatus backend.HealthStatus
-		expectedMsgs   []string
+		expectedMsgs   []string
+		unexpectedMsgs []string
+	}{
+		{
+			name:          "successful health check with broker only",
+			hasController: false,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+			},
+			expectedStatus: backend.HealthStatusOk,
+			expectedMsgs:   []string{"Broker health check passed", "Broker query endpoint verified", "Controller URL not configured"},
+		},
+		{
+			name:          "successful health check with broker and controller",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(200, `{"tables":["table1","table2"]}`))
+			},
+			expectedStatus: backend.HealthStatusOk,
+			expectedMsgs:   []string{"Broker health check passed", "Broker query endpoint verified", "Controller connected (2 tables available)"},
+		},
+		{
+			name:          "broker health check fails",
+			hasController: false,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(503, "Service Unavailable"))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"Broker health check failed"},
+		},
+		{
+			name:          "broker query test fails",
+			hasController: false,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(500, "Query error"))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"query test failed"},
+		},
+		{
+			name:          "controller connection fails",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(500, "Controller error"))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"Controller connection failed"},
+			unexpectedMsgs: []string{"swapped"},
+		},
+		{
+			name:          "controller rejects the credentials",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(401, "Unauthorized"))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"Broker connected, but controller authentication failed, check the controller authentication settings", "status 401"},
+			unexpectedMsgs: []string{"Controller connection failed"},
+		},
+		{
+			name:          "suggests swapped URLs when the broker URL is a controller",
+			hasController: false,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(404, "Not Found"))
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/tables",
+					httpmock.NewStringResponder(200, `{"tables":["table1"]}`))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"query test failed", "broker URL appears to point to a Pinot controller", "not swapped"},
+		},
+		{
+			name:          "suggests swapped URLs when the controller URL is a broker",
+			hasController: true,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
+					httpmock.NewStringResponder(404, "Not Found"))
+				httpmock.RegisterResponder("POST", "http://test-controller:9000/query/sql",
+					httpmock.NewStringResponder(200, `{}`))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"Controller connection failed", "controller URL appears to point to a Pinot broker"},
+		},
+		{
+			name:          "does not suggest swapped URLs for an unknown endpoint",
+			hasController: false,
+			setupMock: func() {
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+					httpmock.NewStringResponder(200, "OK"))
+				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+					httpmock.NewStringResponder(404, "Not Found"))
+				httpmock.RegisterResponder("GET", "http://test-broker:8099/tables",
+					httpmock.NewStringResponder(404, "Not Found"))
+			},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsgs:   []string{"query failed with status 404"},
+			unexpectedMsgs: []string{"swapped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			tt.setupMock()
+
+			opts := PinotClientOptions{
+				BrokerUrl:      "http://test-broker:8099",
+				BrokerAuthType: AuthTypeNone,
+			}
+			if tt.hasController {
+				opts.ControllerUrl = "http://test-controller:9000"
+				opts.ControllerAuthType = AuthTypeNone
+			}
+
+			client, err := New(opts)
+			require.NoError(t, err)
+
+			// Replace the broker and controller httpClient with mock-enabled ones
+			httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+			if tt.hasController {
+				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+			}
+
+			ds := &DataSource{client: client}
+
+			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+
+			assert.NoError(t, err)
+			require.NotNil(t, result)
+			assert.Equal(t, tt.expectedStatus, result.Status)
+
+			for _, msg := range tt.expectedMsgs {
+				assert.Contains(t, result.Message, msg)
+			}
+			for _, msg := range tt.unexpectedMsgs {
+				assert.NotContains(t, result.Message, msg)
+			}
+		})
+	}
+}
+
+func TestDataSource_CheckHealth_SwappedControllerProbe(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://test-broker:8099",
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      "http://test-controller:9000",
+		ControllerAuthType: AuthTypeNone,
+		SQLFieldName:       "query",
+	})
+	require.NoError(t, err)
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	httpmock.RegisterResponder("GET", "http://test-broker:8099/health", httpmock.NewStringResponder(200, "OK"))
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", httpmock.NewStringResponder(200, `{}`))
+	httpmock.RegisterResponder("GET", "http://test-controller:9000/tables", httpmock.NewStringResponder(404, "Not Found"))
+
+	// The probe is sent like the health check query, with the configured SQL field
+	var received map[string]string
+	httpmock.RegisterResponder("POST", "http://test-controller:9000/query/sql", func(req *http.Request) (*http.Response, error) {
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&received))
+		return httpmock.NewStringResponse(200, `{}`), nil
+	})
+
+	ds := &DataSource{client: client, config: DataSourceConfig{HealthCheckTable: "events"}}
+	result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+
+	assert.Equal(t, backend.HealthStatusError, result.Status)
+	assert.Contains(t, result.Message, "controller URL appears to point to a Pinot broker")
+	assert.Equal(t, map[string]string{"query": `SELECT COUNT(*) FROM "events" LIMIT 1`}, received)
+}
+
+func TestDataSource_CheckHealth_HealthCheckTable(t *testing.T) {
+	tests := []struct {
+		name            string
+		table           string
+		mandatoryFilter string
+		expectedSQL     string
+		expectedMsg     string
+	}{
+		{
+			name:        "runs SELECT 1 by default",
+			expectedSQL: "SELECT 1",
+			expectedMsg: "✓ Broker query endpoint verified",
+		},
+		{
+			name:        "counts the configured table",
+			table:       "airlineStats",
+			expectedSQL: `SELECT COUNT(*) FROM "airlineStats" LIMIT 1`,
+			expectedMsg: "✓ Broker query endpoint verified (table airlineStats)",
+		},
+		{
+			name:        "escapes quotes in the table name",
+			table:       `odd"name`,
+			expectedSQL: `SELECT COUNT(*) FROM "odd""name" LIMIT 1`,
+			expectedMsg: `(table odd"name)`,
+		},
+		{
+			name:            "skips the mandatory filter",
+			table:           "airlineStats",
+			mandatoryFilter: "account_id = 42",
+			expectedSQL:     `SELECT COUNT(*) FROM "airlineStats" LIMIT 1`,
+			expectedMsg:     "✓ Broker query endpoint verified (table airlineStats)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.HealthCheckTable = tt.table
+			ds.config.MandatoryFilter = tt.mandatoryFilter
+
+			var received string
+			httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+				httpmock.NewStringResponder(200, "OK"))
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+				var payload map[string]string
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+				received = payload["sql"]
+				return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["count(*)"],"columnDataTypes":["LONG"]},"rows":[[42]]}}`), nil
+			})
+
+			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+
+			require.NoError(t, err)
+			assert.Equal(t, backend.HealthStatusOk, result.Status)
+			assert.Equal(t, tt.expectedSQL, received)
+			assert.Contains(t, result.Message, tt.expectedMsg)
+		})
+	}
+}
+
+func TestDataSource_CheckHealth_QueryExceptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"exceptions in a 200 response", 200, `{"exceptions":[{"errorCode":190,"message":"TableDoesNotExistError"}]}`},
+		{"exceptions with an error status", 500, `{"exceptions":[{"errorCode":190,"message":"TableDoesNotExistError"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.HealthCheckTable = "missing"
+			httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+				httpmock.NewStringResponder(200, "OK"))
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(tt.status, tt.body))
+
+			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+
+			require.NoError(t, err)
+			assert.Equal(t, backend.HealthStatusError, result.Status)
+			assert.Equal(t, "Broker connected, but query test failed: Pinot query error (code 190): TableDoesNotExistError", result.Message)
+		})
+	}
+}
+
+func TestDataSource_CheckHealth_FakeClient(t *testing.T) {
+	tests := []struct {
+		name           string
+		client         *fakePinotAPI
+		expectedStatus backend.HealthStatus
+		expectedMsg    string
 	}{
 		{
-			name:          "successful health check with broker only",
-			hasController: false,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
-					httpmock.NewStringResponder(200, "OK"))
-				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
-					httpmock.NewStringResponder(200, `{}`))
-			},
+			name:           "reports the table count",
+			client:         &fakePinotAPI{queryResponse: `{"resultTable":{}}`, tables: []string{"a", "b"}},
 			expectedStatus: backend.HealthStatusOk,
-			expectedMsgs:   []string{"Broker health check passed", "Broker query endpoint verified", "Controller URL not configured"},
+			expectedMsg:    "✓ Controller connected (2 tables available)",
 		},
 		{
-			name:          "successful health check with broker and controller",
-			hasController: true,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
-					httpmock.NewStringResponder(200, "OK"))
-				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
-					httpmock.NewStringResponder(200, `{}`))
-				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
-					httpmock.NewStringResponder(200, `{"tables":["table1","table2"]}`))
-			},
+			name:           "warns without a controller",
+			client:         &fakePinotAPI{queryResponse: `{"resultTable":{}}`, tablesErr: ErrControllerNotConfigured},
 			expectedStatus: backend.HealthStatusOk,
-			expectedMsgs:   []string{"Broker health check passed", "Broker query endpoint verified", "Controller connected (2 tables available)"},
+			expectedMsg:    "⚠ Controller URL not configured",
 		},
 		{
-			name:          "broker health check fails",
-			hasController: false,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
-					httpmock.NewStringResponder(503, "Service Unavailable"))
-			},
+			name:           "fails on an unhealthy broker",
+			client:         &fakePinotAPI{healthErr: errors.New("connection refused")},
 			expectedStatus: backend.HealthStatusError,
-			expectedMsgs:   []string{"Broker health check failed"},
+			expectedMsg:    "Broker health check failed: connection refused",
 		},
 		{
-			name:          "broker query test fails",
-			hasController: false,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
-					httpmock.NewStringResponder(200, "OK"))
-				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
-					httpmock.NewStringResponder(500, "Query error"))
-			},
+			name:           "fails on query exceptions",
+			client:         &fakePinotAPI{queryResponse: `{"exceptions":[{"errorCode":190,"message":"TableDoesNotExistError"}]}`},
 			expectedStatus: backend.HealthStatusError,
-			expectedMsgs:   []string{"query test failed"},
+			expectedMsg:    "query test failed: Pinot query error (code 190)",
 		},
 		{
-			name:          "controller connection fails",
-			hasController: true,
-			setupMock: func() {
-				httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
-					httpmock.NewStringResponder(200, "OK"))
-				httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
-					httpmock.NewStringResponder(200, `{}`))
-				httpmock.RegisterResponder("GET", "http://test-controller:9000/tables",
-					httpmock.NewStringResponder(500, "Controller error"))
-			},
+			name:           "fails on controller errors",
+			client:         &fakePinotAPI{queryResponse: `{"resultTable":{}}`, tablesErr: errors.New("timeout")},
 			expectedStatus: backend.HealthStatusError,
-			expectedMsgs:   []string{"Controller connection failed"},
+			expectedMsg:    "Controller connection failed: timeout",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			httpmock.Activate()
-			defer httpmock.DeactivateAndReset()
-			tt.setupMock()
+			ds := &DataSource{client: tt.client}
 
-			opts := PinotClientOptions{
-				BrokerUrl:      "http://test-broker:8099",
-				BrokerAuthType: AuthTypeNone,
-			}
-			if tt.hasController {
-				opts.ControllerUrl = "http://test-controller:9000"
-				opts.ControllerAuthType = AuthTypeNone
-			}
+			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
 
-			client, err := New(opts)
 			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, result.Status)
+			assert.Contains(t, result.Message, tt.expectedMsg)
+		})
+	}
+}
 
-			// Replace the broker and controller httpClient with mock-enabled ones
-			httpmock.ActivateNonDefault(client.brokerClient.httpClient)
-			if tt.hasController {
-				httpmock.ActivateNonDefault(client.controllerClient.httpClient)
-			}
+func TestDataSource_CheckHealth_HealthCheckTableSchema(t *testing.T) {
+	schemas := map[string]*TableSchema{
+		"events": {
+			SchemaName:          "events",
+			DimensionFieldSpecs: []FieldSpec{{Name: "country", DataType: "STRING"}},
+			DateTimeFieldSpecs:  []FieldSpec{{Name: "ts", DataType: "LONG", Format: "1:MILLISECONDS:EPOCH"}},
+		},
+	}
 
-			ds := &DataSource{client: client}
+	tests := []struct {
+		name           string
+		config         DataSourceConfig
+		expectedStatus backend.HealthStatus
+		expectedMsg    string
+	}{
+		{
+			name:           "verifies the table",
+			config:         DataSourceConfig{HealthCheckTable: "events_OFFLINE"},
+			expectedStatus: backend.HealthStatusOk,
+			expectedMsg:    "✓ Health check table events found",
+		},
+		{
+			name:           "verifies the time column",
+			config:         DataSourceConfig{HealthCheckTable: "events", DefaultTimeColumn: "TS"},
+			expectedStatus: backend.HealthStatusOk,
+			expectedMsg:    "✓ Health check table events found with time column TS",
+		},
+		{
+			name:           "fails on a missing table",
+			config:         DataSourceConfig{HealthCheckTable: "orders", DefaultTimeColumn: "ts"},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsg:    `Controller connected, but health check table "orders" was not found on the controller`,
+		},
+		{
+			name:           "fails on a missing time column",
+			config:         DataSourceConfig{HealthCheckTable: "events", DefaultTimeColumn: "created_at"},
+			expectedStatus: backend.HealthStatusError,
+			expectedMsg:    `Controller connected, but default time column "created_at" was not found in the schema of table "events"`,
+		},
+		{
+			name:           "skips the verification without a health check table",
+			config:         DataSourceConfig{DefaultTimeColumn: "created_at"},
+			expectedStatus: backend.HealthStatusOk,
+			expectedMsg:    "✓ Controller connected (1 tables available)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: `{"resultTable":{}}`, tables: []string{"events"}, schemas: schemas}
+			ds := &DataSource{client: client, config: tt.config}
 
 			result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
 
-			assert.NoError(t, err)
-			require.NotNil(t, result)
+			require.NoError(t, err)
 			assert.Equal(t, tt.expectedStatus, result.Status)
-
-			for _, msg := range tt.expectedMsgs {
-				assert.Contains(t, result.Message, msg)
+			assert.Contains(t, result.Message, tt.expectedMsg)
+			if tt.config.HealthCheckTable == "" {
+				assert.Zero(t, client.schemaCalls)
 			}
 		})
 	}
 }
 
+func TestDataSource_CheckHealth_IndependentAuth(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	// Broker and controller are exposed behind the same gateway with different credentials
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://gateway:8080/broker",
+		BrokerAuthType:     AuthTypeBasic,
+		BrokerUsername:     "broker-user",
+		BrokerPassword:     "broker-pass",
+		ControllerUrl:      "http://gateway:8080/controller",
+		ControllerAuthType: AuthTypeBearer,
+		ControllerToken:    "controller-token",
+	})
+	require.NoError(t, err)
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	brokerReq, _ := http.NewRequest("GET", "http://gateway:8080/broker", nil)
+	brokerReq.SetBasicAuth("broker-user", "broker-pass")
+	brokerAuth := brokerReq.Header.Get("Authorization")
+
+	authorized := func(expected string, responder httpmock.Responder) httpmock.Responder {
+		return func(req *http.Request) (*http.Response, error) {
+			if !assert.Equal(t, expected, req.Header.Get("Authorization"), req.URL.String()) {
+				return httpmock.NewStringResponse(401, "Unauthorized"), nil
+			}
+			return responder(req)
+		}
+	}
+	httpmock.RegisterResponder("GET", "http://gateway:8080/broker/health",
+		authorized(brokerAuth, httpmock.NewStringResponder(200, "OK")))
+	httpmock.RegisterResponder("POST", "http://gateway:8080/broker/query/sql",
+		authorized(brokerAuth, httpmock.NewStringResponder(200, `{}`)))
+	httpmock.RegisterResponder("GET", "http://gateway:8080/controller/tables",
+		authorized("Bearer controller-token", httpmock.NewStringResponder(200, `{"tables":["table1"]}`)))
+
+	ds := &DataSource{client: client}
+	result, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, result.Status, result.Message)
+	assert.Contains(t, result.Message, "Controller connected (1 tables available)")
+
+	info := httpmock.GetCallCountInfo()
+	assert.Equal(t, 1, info["GET http://gateway:8080/broker/health"])
+	assert.Equal(t, 1, info["POST http://gateway:8080/broker/query/sql"])
+	assert.Equal(t, 1, info["GET http://gateway:8080/controller/tables"])
+}
+
 func TestDataSource_QueryData(t *testing.T) {
 	client, err := New(PinotClientOptions{
 		BrokerUrl:      "http://test-broker:8099",
@@ -727,6 +2003,96 @@ func TestDataSource_QueryData(t *testing.T) {
 	assert.Contains(t, resp.Responses, "B")
 }
 
+func TestDataSource_KeepAlive(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:      "http://test-broker:8099",
+		BrokerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+	httpmock.RegisterResponder("GET", "http://test-broker:8099/health",
+		httpmock.NewStringResponder(200, "OK"))
+
+	ds := &DataSource{client: client}
+	ds.startKeepAlive(10 * time.Millisecond)
+
+	require.Eventually(t, func() bool {
+		return httpmock.GetCallCountInfo()["GET http://test-broker:8099/health"] >= 2
+	}, time.Second, 5*time.Millisecond)
+
+	ds.Dispose()
+
+	select {
+	case <-ds.keepAliveDone:
+	default:
+		t.Fatal("keep-alive goroutine still running after Dispose")
+	}
+
+	pings := httpmock.GetCallCountInfo()["GET http://test-broker:8099/health"]
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, pings, httpmock.GetCallCountInfo()["GET http://test-broker:8099/health"])
+}
+
+func TestDataSource_Dispose_ClosesIdleConnections(t *testing.T) {
+	// newServer returns a server counting the connections closed on its side
+	newServer := func(contentType, body string) (*httptest.Server, *atomic.Int32) {
+		closed := &atomic.Int32{}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			_, _ = w.Write([]byte(body))
+		}))
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed {
+				closed.Add(1)
+			}
+		}
+		server.Start()
+		t.Cleanup(server.Close)
+		return server, closed
+	}
+	broker, brokerClosed := newServer("text/plain", "OK")
+	controller, controllerClosed := newServer("application/json", `{"tables":["airlineStats"]}`)
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          broker.URL,
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      strings.Replace(controller.URL, "127.0.0.1", "localhost", 1),
+		ControllerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+	ds := &DataSource{client: client}
+	ds.startKeepAlive(time.Hour)
+
+	require.NoError(t, client.Health(context.Background()))
+	_, err = client.Tables(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, brokerClosed.Load())
+	assert.Zero(t, controllerClosed.Load())
+
+	ds.Dispose()
+
+	require.Eventually(t, func() bool {
+		return brokerClosed.Load() == 1 && controllerClosed.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+	select {
+	case <-ds.keepAliveDone:
+	default:
+		t.Fatal("keep-alive goroutine still running after Dispose")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		assert.GreaterOrEqual(t, d, 900*time.Millisecond)
+		assert.Less(t, d, 1100*time.Millisecond)
+	}
+	assert.Equal(t, time.Duration(1), jitter(1))
+}
+
 // ============================================================================
 // Configuration Parsing Tests
 // ============================================================================
@@ -746,8 +2112,8 @@ func TestNewDataSourceInstance(t *testing.T) {
 			expectError: false,
 			validate: func(t *testing.T, instance *DataSource) {
 				assert.NotNil(t, instance.client)
-				assert.NotNil(t, instance.client.brokerClient)
-				assert.Nil(t, instance.client.controllerClient)
+				assert.NotNil(t, instance.client.(*PinotClient).brokerClient)
+				assert.Nil(t, instance.client.(*PinotClient).controllerClient)
 			},
 		},
 		{
@@ -755,8 +2121,8 @@ func TestNewDataSourceInstance(t *testing.T) {
 			jsonData: `{"broker":{"url":"http://localhost:8099","authType":"none"},"controller":{"url":"http://localhost:9000","authType":"none"}}`,
 			expectError: false,
 			validate: func(t *testing.T, instance *DataSource) {
-				assert.NotNil(t, instance.client.brokerClient)
-				assert.NotNil(t, instance.client.controllerClient)
+				assert.NotNil(t, instance.client.(*PinotClient).brokerClient)
+				assert.NotNil(t, instance.client.(*PinotClient).controllerClient)
 			},
 		},
 		{
@@ -767,9 +2133,9 @@ func TestNewDataSourceInstance(t *testing.T) {
 			},
 			expectError: false,
 			validate: func(t *testing.T, instance *DataSource) {
-				assert.Equal(t, AuthTypeBasic, instance.client.brokerClient.authType)
-				assert.Equal(t, "testuser", instance.client.brokerClient.username)
-				assert.Equal(t, "testpass", instance.client.brokerClient.password)
+				assert.Equal(t, AuthTypeBasic, instance.client.(*PinotClient).brokerClient.authType)
+				assert.Equal(t, "testuser", instance.client.(*PinotClient).brokerClient.username)
+				assert.Equal(t, "testpass", instance.client.(*PinotClient).brokerClient.password)
 			},
 		},
 		{
@@ -780,8 +2146,128 @@ func TestNewDataSourceInstance(t *testing.T) {
 			},
 			expectError: false,
 			validate: func(t *testing.T, instance *DataSource) {
-				assert.Equal(t, AuthTypeBearer, instance.client.brokerClient.authType)
-				assert.Equal(t, "test-token-123", instance.client.brokerClient.token)
+				assert.Equal(t, AuthTypeBearer, instance.client.(*PinotClient).brokerClient.authType)
+				assert.Equal(t, "test-token-123", instance.client.(*PinotClient).brokerClient.token)
+			},
+		},
+		{
+			name:     "creates instance with per-endpoint timeouts",
+			jsonData: `{"broker":{"url":"http://localhost:8099","timeoutMs":45000},"controller":{"url":"http://localhost:9000","timeoutMs":5000}}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Equal(t, DefaultQueryTimeout, instance.client.(*PinotClient).brokerClient.httpClient.Timeout)
+				assert.Equal(t, 5*time.Second, instance.client.(*PinotClient).controllerClient.httpClient.Timeout)
+				assert.Equal(t, DefaultQueryTimeout, instance.client.(*PinotClient).queryTimeout)
+			},
+		},
+		{
+			name:     "creates instance with default endpoint timeouts",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"controller":{"url":"http://localhost:9000"}}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Equal(t, DefaultQueryTimeout, instance.client.(*PinotClient).brokerClient.httpClient.Timeout)
+				assert.Equal(t, 30*time.Second, instance.client.(*PinotClient).controllerClient.httpClient.Timeout)
+			},
+		},
+		{
+			name:     "creates instance with custom request timeouts",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"queryTimeoutMs":90000,"metadataTimeoutMs":2000}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Equal(t, 90*time.Second, instance.client.(*PinotClient).queryTimeout)
+				assert.Equal(t, 2*time.Second, instance.client.(*PinotClient).metadataTimeout)
+				assert.Equal(t, 90*time.Second, instance.client.(*PinotClient).brokerClient.httpClient.Timeout)
+			},
+		},
+		{
+			name:     "creates instance with transport timeouts",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"controller":{"url":"http://localhost:9000"},"idleConnTimeoutMs":30000,"responseHeaderTimeoutMs":5000,"expectContinueTimeoutMs":500}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				for _, client := range []*HTTPClient{instance.client.(*PinotClient).brokerClient, instance.client.(*PinotClient).controllerClient} {
+					transport := client.httpClient.Transport.(*http.Transport)
+					assert.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+					assert.Equal(t, 5*time.Second, transport.ResponseHeaderTimeout)
+					assert.Equal(t, 500*time.Millisecond, transport.ExpectContinueTimeout)
+				}
+			},
+		},
+		{
+			name:        "creates instance with HTTP/2 disabled",
+			jsonData:    `{"broker":{"url":"https://localhost:8099"},"controller":{"url":"https://localhost:9000"},"disableHttp2":true}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				for _, client := range []*HTTPClient{instance.client.(*PinotClient).brokerClient, instance.client.(*PinotClient).controllerClient} {
+					transport := client.httpClient.Transport.(*http.Transport)
+					assert.False(t, transport.ForceAttemptHTTP2)
+					assert.NotNil(t, transport.TLSNextProto)
+				}
+			},
+		},
+		{
+			name:     "creates instance with GET queries",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"queryMethod":"GET","maxQueryUrlLength":2048}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Equal(t, http.MethodGet, instance.client.(*PinotClient).queryMethod)
+				assert.Equal(t, 2048, instance.client.(*PinotClient).maxQueryURLLength)
+			},
+		},
+		{
+			name:     "creates instance with strict types",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"strictTypes":true}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.True(t, instance.config.StrictTypes)
+				assert.True(t, instance.conversionOptions().strictTypes)
+			},
+		},
+		{
+			name:     "creates instance with timezone",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"timezone":"Europe/Paris"}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Equal(t, "Europe/Paris", instance.location.String())
+			},
+		},
+		{
+			name:        "fails with invalid timezone",
+			jsonData:    `{"broker":{"url":"http://localhost:8099"},"timezone":"Mars/Olympus"}`,
+			expectError: true,
+			errorMsg:    "invalid timezone",
+		},
+		{
+			name:        "creates instance with a string column pattern",
+			jsonData:    `{"broker":{"url":"http://localhost:8099"},"stringColumnPattern":"(?i)_id$"}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				require.NotNil(t, instance.conversionOptions().stringColumns)
+				assert.True(t, instance.conversionOptions().stringColumns.MatchString("ORDER_ID"))
+			},
+		},
+		{
+			name:        "fails with invalid string column pattern",
+			jsonData:    `{"broker":{"url":"http://localhost:8099"},"stringColumnPattern":"(_id$"}`,
+			expectError: true,
+			errorMsg:    "invalid string column pattern",
+		},
+		{
+			name:     "creates instance without keep-alive by default",
+			jsonData: `{"broker":{"url":"http://localhost:8099"}}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				assert.Nil(t, instance.stopKeepAlive)
+				instance.Dispose()
+			},
+		},
+		{
+			name:     "creates instance with keep-alive",
+			jsonData: `{"broker":{"url":"http://localhost:8099"},"keepAliveIntervalMs":60000}`,
+			expectError: false,
+			validate: func(t *testing.T, instance *DataSource) {
+				require.NotNil(t, instance.stopKeepAlive)
+				instance.Dispose()
+				<-instance.keepAliveDone
 			},
 		},
 		{
@@ -801,7 +2287,7 @@ func TestNewDataSourceInstance(t *testing.T) {
 			jsonData: `{"broker":{"url":"http://localhost:8099","authType":"none","tlsSkipVerify":true}}`,
 			expectError: false,
 			validate: func(t *testing.T, instance *DataSource) {
-				assert.NotNil(t, instance.client.brokerClient)
+				assert.NotNil(t, instance.client.(*PinotClient).brokerClient)
 			},
 		},
 	}
@@ -833,6 +2319,52 @@ func TestNewDataSourceInstance(t *testing.T) {
 	}
 }
 
+func TestNewDataSourceInstance_InsecureTLSWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData string
+		expected []string
+	}{
+		{
+			name:     "does not warn when verification is enabled",
+			jsonData: `{"broker":{"url":"https://localhost:8099"},"controller":{"url":"https://localhost:9000"}}`,
+		},
+		{
+			name:     "warns for broker",
+			jsonData: `{"broker":{"url":"https://localhost:8099","tlsSkipVerify":true},"controller":{"url":"https://localhost:9000"}}`,
+			expected: []string{"broker"},
+		},
+		{
+			name:     "warns for controller",
+			jsonData: `{"broker":{"url":"https://localhost:8099"},"controller":{"url":"https://localhost:9000","tlsSkipVerify":true}}`,
+			expected: []string{"controller"},
+		},
+		{
+			name:     "warns for both endpoints",
+			jsonData: `{"broker":{"url":"https://localhost:8099","tlsSkipVerify":true},"controller":{"url":"https://localhost:9000","tlsSkipVerify":true}}`,
+			expected: []string{"broker", "controller"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &capturingLogger{Logger: backend.Logger}
+			backend.Logger = logger
+			defer func() { backend.Logger = logger.Logger }()
+
+			instance, err := newDataSourceInstance(context.Background(), backend.DataSourceInstanceSettings{JSONData: []byte(tt.jsonData)})
+			require.NoError(t, err)
+			defer instance.(*DataSource).Dispose()
+
+			require.Len(t, logger.warnings, len(tt.expected))
+			for i, endpoint := range tt.expected {
+				assert.Contains(t, logger.warnings[i], "TLS certificate verification is disabled")
+				assert.Contains(t, logger.warnings[i], endpoint)
+			}
+		})
+	}
+}
+
 // ============================================================================
 // Type Tests
 // ============================================================================
@@ -916,3 +2448,49 @@ func TestTablesResponse_JSON(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, []string{"table1", "table2", "table3"}, resp.Tables)
 }
+
+func TestTablesResponse_JSON_TableTypeShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonStr  string
+		expected []string
+	}{
+		{
+			name:     "merges offline and realtime tables",
+			jsonStr:  `{"OFFLINE":["orders","events"],"REALTIME":["clicks"]}`,
+			expected: []string{"orders", "events", "clicks"},
+		},
+		{
+			name:     "dedupes hybrid tables",
+			jsonStr:  `{"OFFLINE":["events"],"REALTIME":["events","clicks"]}`,
+			expected: []string{"events", "clicks"},
+		},
+		{
+			name:     "strips table type suffixes",
+			jsonStr:  `{"OFFLINE":["events_OFFLINE"],"REALTIME":["events_REALTIME"]}`,
+			expected: []string{"events"},
+		},
+		{
+			name:     "accepts a single table type",
+			jsonStr:  `{"REALTIME":["clicks"]}`,
+			expected: []string{"clicks"},
+		},
+		{
+			name:     "prefers the tables field",
+			jsonStr:  `{"tables":["table1"],"OFFLINE":["other"]}`,
+			expected: []string{"table1"},
+		},
+		{
+			name:    "returns no tables for an unknown shape",
+			jsonStr: `{}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp TablesResponse
+			require.NoError(t, json.Unmarshal([]byte(tt.jsonStr), &resp))
+			assert.Equal(t, tt.expected, resp.Tables)
+		})
+	}
+}
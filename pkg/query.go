@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ============================================================================
+// TYPES - Query Model
+// ============================================================================
+
+// Query result formats
+const (
+	FormatTable      = "table"
+	FormatTimeSeries = "timeseries"
+	FormatStats      = "stats" // Execution statistics of the query instead of its rows
+)
+
+// ReduceLast reduces a timeseries to its last data point per series, e.g. for stat and gauge panels
+const ReduceLast = "last"
+
+// QueryModel represents the query sent by the Grafana frontend
+type QueryModel struct {
+	RawSQL         string `json:"rawSql"`
+	Format         string `json:"format"`
+	TimeColumn     string `json:"timeColumn"`     // Time column of timeseries results (detected when empty)
+	HideTimeFilter bool   `json:"hideTimeFilter"` // Runs the query without the time range constraints of the time macros
+	TableType      string `json:"tableType"`      // Queries only the OFFLINE or REALTIME half of a hybrid table
+	ExpandObject   bool   `json:"expandObject"`   // Expands a single object column into a field per key
+	LegendColumn   string `json:"legendColumn"`   // Column whose first value labels and names the value fields
+	KeepRawTime    bool   `json:"keepRawTime"`    // Keeps a numeric epoch time column as an extra <column>_raw field
+	SplitColumns   bool   `json:"splitColumns"`   // Returns a single-row result as one frame per column, e.g. for stat panels
+
+	// Display names of the result columns (e.g. cnt: Count), keeping the field names for transforms
+	ColumnAliases map[string]string `json:"columnAliases,omitempty"`
+
+	// Runs the query without the datasource default LIMIT, e.g. for exports
+	NoLimit bool `json:"noLimit,omitempty"`
+
+	// Page of the result for server-side paging, appended as LIMIT offset, limit or used by $__offset/$__limit
+	Offset int64 `json:"offset,omitempty"`
+	Limit  int64 `json:"limit,omitempty"`
+
+	// Selected column sorting the result server-side, appended as ORDER BY when the SQL has none
+	SortColumn string `json:"sortColumn,omitempty"`
+	SortDesc   bool   `json:"sortDesc,omitempty"`
+
+	// Columns returned as string fields whatever their Pinot type, e.g. LONG ids joined with other datasources
+	StringColumns []string `json:"stringColumns,omitempty"`
+
+	// Broker query options (e.g. useMultiStageEngine), also read from a leading "-- options: {...}" comment
+	QueryOptions map[string]interface{} `json:"queryOptions,omitempty"`
+
+	// Sends enableNullHandling=true so the broker returns SQL NULLs instead of default values
+	EnableNullHandling bool `json:"enableNullHandling,omitempty"`
+
+	// Reduces a timeseries server-side, e.g. "last" keeps only the latest point of each series
+	Reduce string `json:"reduce,omitempty"`
+
+	// Splits a timeseries with a single string column (e.g. SELECT ts, host, value) into a series per value
+	AutoLabels bool `json:"autoLabels,omitempty"`
+
+	// Parses the time column of a timeseries with its format in the table schema (e.g. epoch seconds)
+	EnrichFromSchema bool `json:"enrichFromSchema,omitempty"`
+
+	// Dashboard timezone (e.g. Europe/Berlin) aligning the calendar buckets of $__timeGroup
+	Timezone string `json:"timezone,omitempty"`
+
+	// Explicit time range in epoch milliseconds, used when the request carries no time range
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+// ============================================================================
+// TYPES - Pinot Broker Response
+// ============================================================================
+
+// PinotResponse represents the response of the broker's /query/sql endpoint
+type PinotResponse struct {
+	ResultTable *ResultTable     `json:"resultTable"`
+	Exceptions  []PinotException `json:"exceptions"`
+
+	// ResultTables holds every result table when the response has several, as returned by some
+	// proxies for a UNION; ResultTable is then the first of them. See UnmarshalJSON
+	ResultTables []*ResultTable `json:"-"`
+
+	// Execution statistics
+	NumDocsScanned     int64 `json:"numDocsScanned"`
+	TotalDocs          int64 `json:"totalDocs"`
+	NumSegmentsQueried int64 `json:"numSegmentsQueried"`
+	TimeUsedMs         int64 `json:"timeUsedMs"`
+
+	// Consuming (not yet committed) segments hit on realtime tables, telling how fresh the result is
+	NumConsumingSegmentsQueried   int64 `json:"numConsumingSegmentsQueried"`
+	NumConsumingSegmentsProcessed int64 `json:"numConsumingSegmentsProcessed"`
+	NumConsumingSegmentsMatched   int64 `json:"numConsumingSegmentsMatched"`
+	MinConsumingFreshnessTimeMs   int64 `json:"minConsumingFreshnessTimeMs"` // Epoch ms of the oldest latest ingested row
+
+	// Timing breakdowns, nil when the broker did not return them (they vary with the Pinot version)
+	BrokerReduceTimeMs                     *int64 `json:"brokerReduceTimeMs"`
+	OfflineThreadCpuTimeNs                 *int64 `json:"offlineThreadCpuTimeNs"`
+	RealtimeThreadCpuTimeNs                *int64 `json:"realtimeThreadCpuTimeNs"`
+	OfflineSystemActivitiesCpuTimeNs       *int64 `json:"offlineSystemActivitiesCpuTimeNs"`
+	RealtimeSystemActivitiesCpuTimeNs      *int64 `json:"realtimeSystemActivitiesCpuTimeNs"`
+	OfflineResponseSerializationCpuTimeNs  *int64 `json:"offlineResponseSerializationCpuTimeNs"`
+	RealtimeResponseSerializationCpuTimeNs *int64 `json:"realtimeResponseSerializationCpuTimeNs"`
+	OfflineTotalCpuTimeNs                  *int64 `json:"offlineTotalCpuTimeNs"`
+	RealtimeTotalCpuTimeNs                 *int64 `json:"realtimeTotalCpuTimeNs"`
+
+	// Correlation identifiers (not returned by every Pinot version)
+	RequestID string `json:"requestId"`
+	BrokerID  string `json:"brokerId"`
+
+	// CorrelationID is the ID sent to the broker with the query, see withCorrelationID
+	CorrelationID string `json:"-"`
+
+	// Raw holds the response bytes as received when DataSourceConfig.DebugRawResponse is set,
+	// cut to MaxRawResponseBytes (RawTruncated is then set)
+	Raw          []byte `json:"-"`
+	RawTruncated bool   `json:"-"`
+}
+
+// UnmarshalJSON accepts, besides a single resultTable object, the arrays of result tables returned
+// by some proxies, either as resultTable or as resultTables. Numbers are kept as json.Number.
+func (r *PinotResponse) UnmarshalJSON(b []byte) error {
+	type response PinotResponse // Without this method, to decode the other fields
+	aux := struct {
+		*response
+		ResultTable  json.RawMessage `json:"resultTable"`
+		ResultTables json.RawMessage `json:"resultTables"`
+	}{response: (*response)(r)}
+	if err := decodeNumbers(b, &aux); err != nil {
+		return err
+	}
+
+	r.ResultTable, r.ResultTables = nil, nil
+	for _, raw := range []json.RawMessage{aux.ResultTable, aux.ResultTables} {
+		raw = bytes.TrimSpace(raw)
+		switch {
+		case len(raw) == 0 || string(raw) == "null":
+		case raw[0] == '[':
+			var tables []*ResultTable
+			if err := decodeNumbers(raw, &tables); err != nil {
+				return err
+			}
+			for _, table := range tables {
+				if table != nil {
+					r.ResultTables = append(r.ResultTables, table)
+				}
+			}
+		default:
+			var table ResultTable
+			if err := decodeNumbers(raw, &table); err != nil {
+				return err
+			}
+			r.ResultTables = append(r.ResultTables, &table)
+		}
+	}
+
+	if len(r.ResultTables) > 0 {
+		r.ResultTable = r.ResultTables[0]
+	}
+	if len(r.ResultTables) < 2 {
+		r.ResultTables = nil
+	}
+	return nil
+}
+
+// decodeNumbers decodes the JSON into v, keeping numbers as json.Number like newResponseDecoder
+func decodeNumbers(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// MaxRawResponseBytes caps the raw response kept in frame meta, see DataSourceConfig.DebugRawResponse
+const MaxRawResponseBytes = 1 << 20
+
+// ResultTable holds the schema and rows of a query result
+type ResultTable struct {
+	DataSchema DataSchema      `json:"dataSchema"`
+	Rows       [][]interface{} `json:"rows"`
+}
+
+// DataSchema describes the columns of a result table
+type DataSchema struct {
+	ColumnNames     []string `json:"columnNames"`
+	ColumnDataTypes []string `json:"columnDataTypes"`
+}
+
+// PinotException represents an error reported by the broker for a query
+type PinotException struct {
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
+func (e *PinotException) Error() string {
+	return fmt.Sprintf("Pinot query error (code %d): %s", e.ErrorCode, e.Message)
+}
+
+// stackFrameRegex matches the frame lines of a Java stack trace, e.g. "\tat org.apache..." or "\t... 12 more"
+var stackFrameRegex = regexp.MustCompile(`^\s+(at |\.\.\. \d+ more)`)
+
+// withoutStackTrace returns the exception with the stack frame lines removed from its message
+// The exception and "Caused by" lines are kept
+func (e PinotException) withoutStackTrace() PinotException {
+	lines := strings.Split(e.Message, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !stackFrameRegex.MatchString(line) {
+			kept = append(kept, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	e.Message = strings.TrimSpace(strings.Join(kept, "\n"))
+	return e
+}
+
+// ============================================================================
+// QUERY - Execution
+// ============================================================================
+
+// executeQuery runs a single Grafana query against the broker and converts the result
+// Panics raised while processing a malformed response are turned into an error response
+func (ds *DataSource) executeQuery(ctx context.Context, query backend.DataQuery) (response backend.DataResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			backend.Logger.Error("Recovered from panic while executing query", "refId", query.RefID, "panic", r)
+			response = backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("internal error while processing query: %v", r))
+		}
+	}()
+
+	var qm QueryModel
+	if len(query.JSON) > 0 {
+		if err := json.Unmarshal(query.JSON, &qm); err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("failed to parse query: %v", err))
+		}
+	}
+
+	sql, err := ds.buildSQL(ctx, &qm, query.TimeRange, query.MaxDataPoints, query.Interval)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if sql == "" {
+		return backend.DataResponse{}
+	}
+	if sql, err = ds.guardSQL(sql); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	if ds.config.MaxSQLLength > 0 && len(sql) > ds.config.MaxSQLLength {
+		// Typically a multi-value variable expanded into a huge IN list, which the broker rejects opaquely
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf(
+			"query is %d bytes long, over the %d bytes allowed by the datasource; narrow the filter, e.g. select fewer values of multi-value variables",
+			len(sql), ds.config.MaxSQLLength))
+	}
+
+	pinotResp, err := ds.sendQuery(ctx, sql, qm.QueryOptions)
+	var pinotErr *PinotException
+	if errors.As(err, &pinotErr) {
+		return pinotErrorResponse(query.RefID, sql, pinotErr)
+	}
+	if err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
+
+	opts := ds.conversionOptions()
+	if qm.EnrichFromSchema && qm.Format == FormatTimeSeries {
+		if err := ds.enrichFromSchema(ctx, sql, &qm, pinotResp, &opts); err != nil {
+			return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+		}
+	}
+
+	frames, err := convertToDataFrames(query.RefID, pinotResp, qm, opts)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("failed to convert query response: %v", err))
+	}
+	frames = splitFrames(frames, ds.config.MaxRowsPerFrame)
+	setFrameMeta(frames, sql, pinotResp)
+	ds.addScanRatioNotice(frames, pinotResp)
+	addEmptyResultNotice(frames, pinotResp)
+
+	return backend.DataResponse{Frames: frames}
+}
+
+// buildSQL returns the SQL sent to the broker for the query model: the raw SQL with the options
+// comment and other comments (unless keepComments is set) removed, macros expanded and the table
+// type, default database, auto timeseries, sort, pagination and default limit rewrites applied.
+// Inline options are merged into the model. Empty SQL is returned for an empty query.
+func (ds *DataSource) buildSQL(ctx context.Context, qm *QueryModel, requestRange backend.TimeRange, maxDataPoints int64, interval time.Duration) (string, error) {
+	rawSQL, inlineOptions, err := parseOptionsComment(strings.TrimSpace(qm.RawSQL))
+	if err != nil {
+		return "", err
+	}
+	if rawSQL = ds.removeComments(rawSQL); rawSQL == "" {
+		return "", nil
+	}
+	qm.QueryOptions = mergeQueryOptions(qm.QueryOptions, inlineOptions)
+	if ds.config.EnableNullHandling || qm.EnableNullHandling {
+		// An explicit enableNullHandling option still wins
+		qm.QueryOptions = mergeQueryOptions(map[string]interface{}{"enableNullHandling": true}, qm.QueryOptions)
+	}
+
+	timezone, err := ds.macroTimezone(qm.Timezone)
+	if err != nil {
+		return "", err
+	}
+	if qm.Offset < 0 || qm.Limit < 0 {
+		return "", fmt.Errorf("offset and limit must not be negative")
+	}
+	if qm.Reduce != "" && qm.Reduce != ReduceLast {
+		return "", fmt.Errorf("invalid reduce %q, expected %s", qm.Reduce, ReduceLast)
+	}
+
+	timeRange := qm.effectiveTimeRange(requestRange)
+	sql, err := applyMacros(rawSQL, macroContext{
+		timeRange:      timeRange,
+		interval:       computeInterval(timeRange, maxDataPoints, interval),
+		hideTimeFilter: qm.HideTimeFilter,
+		database:       ds.config.DefaultDatabase,
+		timezone:       timezone,
+		offset:         qm.Offset,
+		limit:          qm.Limit,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sql, err = applyTableType(sql, qm.TableType)
+	if err != nil {
+		return "", err
+	}
+	sql = applyDefaultDatabase(sql, ds.config.DefaultDatabase)
+
+	if ds.config.AutoTimeSeries && qm.Format == FormatTimeSeries {
+		if qm.TimeColumn == "" {
+			qm.TimeColumn = ds.config.DefaultTimeColumn
+		}
+		sql = applyAutoTimeSeries(sql, qm.TimeColumn)
+	}
+
+	sql, err = applySort(sql, qm.SortColumn, qm.SortDesc)
+	if err != nil {
+		return "", err
+	}
+	sql = applyPagination(sql, qm.Offset, qm.Limit)
+	if !qm.NoLimit {
+		limit := ds.defaultLimit(ctx)
+		if ds.config.LimitToMaxDataPoints && (qm.Format == "" || qm.Format == FormatTable) && maxDataPoints > 0 && (limit <= 0 || int64(limit) > maxDataPoints) {
+			// Table panels cannot display more rows than their data points
+			limit = int(maxDataPoints)
+		}
+		sql = applyDefaultLimit(sql, limit)
+	}
+
+	return sql, nil
+}
+
+// ============================================================================
+// QUERY - Cluster Default Limit
+// ============================================================================
+
+// ClusterDefaultLimitKey is the cluster config holding the default limit of queries without a LIMIT
+const ClusterDefaultLimitKey = "pinot.broker.default.query.limit"
+
+// DefaultClusterConfigCacheTTL is how long the cluster default limit is reused before it is read again
+const DefaultClusterConfigCacheTTL = 5 * time.Minute
+
+// clusterLimitCache keeps the default limit of the cluster config; the zero value is ready to use
+type clusterLimitCache struct {
+	mu      sync.Mutex
+	limit   int
+	expires time.Time
+}
+
+// defaultLimit returns the LIMIT appended to queries without one: the datasource defaultLimit or,
+// with defaultLimitFromCluster, the default query limit of the cluster config. A cluster without
+// that config (or an unreachable controller) disables the default limit until the cache expires
+func (ds *DataSource) defaultLimit(ctx context.Context) int {
+	if ds.config.DefaultLimit > 0 || !ds.config.DefaultLimitFromCluster {
+		return ds.config.DefaultLimit
+	}
+
+	c := &ds.clusterLimit
+	c.mu.Lock()
+	limit, expires := c.limit, c.expires
+	c.mu.Unlock()
+	if time.Now().Before(expires) {
+		return limit
+	}
+
+	// Fetched without the lock so concurrent queries are not held behind the controller; a failure
+	// is cached as no limit like a cluster without the config
+	limit = 0
+	configs, err := ds.client.ClusterConfigs(ctx)
+	if err != nil {
+		backend.Logger.Warn("Failed to read the default limit from the cluster config", "error", err)
+	} else if value, ok := configs[ClusterDefaultLimitKey]; ok {
+		if clusterLimit, err := convertToInt64(value); err == nil && clusterLimit > 0 {
+			limit = int(clusterLimit)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit, c.expires = limit, time.Now().Add(DefaultClusterConfigCacheTTL)
+	return limit
+}
+
+// removeComments strips the SQL comments, so commented-out macros, tables or statements never reach
+// the macros, rewrites and read-only guard, and the broker receives the SQL that was checked
+// Comment-like sequences in string literals are kept. With keepComments the SQL is left as is.
+func (ds *DataSource) removeComments(sql string) string {
+	if ds.config.KeepComments {
+		return sql
+	}
+	return strings.TrimSpace(stripComments(sql))
+}
+
+// macroTimezone returns the zone aligning calendar buckets: the query timezone, or the datasource
+// timezone for browser time (which the backend cannot resolve) and queries without one
+func (ds *DataSource) macroTimezone(queryTimezone string) (string, error) {
+	timezone := queryTimezone
+	if timezone == "" || strings.EqualFold(timezone, "browser") {
+		timezone = ds.config.Timezone
+	}
+	if timezone == "" || strings.EqualFold(timezone, "utc") {
+		return "UTC", nil
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return "", fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+	return timezone, nil
+}
+
+// effectiveTimeRange returns the request time range, or the explicit range of the query model when
+// the request has none (e.g. queries issued outside a dashboard)
+func (qm QueryModel) effectiveTimeRange(tr backend.TimeRange) backend.TimeRange {
+	if !tr.From.IsZero() || !tr.To.IsZero() || (qm.From == 0 && qm.To == 0) {
+		return tr
+	}
+	return ResourceTimeRange{From: qm.From, To: qm.To}.toBackend()
+}
+
+// guardSQL returns the SQL as sent to the broker: rejected when it is not a read query and writes
+// are not allowed, and with the mandatory filter of the datasource applied
+func (ds *DataSource) guardSQL(sql string) (string, error) {
+	if !ds.config.AllowWriteQueries {
+		if err := checkReadOnly(sql); err != nil {
+			return "", err
+		}
+	}
+	return applyMandatoryFilter(sql, ds.config.MandatoryFilter)
+}
+
+// runQuery guards the SQL, see guardSQL, then executes it like sendQuery
+// Every query path, including resources, goes through it unless it guards the SQL itself
+func (ds *DataSource) runQuery(ctx context.Context, sql string, options map[string]interface{}) (*PinotResponse, error) {
+	sql, err := ds.guardSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	return ds.sendQuery(ctx, sql, options)
+}
+
+// sendQuery executes the SQL as is against the broker and decodes the Pinot response
+// Exceptions reported by Pinot are returned as errors. The configured broker tenant is added to the options
+// and, with debugRawResponse, the raw response is kept in PinotResponse.Raw
+func (ds *DataSource) sendQuery(ctx context.Context, sql string, options map[string]interface{}) (*PinotResponse, error) {
+	if ds.config.BrokerTenant != "" {
+		// A tenant set in the query options still wins
+		options = mergeQueryOptions(map[string]interface{}{"brokerTenant": ds.config.BrokerTenant}, options)
+	}
+
+	ctx, correlationID := withCorrelationID(ctx)
+	backend.Logger.Debug("Running query", "correlationId", correlationID, "sql", sql)
+
+	resp, err := ds.client.QueryWithOptions(ctx, sql, options)
+	var raw *cappedBuffer
+	if err == nil && ds.config.DebugRawResponse {
+		raw = &cappedBuffer{max: MaxRawResponseBytes}
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(resp.Body, raw), resp.Body}
+	}
+
+	pinotResp, err := interpretQueryResponse(resp, err)
+	var pinotErr *PinotException
+	if errors.As(err, &pinotErr) && !ds.config.DebugErrors {
+		backend.Logger.Debug("Pinot query exception", "correlationId", correlationID, "errorCode", pinotErr.ErrorCode, "message", pinotErr.Message)
+		ex := pinotErr.withoutStackTrace()
+		return nil, &ex
+	}
+	if err != nil {
+		backend.Logger.Debug("Query failed", "correlationId", correlationID, "error", err)
+		return nil, err
+	}
+
+	pinotResp.CorrelationID = correlationID
+	if raw != nil {
+		pinotResp.Raw, pinotResp.RawTruncated = raw.buf.Bytes(), raw.truncated
+	}
+	return pinotResp, nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and drops the rest
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write never fails, so reads teed into the buffer are not interrupted once it is full
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	kept := p
+	if room := b.max - b.buf.Len(); len(kept) > room {
+		kept = kept[:max(room, 0)]
+		b.truncated = true
+	}
+	b.buf.Write(kept)
+	return len(p), nil
+}
+
+// interpretQueryResponse decodes the broker answer of a query, so every caller gets the same error
+// shapes: Pinot exceptions are returned as *PinotException whether the broker reported them in a
+// 200 response or with an error status, and other failures as they are (e.g. *StatusError)
+func interpretQueryResponse(resp *http.Response, err error) (*PinotResponse, error) {
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			var pinotResp PinotResponse
+			if json.Unmarshal([]byte(statusErr.Body), &pinotResp) == nil && len(pinotResp.Exceptions) > 0 {
+				return nil, &pinotResp.Exceptions[0]
+			}
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var pinotResp PinotResponse
+	if err := newResponseDecoder(resp.Body).Decode(&pinotResp); err != nil {
+		return nil, fmt.Errorf("failed to parse query response: %w", err)
+	}
+	if len(pinotResp.Exceptions) > 0 {
+		return nil, &pinotResp.Exceptions[0]
+	}
+	for _, table := range append([]*ResultTable{pinotResp.ResultTable}, pinotResp.ResultTables...) {
+		if table != nil && table.Rows == nil {
+			// Some brokers send "rows": null for results without matches; the columns of the
+			// data schema still make an empty result, unlike a response without a result table
+			table.Rows = [][]interface{}{}
+		}
+	}
+
+	return &pinotResp, nil
+}
+
+// pinotErrorResponse returns the error response of a query failed with a broker exception
+// The Pinot error code is exposed as errorCode in the meta of an empty frame for alerting and automation
+func pinotErrorResponse(refID, sql string, pinotErr *PinotException) backend.DataResponse {
+	frame := data.NewFrame(refID)
+	frame.RefID = refID
+	frame.Meta = &data.FrameMeta{
+		ExecutedQueryString: sql,
+		Custom:              map[string]interface{}{"errorCode": pinotErr.ErrorCode},
+	}
+
+	response := backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, pinotErr.Error())
+	response.Frames = data.Frames{frame}
+	return response
+}
+
+// ============================================================================
+// QUERY - Schema Enrichment
+// ============================================================================
+
+// DefaultSchemaCacheTTL is how long the table schemas fetched for enrichFromSchema are reused
+const DefaultSchemaCacheTTL = 5 * time.Minute
+
+// schemaCache keeps table schemas for DefaultSchemaCacheTTL; the zero value is ready to use
+type schemaCache struct {
+	mu      sync.Mutex
+	entries map[string]schemaCacheEntry
+}
+
+type schemaCacheEntry struct {
+	schema  *TableSchema
+	expires time.Time
+}
+
+// get returns the schema of the table, fetching it from the controller when missing or expired
+// Failures are not cached
+func (c *schemaCache) get(ctx context.Context, client PinotAPI, table string) (*TableSchema, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[table]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.schema, nil
+	}
+
+	schema, err := client.TableSchema(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]schemaCacheEntry{}
+	}
+	c.entries[table] = schemaCacheEntry{schema: schema, expires: time.Now().Add(DefaultSchemaCacheTTL)}
+	return schema, nil
+}
+
+// enrichFromSchema sets the time format of the conversion from the dateTime field spec of the
+// time column in the schema of the queried table, so e.g. epoch seconds or yyyyMMdd days are
+// parsed as such. Without a time column, the first result column declared as dateTime becomes it.
+// Queries whose time column has no dateTime spec are converted as usual.
+func (ds *DataSource) enrichFromSchema(ctx context.Context, sql string, qm *QueryModel, pinotResp *PinotResponse, opts *conversionOptions) error {
+	refs := findTableReferences(sql)
+	if len(refs) == 0 || pinotResp.ResultTable == nil {
+		return nil
+	}
+	table := stripTableType(refs[0].name)
+
+	schema, err := ds.schemas.get(ctx, ds.client, table)
+	if err != nil {
+		return fmt.Errorf("failed to get the schema of table %q to enrich the query: %w", table, err)
+	}
+
+	for _, column := range pinotResp.ResultTable.DataSchema.ColumnNames {
+		if qm.TimeColumn != "" && !strings.EqualFold(column, qm.TimeColumn) {
+			continue
+		}
+		for _, spec := range schema.DateTimeFieldSpecs {
+			if !strings.EqualFold(spec.Name, column) {
+				continue
+			}
+			format, err := parseSchemaTimeFormat(spec.Format)
+			if err != nil {
+				return fmt.Errorf("time column %q: %w", spec.Name, err)
+			}
+			qm.TimeColumn = column
+			opts.timeFormat = &format
+			return nil
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// QUERY - Inline Options
+// ============================================================================
+
+// optionsCommentPrefix introduces a leading comment holding query options as a JSON object
+const optionsCommentPrefix = "-- options:"
+
+// parseOptionsComment extracts the options of a leading "-- options: {...}" comment line
+// and returns the SQL without it. SQL without such a comment is returned unchanged.
+func parseOptionsComment(sql string) (string, map[string]interface{}, error) {
+	if len(sql) < len(optionsCommentPrefix) || !strings.EqualFold(sql[:len(optionsCommentPrefix)], optionsCommentPrefix) {
+		return sql, nil, nil
+	}
+
+	comment, rest, _ := strings.Cut(sql[len(optionsCommentPrefix):], "\n")
+
+	var options map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(comment))
+	decoder.UseNumber()
+	if err := decoder.Decode(&options); err != nil {
+		return "", nil, fmt.Errorf("invalid options comment, expected a JSON object: %w", err)
+	}
+
+	return strings.TrimSpace(rest), options, nil
+}
+
+// mergeQueryOptions returns the query options overridden by the inline options
+func mergeQueryOptions(options, inline map[string]interface{}) map[string]interface{} {
+	if len(inline) == 0 {
+		return options
+	}
+
+	merged := make(map[string]interface{}, len(options)+len(inline))
+	for key, value := range options {
+		merged[key] = value
+	}
+	for key, value := range inline {
+		merged[key] = value
+	}
+	return merged
+}
+
+// conversionOptions returns the frame conversion settings of the datasource
+func (ds *DataSource) conversionOptions() conversionOptions {
+	return conversionOptions{
+		strictTypes:    ds.config.StrictTypes,
+		strictTime:     ds.config.StrictTimeSeries,
+		location:       ds.location,
+		lenientNumbers: ds.config.LenientNumbers,
+		nullSentinels:  ds.config.NullSentinels,
+		maxColumns:     ds.config.MaxColumns,
+		geoJSON:        ds.config.GeoJSON,
+		typeOverrides:  ds.config.TypeOverrides,
+		stringColumns:  ds.stringColumns,
+	}
+}
+
+// setFrameMeta attaches the executed query and response details to the frames
+// Meta set during conversion, such as the frame type or custom keys, is preserved. The raw
+// response, which can be large, is only attached to the first frame.
+func setFrameMeta(frames data.Frames, sql string, pinotResp *PinotResponse) {
+	for idx, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = sql
+
+		// A new map per frame, as split frames share the custom map of their meta copies
+		custom := map[string]interface{}{}
+		if existing, ok := frame.Meta.Custom.(map[string]interface{}); ok {
+			for key, value := range existing {
+				custom[key] = value
+			}
+		}
+		for key, value := range responseMeta(pinotResp) {
+			custom[key] = value
+		}
+		if idx == 0 {
+			for key, value := range rawResponseMeta(pinotResp) {
+				custom[key] = value
+			}
+		}
+		frame.Meta.Custom = custom
+	}
+}
+
+// responseMeta collects the response details exposed in frame meta
+// Correlation identifiers and timing breakdowns are only included when the broker returned them,
+// and consuming segment counts when the query hit a realtime table
+func responseMeta(pinotResp *PinotResponse) map[string]interface{} {
+	meta := map[string]interface{}{}
+	if pinotResp.RequestID != "" {
+		meta["requestId"] = pinotResp.RequestID
+	}
+	if pinotResp.BrokerID != "" {
+		meta["brokerId"] = pinotResp.BrokerID
+	}
+	if pinotResp.CorrelationID != "" {
+		meta["correlationId"] = pinotResp.CorrelationID
+	}
+	if ratio, ok := pinotResp.scanRatio(); ok {
+		meta["scanRatio"] = ratio
+	}
+	if pinotResp.NumConsumingSegmentsQueried > 0 {
+		// Only realtime tables have consuming segments, the counts would be noise for the others
+		meta["numConsumingSegmentsQueried"] = pinotResp.NumConsumingSegmentsQueried
+		meta["numConsumingSegmentsProcessed"] = pinotResp.NumConsumingSegmentsProcessed
+		meta["numConsumingSegmentsMatched"] = pinotResp.NumConsumingSegmentsMatched
+		if pinotResp.MinConsumingFreshnessTimeMs > 0 {
+			meta["minConsumingFreshnessTimeMs"] = pinotResp.MinConsumingFreshnessTimeMs
+		}
+	}
+	for key, value := range pinotResp.timings() {
+		meta[key] = value
+	}
+	return meta
+}
+
+// rawResponseMeta returns the raw broker response kept with debugRawResponse as frame meta
+// Complete responses are nested as JSON in the inspector, cut ones are kept as text
+func rawResponseMeta(pinotResp *PinotResponse) map[string]interface{} {
+	if pinotResp.Raw == nil {
+		return nil
+	}
+	raw := bytes.TrimSpace(bytes.TrimPrefix(pinotResp.Raw, []byte(utf8BOM)))
+	if !pinotResp.RawTruncated && json.Valid(raw) {
+		return map[string]interface{}{"pinotRaw": json.RawMessage(raw)}
+	}
+	return map[string]interface{}{"pinotRaw": string(raw), "pinotRawTruncated": pinotResp.RawTruncated}
+}
+
+// timings returns the timing breakdowns present in the response, keyed by their Pinot name
+func (r *PinotResponse) timings() map[string]int64 {
+	timings := map[string]int64{}
+	for key, value := range map[string]*int64{
+		"brokerReduceTimeMs":                     r.BrokerReduceTimeMs,
+		"offlineThreadCpuTimeNs":                 r.OfflineThreadCpuTimeNs,
+		"realtimeThreadCpuTimeNs":                r.RealtimeThreadCpuTimeNs,
+		"offlineSystemActivitiesCpuTimeNs":       r.OfflineSystemActivitiesCpuTimeNs,
+		"realtimeSystemActivitiesCpuTimeNs":      r.RealtimeSystemActivitiesCpuTimeNs,
+		"offlineResponseSerializationCpuTimeNs":  r.OfflineResponseSerializationCpuTimeNs,
+		"realtimeResponseSerializationCpuTimeNs": r.RealtimeResponseSerializationCpuTimeNs,
+		"offlineTotalCpuTimeNs":                  r.OfflineTotalCpuTimeNs,
+		"realtimeTotalCpuTimeNs":                 r.RealtimeTotalCpuTimeNs,
+	} {
+		if value != nil {
+			timings[key] = *value
+		}
+	}
+	return timings
+}
+
+// scanRatio returns the fraction of the table documents scanned by the query
+// It reports false when the broker did not return the total document count
+func (r *PinotResponse) scanRatio() (float64, bool) {
+	if r.TotalDocs <= 0 {
+		return 0, false
+	}
+	return float64(r.NumDocsScanned) / float64(r.TotalDocs), true
+}
+
+// addScanRatioNotice warns when the query scanned more of the table than the configured threshold,
+// which usually points at a missing index
+func (ds *DataSource) addScanRatioNotice(frames data.Frames, pinotResp *PinotResponse) {
+	ratio, ok := pinotResp.scanRatio()
+	if !ok {
+		return
+	}
+
+	threshold := ds.config.ScanRatioWarningThreshold
+	if threshold <= 0 {
+		threshold = DefaultScanRatioWarningThreshold
+	}
+	if ratio <= threshold {
+		return
+	}
+
+	notice := data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("The query scanned %.0f%% of the table documents (%d of %d), consider reviewing the table indexes",
+			ratio*100, pinotResp.NumDocsScanned, pinotResp.TotalDocs),
+	}
+	for _, frame := range frames {
+		frame.AppendNotices(notice)
+	}
+}
+
+// addEmptyResultNotice tells that a query without rows ran successfully, so the "No data" of the
+// panel is not mistaken for an error
+func addEmptyResultNotice(frames data.Frames, pinotResp *PinotResponse) {
+	for _, frame := range frames {
+		if frame.Rows() > 0 {
+			return
+		}
+	}
+
+	notice := data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("The query ran successfully but matched no rows (%d documents scanned)", pinotResp.NumDocsScanned),
+	}
+	for _, frame := range frames {
+		frame.AppendNotices(notice)
+	}
+}
@@ -0,0 +1,2083 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// updateGoldenFiles regenerates the golden responses in testdata: go test ./pkg -update-golden
+var updateGoldenFiles = flag.Bool("update-golden", false, "update golden response files")
+
+// ============================================================================
+// Test Helpers
+// ============================================================================
+
+// newMockedDataSource creates a datasource whose broker client is served by httpmock
+func newMockedDataSource(t *testing.T) *DataSource {
+	t.Helper()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:      "http://test-broker:8099",
+		BrokerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+
+	return &DataSource{client: client}
+}
+
+// newMockedDataSourceWithController creates a datasource whose broker and controller clients are served by httpmock
+func newMockedDataSourceWithController(t *testing.T) *DataSource {
+	t.Helper()
+
+	client, err := New(PinotClientOptions{
+		BrokerUrl:          "http://test-broker:8099",
+		BrokerAuthType:     AuthTypeNone,
+		ControllerUrl:      "http://test-controller:9000",
+		ControllerAuthType: AuthTypeNone,
+	})
+	require.NoError(t, err)
+
+	httpmock.ActivateNonDefault(client.brokerClient.httpClient)
+	httpmock.ActivateNonDefault(client.controllerClient.httpClient)
+
+	return &DataSource{client: client}
+}
+
+// fakePinotAPI is an in-memory PinotAPI for tests that need no HTTP-level behavior
+type fakePinotAPI struct {
+	healthErr     error
+	queryResponse string // Broker response body returned for every query
+	queryErr      error
+	tables        []string
+	tablesErr     error
+	schemas       map[string]*TableSchema
+
+	clusterConfigs    map[string]interface{}
+	clusterConfigsErr error
+
+	queries            []string // SQL of the received queries
+	schemaCalls        int      // Number of TableSchema calls
+	clusterConfigCalls int      // Number of ClusterConfigs calls
+}
+
+var errNotFaked = errors.New("not implemented by the fake")
+
+func (f *fakePinotAPI) Health(ctx context.Context) error {
+	return f.healthErr
+}
+
+func (f *fakePinotAPI) Query(ctx context.Context, sql string) (*http.Response, error) {
+	return f.QueryWithOptions(ctx, sql, nil)
+}
+
+func (f *fakePinotAPI) QueryWithOptions(ctx context.Context, sql string, options map[string]interface{}) (*http.Response, error) {
+	f.queries = append(f.queries, sql)
+	if f.queryErr != nil {
+		return nil, f.queryErr
+	}
+	return httpmock.NewStringResponse(http.StatusOK, f.queryResponse), nil
+}
+
+func (f *fakePinotAPI) Tables(ctx context.Context) ([]string, error) {
+	return f.tables, f.tablesErr
+}
+
+func (f *fakePinotAPI) Schemas(ctx context.Context) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakePinotAPI) TableSchema(ctx context.Context, table string) (*TableSchema, error) {
+	f.schemaCalls++
+	if schema, ok := f.schemas[table]; ok {
+		return schema, nil
+	}
+	return nil, &StatusError{Operation: "get table schema", StatusCode: http.StatusNotFound}
+}
+
+func (f *fakePinotAPI) TableSize(ctx context.Context, table string) (*TableSize, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakePinotAPI) Instances(ctx context.Context) ([]string, error) {
+	return nil, errNotFaked
+}
+
+func (f *fakePinotAPI) ClusterConfigs(ctx context.Context) (map[string]interface{}, error) {
+	f.clusterConfigCalls++
+	if f.clusterConfigs == nil && f.clusterConfigsErr == nil {
+		return nil, errNotFaked
+	}
+	return f.clusterConfigs, f.clusterConfigsErr
+}
+
+// newDataQuery creates a Grafana data query from a query model
+func newDataQuery(t *testing.T, refID string, qm QueryModel) backend.DataQuery {
+	t.Helper()
+
+	queryJSON, err := json.Marshal(qm)
+	require.NoError(t, err)
+
+	return backend.DataQuery{RefID: refID, JSON: queryJSON}
+}
+
+// runGoldenQuery executes a query against a mocked broker response and compares it with the golden file
+func runGoldenQuery(t *testing.T, name string, qm QueryModel, response string) backend.DataResponse {
+	t.Helper()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, response))
+
+	// A fixed correlation ID keeps the golden frame meta stable
+	ctx := context.WithValue(context.Background(), correlationIDKey{}, "golden-correlation-id")
+	resp := ds.executeQuery(ctx, newDataQuery(t, "A", qm))
+	require.NoError(t, resp.Error)
+
+	experimental.CheckGoldenJSONResponse(t, "testdata", name, &resp, *updateGoldenFiles)
+
+	return resp
+}
+
+// ============================================================================
+// Query Execution Tests
+// ============================================================================
+
+func TestDataSource_executeQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       QueryModel
+		response    string
+		status      int
+		expectError bool
+		errorMsg    string
+		validate    func(t *testing.T, resp backend.DataResponse)
+	}{
+		{
+			name:     "converts result table into a frame",
+			query:    QueryModel{RawSQL: "SELECT carrier, flights FROM airlineStats"},
+			response: `{"resultTable":{"dataSchema":{"columnNames":["carrier","flights"],"columnDataTypes":["STRING","LONG"]},"rows":[["AA",10],["DL",20]]}}`,
+			status:   200,
+			validate: func(t *testing.T, resp backend.DataResponse) {
+				require.Len(t, resp.Frames, 1)
+				frame := resp.Frames[0]
+				assert.Equal(t, "A", frame.RefID)
+				require.Len(t, frame.Fields, 2)
+				assert.Equal(t, "carrier", frame.Fields[0].Name)
+				assert.Equal(t, "flights", frame.Fields[1].Name)
+				assert.Equal(t, 2, frame.Rows())
+				require.NotNil(t, frame.Meta)
+				assert.Equal(t, "SELECT carrier, flights FROM airlineStats", frame.Meta.ExecutedQueryString)
+			},
+		},
+		{
+			name:     "includes request and broker IDs in frame meta",
+			query:    QueryModel{RawSQL: "SELECT 1"},
+			response: `{"resultTable":{"dataSchema":{"columnNames":["1"],"columnDataTypes":["LONG"]},"rows":[[1]]},"requestId":"236490978000000006","brokerId":"Broker_pinot-broker_8099"}`,
+			status:   200,
+			validate: func(t *testing.T, resp backend.DataResponse) {
+				require.Len(t, resp.Frames, 1)
+				require.NotNil(t, resp.Frames[0].Meta)
+				custom, ok := resp.Frames[0].Meta.Custom.(map[string]interface{})
+				require.True(t, ok)
+				assert.Equal(t, "236490978000000006", custom["requestId"])
+				assert.Equal(t, "Broker_pinot-broker_8099", custom["brokerId"])
+			},
+		},
+		{
+			name:     "omits correlation IDs not returned by the broker",
+			query:    QueryModel{RawSQL: "SELECT 1"},
+			response: `{"resultTable":{"dataSchema":{"columnNames":["1"],"columnDataTypes":["LONG"]},"rows":[[1]]}}`,
+			status:   200,
+			validate: func(t *testing.T, resp backend.DataResponse) {
+				custom, ok := resp.Frames[0].Meta.Custom.(map[string]interface{})
+				require.True(t, ok)
+				assert.NotContains(t, custom, "requestId")
+				assert.NotContains(t, custom, "brokerId")
+			},
+		},
+		{
+			name:        "returns Pinot exceptions as errors",
+			query:       QueryModel{RawSQL: "SELECT * FROM missing"},
+			response:    `{"exceptions":[{"errorCode":190,"message":"TableDoesNotExistError"}]}`,
+			status:      200,
+			expectError: true,
+			errorMsg:    "Pinot query error (code 190): TableDoesNotExistError",
+		},
+		{
+			name:        "returns HTTP failures as errors",
+			query:       QueryModel{RawSQL: "SELECT 1"},
+			response:    `Internal Server Error`,
+			status:      500,
+			expectError: true,
+			errorMsg:    "query failed with status 500",
+		},
+		{
+			name:        "returns an error for scalar rows",
+			query:       QueryModel{RawSQL: "SELECT name FROM t"},
+			response:    `{"resultTable":{"dataSchema":{"columnNames":["name"],"columnDataTypes":["STRING"]},"rows":[1,2]}}`,
+			status:      200,
+			expectError: true,
+			errorMsg:    "failed to parse query response",
+		},
+		{
+			name:        "returns an error for a non-array result table",
+			query:       QueryModel{RawSQL: "SELECT name FROM t"},
+			response:    `{"resultTable":{"dataSchema":{"columnNames":"name"},"rows":"oops"}}`,
+			status:      200,
+			expectError: true,
+			errorMsg:    "failed to parse query response",
+		},
+		{
+			name:  "returns no frames for an empty query",
+			query: QueryModel{RawSQL: "   "},
+			validate: func(t *testing.T, resp backend.DataResponse) {
+				assert.Empty(t, resp.Frames)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(tt.status, tt.response))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			if tt.expectError {
+				require.Error(t, resp.Error)
+				assert.Contains(t, resp.Error.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, resp.Error)
+				tt.validate(t, resp)
+			}
+		})
+	}
+}
+
+func TestDataSource_executeQuery_MaxRowsPerFrame(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.MaxRowsPerFrame = 2
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["carrier"],"columnDataTypes":["STRING"]},"rows":[["AA"],["DL"],["UA"]]},"requestId":"42"}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT carrier FROM airlineStats"}))
+
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 2)
+	assert.Equal(t, 2, resp.Frames[0].Rows())
+	assert.Equal(t, "UA", *resp.Frames[1].Fields[0].At(0).(*string))
+	for _, frame := range resp.Frames {
+		assert.Equal(t, "SELECT carrier FROM airlineStats", frame.Meta.ExecutedQueryString)
+		assert.Equal(t, "42", frame.Meta.Custom.(map[string]interface{})["requestId"])
+	}
+}
+
+// capturingLogger records the debug and warning logs of the plugin logger
+type capturingLogger struct {
+	log.Logger
+	mu       sync.Mutex
+	entries  []string
+	warnings []string
+}
+
+func (l *capturingLogger) Debug(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func (l *capturingLogger) Warn(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func TestDataSource_executeQuery_DebugRawResponse(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	body := `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]},"numServersQueried":2}`
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", httpmock.NewStringResponder(200, body))
+
+	ds := newMockedDataSource(t)
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+	require.NoError(t, resp.Error)
+	assert.NotContains(t, resp.Frames[0].Meta.Custom, "pinotRaw")
+
+	ds.config.DebugRawResponse = true
+	resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+	require.NoError(t, resp.Error)
+	custom := resp.Frames[0].Meta.Custom.(map[string]interface{})
+	assert.JSONEq(t, body, string(custom["pinotRaw"].(json.RawMessage)))
+	assert.NotContains(t, custom, "pinotRawTruncated")
+
+	// The raw response is serialized as nested JSON in the frame meta
+	meta, err := json.Marshal(resp.Frames[0].Meta)
+	require.NoError(t, err)
+	assert.Contains(t, string(meta), `"pinotRaw":{"resultTable"`)
+}
+
+func TestSetFrameMeta_RawResponseOnFirstFrame(t *testing.T) {
+	shared := map[string]interface{}{"splitIndex": 1}
+	frames := data.Frames{
+		data.NewFrame("A").SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesWide, Custom: shared}),
+		data.NewFrame("A").SetMeta(&data.FrameMeta{Custom: shared}),
+		data.NewFrame("A"),
+	}
+	pinotResp := &PinotResponse{RequestID: "req-1", Raw: []byte(`{"resultTable":null}`)}
+
+	setFrameMeta(frames, "SELECT a FROM t", pinotResp)
+
+	first := frames[0].Meta.Custom.(map[string]interface{})
+	assert.Contains(t, first, "pinotRaw")
+	// Custom meta set before is merged rather than replaced
+	assert.Equal(t, 1, first["splitIndex"])
+	assert.Equal(t, "req-1", first["requestId"])
+	assert.Equal(t, data.FrameTypeTimeSeriesWide, frames[0].Meta.Type)
+
+	for _, frame := range frames[1:] {
+		custom := frame.Meta.Custom.(map[string]interface{})
+		assert.NotContains(t, custom, "pinotRaw")
+		assert.Equal(t, "req-1", custom["requestId"])
+		assert.Equal(t, "SELECT a FROM t", frame.Meta.ExecutedQueryString)
+	}
+	assert.Equal(t, 1, frames[1].Meta.Custom.(map[string]interface{})["splitIndex"])
+	// The map shared by the frames is left untouched
+	assert.Equal(t, map[string]interface{}{"splitIndex": 1}, shared)
+}
+
+func TestResponseMeta_RawTruncated(t *testing.T) {
+	raw := &cappedBuffer{max: 10}
+	n, err := raw.Write([]byte(`{"resultTable":`))
+	require.NoError(t, err)
+	assert.Equal(t, 15, n)
+	n, err = raw.Write([]byte(`null}`))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	meta := rawResponseMeta(&PinotResponse{Raw: raw.buf.Bytes(), RawTruncated: raw.truncated})
+	assert.Equal(t, `{"resultTa`, meta["pinotRaw"])
+	assert.Equal(t, true, meta["pinotRawTruncated"])
+}
+
+func TestDataSource_executeQuery_CorrelationID(t *testing.T) {
+	traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	traceCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		validate func(t *testing.T, correlationID string)
+	}{
+		{
+			name: "generates a UUID without trace context",
+			ctx:  context.Background(),
+			validate: func(t *testing.T, correlationID string) {
+				_, err := uuid.Parse(correlationID)
+				assert.NoError(t, err)
+			},
+		},
+		{
+			name: "uses the upstream trace ID",
+			ctx:  traceCtx,
+			validate: func(t *testing.T, correlationID string) {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", correlationID)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			logger := &capturingLogger{Logger: backend.Logger}
+			backend.Logger = logger
+			defer func() { backend.Logger = logger.Logger }()
+
+			ds := newMockedDataSource(t)
+			var header string
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				func(req *http.Request) (*http.Response, error) {
+					header = req.Header.Get(CorrelationIDHeader)
+					return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`), nil
+				})
+
+			resp := ds.executeQuery(tt.ctx, newDataQuery(t, "A", QueryModel{RawSQL: "SELECT 1"}))
+			require.NoError(t, resp.Error)
+
+			require.NotEmpty(t, header)
+			tt.validate(t, header)
+			assert.Equal(t, header, resp.Frames[0].Meta.Custom.(map[string]interface{})["correlationId"])
+
+			logged := false
+			for _, entry := range logger.entries {
+				logged = logged || strings.Contains(entry, header)
+			}
+			assert.True(t, logged, "correlation ID %s not logged", header)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_ReadOnly(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "DROP TABLE airlineStats"}))
+	require.Error(t, resp.Error)
+	assert.Contains(t, resp.Error.Error(), "only read queries are allowed")
+	assert.Equal(t, backend.StatusBadRequest, resp.Status)
+	assert.Equal(t, 0, httpmock.GetTotalCallCount())
+
+	// Datasources configured for writes pass the statement through to the broker
+	ds.config.AllowWriteQueries = true
+	resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "DROP TABLE airlineStats"}))
+	require.NoError(t, resp.Error)
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+}
+
+func TestDataSource_executeQuery_ScanRatioNotice(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     float64
+		stats         string
+		expectNotice  bool
+		expectedRatio interface{}
+	}{
+		{
+			name:          "warns above the default threshold",
+			stats:         `"numDocsScanned":900,"totalDocs":1000`,
+			expectNotice:  true,
+			expectedRatio: 0.9,
+		},
+		{
+			name:          "does not warn below the threshold",
+			stats:         `"numDocsScanned":100,"totalDocs":1000`,
+			expectedRatio: 0.1,
+		},
+		{
+			name:          "uses the configured threshold",
+			threshold:     0.05,
+			stats:         `"numDocsScanned":100,"totalDocs":1000`,
+			expectNotice:  true,
+			expectedRatio: 0.1,
+		},
+		{
+			name:  "omits the ratio without document counts",
+			stats: `"numDocsScanned":0,"totalDocs":0`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.ScanRatioWarningThreshold = tt.threshold
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]},`+tt.stats+`}`))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+			require.NoError(t, resp.Error)
+
+			meta := resp.Frames[0].Meta
+			custom := meta.Custom.(map[string]interface{})
+			if tt.expectedRatio == nil {
+				assert.NotContains(t, custom, "scanRatio")
+			} else {
+				assert.InDelta(t, tt.expectedRatio, custom["scanRatio"], 1e-9)
+			}
+
+			if tt.expectNotice {
+				require.Len(t, meta.Notices, 1)
+				assert.Equal(t, data.NoticeSeverityWarning, meta.Notices[0].Severity)
+				assert.Contains(t, meta.Notices[0].Text, "consider reviewing the table indexes")
+			} else {
+				assert.Empty(t, meta.Notices)
+			}
+		})
+	}
+}
+
+func TestDataSource_executeQuery_EmptyResultNotice(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[]},"numDocsScanned":12,"totalDocs":1000}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t WHERE a > 100"}))
+
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	assert.Equal(t, 0, resp.Frames[0].Rows())
+	require.Len(t, resp.Frames[0].Meta.Notices, 1)
+	notice := resp.Frames[0].Meta.Notices[0]
+	assert.Equal(t, data.NoticeSeverityInfo, notice.Severity)
+	assert.Equal(t, "The query ran successfully but matched no rows (12 documents scanned)", notice.Text)
+
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]},"numDocsScanned":1,"totalDocs":1000}`))
+
+	resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+
+	require.NoError(t, resp.Error)
+	assert.Empty(t, resp.Frames[0].Meta.Notices)
+}
+
+func TestDataSource_executeQuery_NullRows(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   string
+		format string
+	}{
+		{"null rows as a table", "null", FormatTable},
+		{"empty rows as a table", "[]", FormatTable},
+		{"null rows as a timeseries", "null", FormatTimeSeries},
+		{"empty rows as a timeseries", "[]", FormatTimeSeries},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: `{"resultTable":{"dataSchema":{"columnNames":["ts","carrier","delay"],"columnDataTypes":["TIMESTAMP","STRING","DOUBLE"]},"rows":` + tt.rows + `},"numDocsScanned":0}`}
+			ds := &DataSource{client: client}
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT ts, carrier, delay FROM airlineStats", Format: tt.format}))
+
+			require.NoError(t, resp.Error)
+			require.Len(t, resp.Frames, 1)
+			frame := resp.Frames[0]
+			assert.Equal(t, 0, frame.Rows())
+			if tt.format == FormatTable {
+				require.Len(t, frame.Fields, 3)
+				assert.Equal(t, "ts", frame.Fields[0].Name)
+				assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[0].Type())
+				assert.Equal(t, "carrier", frame.Fields[1].Name)
+				assert.Equal(t, data.FieldTypeNullableFloat64, frame.Fields[2].Type())
+			}
+			require.NotNil(t, frame.Meta)
+			require.Len(t, frame.Meta.Notices, 1)
+			assert.Contains(t, frame.Meta.Notices[0].Text, "matched no rows")
+		})
+	}
+}
+
+func TestDataSource_executeQuery_MultipleResultTables(t *testing.T) {
+	tables := `[` +
+		`{"dataSchema":{"columnNames":["carrier","flights"],"columnDataTypes":["STRING","LONG"]},"rows":[["AA",10],["DL",20]]},` +
+		`{"dataSchema":{"columnNames":["origin"],"columnDataTypes":["STRING"]},"rows":[["SFO"]]}]`
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"an array of result tables", `{"resultTable":` + tables + `}`},
+		{"a resultTables field", `{"resultTables":` + tables + `}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &DataSource{client: &fakePinotAPI{queryResponse: tt.response}}
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT carrier, flights FROM a UNION SELECT origin FROM b"}))
+
+			require.NoError(t, resp.Error)
+			require.Len(t, resp.Frames, 2)
+
+			first, second := resp.Frames[0], resp.Frames[1]
+			assert.Equal(t, "A_1", first.Name)
+			assert.Equal(t, "A", first.RefID)
+			require.Len(t, first.Fields, 2)
+			assert.Equal(t, "carrier", first.Fields[0].Name)
+			assert.Equal(t, 2, first.Rows())
+
+			assert.Equal(t, "A_2", second.Name)
+			assert.Equal(t, "A", second.RefID)
+			require.Len(t, second.Fields, 1)
+			assert.Equal(t, "origin", second.Fields[0].Name)
+			assert.Equal(t, 1, second.Rows())
+		})
+	}
+}
+
+func TestDataSource_executeQuery_ConsumingSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    string
+		expected map[string]interface{}
+	}{
+		{
+			name:  "exposes the counts of a realtime query",
+			stats: `"numConsumingSegmentsQueried":4,"numConsumingSegmentsProcessed":3,"numConsumingSegmentsMatched":2,"minConsumingFreshnessTimeMs":1700000000000`,
+			expected: map[string]interface{}{
+				"numConsumingSegmentsQueried":   int64(4),
+				"numConsumingSegmentsProcessed": int64(3),
+				"numConsumingSegmentsMatched":   int64(2),
+				"minConsumingFreshnessTimeMs":   int64(1700000000000),
+			},
+		},
+		{
+			name:     "omits the counts of an offline query",
+			stats:    `"numConsumingSegmentsQueried":0,"numConsumingSegmentsProcessed":0,"numConsumingSegmentsMatched":0,"minConsumingFreshnessTimeMs":0`,
+			expected: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]},`+tt.stats+`}`))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM events_REALTIME"}))
+			require.NoError(t, resp.Error)
+
+			custom := resp.Frames[0].Meta.Custom.(map[string]interface{})
+			consuming := map[string]interface{}{}
+			for key, value := range custom {
+				if strings.Contains(key, "Consuming") {
+					consuming[key] = value
+				}
+			}
+			assert.Equal(t, tt.expected, consuming)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_Timings(t *testing.T) {
+	timingKeys := []string{
+		"brokerReduceTimeMs", "offlineThreadCpuTimeNs", "realtimeThreadCpuTimeNs",
+		"offlineSystemActivitiesCpuTimeNs", "realtimeSystemActivitiesCpuTimeNs",
+		"offlineResponseSerializationCpuTimeNs", "realtimeResponseSerializationCpuTimeNs",
+		"offlineTotalCpuTimeNs", "realtimeTotalCpuTimeNs",
+	}
+
+	tests := []struct {
+		name     string
+		stats    string
+		expected map[string]interface{}
+	}{
+		{
+			name:  "captures the returned timings",
+			stats: `,"timeUsedMs":12,"brokerReduceTimeMs":3,"offlineThreadCpuTimeNs":1500000,"offlineTotalCpuTimeNs":0,"realtimeTotalCpuTimeNs":2500000`,
+			expected: map[string]interface{}{
+				"brokerReduceTimeMs":     int64(3),
+				"offlineThreadCpuTimeNs": int64(1500000),
+				"offlineTotalCpuTimeNs":  int64(0),
+				"realtimeTotalCpuTimeNs": int64(2500000),
+			},
+		},
+		{
+			name:     "omits timings of older brokers",
+			stats:    `,"timeUsedMs":12`,
+			expected: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}`+tt.stats+`}`))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM events"}))
+			require.NoError(t, resp.Error)
+
+			custom := resp.Frames[0].Meta.Custom.(map[string]interface{})
+			timings := map[string]interface{}{}
+			for _, key := range timingKeys {
+				if value, ok := custom[key]; ok {
+					timings[key] = value
+				}
+			}
+			assert.Equal(t, tt.expected, timings)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_EnrichFromSchema(t *testing.T) {
+	schemas := map[string]*TableSchema{
+		"events": {
+			SchemaName:         "events",
+			DateTimeFieldSpecs: []FieldSpec{{Name: "ts", DataType: "LONG", Format: "1:SECONDS:EPOCH"}},
+		},
+	}
+	response := `{"resultTable":{"dataSchema":{"columnNames":["ts","value"],"columnDataTypes":["LONG","DOUBLE"]},"rows":[[1700000000,1.5]]}}`
+
+	tests := []struct {
+		name        string
+		enrich      bool
+		expected    time.Time
+		schemaCalls int
+	}{
+		{
+			name:        "parses epoch seconds with the schema format",
+			enrich:      true,
+			expected:    time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC),
+			schemaCalls: 1,
+		},
+		{
+			name:     "reads epoch milliseconds without enrichment",
+			enrich:   false,
+			expected: time.UnixMilli(1700000000).UTC(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: response, schemas: schemas}
+			ds := &DataSource{client: client}
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{
+				RawSQL:           "SELECT ts, value FROM events_OFFLINE",
+				Format:           FormatTimeSeries,
+				TimeColumn:       "ts",
+				EnrichFromSchema: tt.enrich,
+			}))
+			require.NoError(t, resp.Error)
+			require.Len(t, resp.Frames, 1)
+
+			field, _ := resp.Frames[0].FieldByName("ts")
+			require.NotNil(t, field)
+			value, ok := field.ConcreteAt(0)
+			require.True(t, ok)
+			assert.Equal(t, tt.expected, value.(time.Time).UTC())
+			assert.Equal(t, tt.schemaCalls, client.schemaCalls)
+		})
+	}
+
+	t.Run("reuses the cached schema", func(t *testing.T) {
+		client := &fakePinotAPI{queryResponse: response, schemas: schemas}
+		ds := &DataSource{client: client}
+		qm := QueryModel{RawSQL: "SELECT ts, value FROM events", Format: FormatTimeSeries, EnrichFromSchema: true}
+
+		for i := 0; i < 2; i++ {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", qm))
+			require.NoError(t, resp.Error)
+		}
+		assert.Equal(t, 1, client.schemaCalls)
+	})
+
+	t.Run("fails when the schema is missing", func(t *testing.T) {
+		ds := &DataSource{client: &fakePinotAPI{queryResponse: response}}
+
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{
+			RawSQL: "SELECT ts, value FROM events", Format: FormatTimeSeries, EnrichFromSchema: true,
+		}))
+		require.Error(t, resp.Error)
+		assert.Contains(t, resp.Error.Error(), `schema of table "events"`)
+	})
+}
+
+func TestDataSource_executeQuery_MaxDataPoints(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	query := newDataQuery(t, "A", QueryModel{RawSQL: "SELECT $__timeGroup(ts), $__interval_ms AS step, COUNT(*) FROM t GROUP BY 1"})
+	query.TimeRange = backend.TimeRange{From: time.UnixMilli(1700000000000), To: time.UnixMilli(1700003600000)}
+	query.MaxDataPoints = 60
+	query.Interval = time.Second
+
+	resp := ds.executeQuery(context.Background(), query)
+
+	require.NoError(t, resp.Error)
+	executed := resp.Frames[0].Meta.ExecutedQueryString
+	assert.Contains(t, executed, "'60000:MILLISECONDS'")
+	assert.Contains(t, executed, "60000 AS step")
+}
+
+func TestDataSource_executeQuery_ExplicitTimeRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         QueryModel
+		timeRange     backend.TimeRange
+		expectedRange string
+	}{
+		{
+			name:          "uses the query model range without a request range",
+			query:         QueryModel{RawSQL: "SELECT * FROM t WHERE $__timeFilter(ts)", From: 1700000000000, To: 1700003600000},
+			expectedRange: "ts >= 1700000000000 AND ts <= 1700003600000",
+		},
+		{
+			name:  "prefers the request range",
+			query: QueryModel{RawSQL: "SELECT * FROM t WHERE $__timeFilter(ts)", From: 1700000000000, To: 1700003600000},
+			timeRange: backend.TimeRange{
+				From: time.UnixMilli(1600000000000),
+				To:   time.UnixMilli(1600003600000),
+			},
+			expectedRange: "ts >= 1600000000000 AND ts <= 1600003600000",
+		},
+		{
+			name:          "keeps the zero range without either",
+			query:         QueryModel{RawSQL: "SELECT * FROM t WHERE ts >= $__timeFrom"},
+			expectedRange: "ts >= " + strconv.FormatInt(time.Time{}.UnixMilli(), 10),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+			query := newDataQuery(t, "A", tt.query)
+			query.TimeRange = tt.timeRange
+			resp := ds.executeQuery(context.Background(), query)
+
+			require.NoError(t, resp.Error)
+			assert.Contains(t, resp.Frames[0].Meta.ExecutedQueryString, tt.expectedRange)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_SplitColumns(t *testing.T) {
+	resp := runGoldenQuery(t, "split_columns", QueryModel{
+		RawSQL:       "SELECT COUNT(*), AVG(delay), MAX(distance) FROM airlineStats",
+		SplitColumns: true,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["count(*)","avg(delay)","max(distance)"],"columnDataTypes":["LONG","DOUBLE","DOUBLE"]},"rows":[[1000,12.5,4983]]}}`)
+
+	require.Len(t, resp.Frames, 3)
+	for idx, name := range []string{"count(*)", "avg(delay)", "max(distance)"} {
+		frame := resp.Frames[idx]
+		assert.Equal(t, name, frame.Name)
+		assert.Equal(t, "A", frame.RefID)
+		require.Len(t, frame.Fields, 1)
+		assert.Equal(t, name, frame.Fields[0].Config.DisplayNameFromDS)
+		assert.Equal(t, "SELECT COUNT(*), AVG(delay), MAX(distance) FROM airlineStats", frame.Meta.ExecutedQueryString)
+	}
+	assert.Equal(t, int64(1000), *resp.Frames[0].Fields[0].At(0).(*int64))
+}
+
+func TestDataSource_executeQuery_TimeSeriesWide(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_wide", QueryModel{
+		RawSQL:     "SELECT ts, metricA, metricB FROM metrics",
+		Format:     FormatTimeSeries,
+		TimeColumn: "ts",
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","metricA","metricB"],"columnDataTypes":["LONG","DOUBLE","LONG"]},"rows":[[1700000120000,3.5,30],[1700000000000,1.5,10],[1700000060000,2.5,20]]}}`)
+
+	require.Len(t, resp.Frames, 1)
+	frame := resp.Frames[0]
+	assert.Equal(t, data.FrameTypeTimeSeriesWide, frame.Meta.Type)
+	require.Len(t, frame.Fields, 3)
+	assert.Equal(t, data.FieldTypeNullableTime, frame.Fields[0].Type())
+	assert.Equal(t, int64(1700000000000), frame.Fields[0].At(0).(*time.Time).UnixMilli())
+	assert.Equal(t, 1.5, *frame.Fields[1].At(0).(*float64))
+	assert.Equal(t, int64(30), *frame.Fields[2].At(2).(*int64))
+}
+
+func TestDataSource_executeQuery_TimeSeriesMatrix(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_matrix", QueryModel{
+		RawSQL:        "SELECT ts, p50, p95, p99 FROM latencies",
+		Format:        FormatTimeSeries,
+		TimeColumn:    "ts",
+		ColumnAliases: map[string]string{"p99": "Worst 1%"},
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","p50","p95","p99"],"columnDataTypes":["TIMESTAMP","DOUBLE","DOUBLE","DOUBLE"]},"rows":[[1700000000000,12.5,40.1,95.3],[1700000060000,11.2,38.7,120.4]]}}`)
+
+	require.Len(t, resp.Frames, 1)
+	frame := resp.Frames[0]
+	require.Len(t, frame.Fields, 4)
+	assert.Empty(t, frame.Fields[0].Config.DisplayNameFromDS)
+	assert.Equal(t, "p50", frame.Fields[1].Config.DisplayNameFromDS)
+	assert.Equal(t, "p95", frame.Fields[2].Config.DisplayNameFromDS)
+	assert.Equal(t, "Worst 1%", frame.Fields[3].Config.DisplayName)
+	assert.Empty(t, frame.Fields[3].Config.DisplayNameFromDS)
+}
+
+func TestDataSource_executeQuery_TimeSeriesKeepRawTime(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_raw_time", QueryModel{
+		RawSQL:      "SELECT value, ts FROM metrics",
+		Format:      FormatTimeSeries,
+		TimeColumn:  "ts",
+		KeepRawTime: true,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["value","ts"],"columnDataTypes":["DOUBLE","LONG"]},"rows":[[2.5,1700000060000],[1.5,1700000000000]]}}`)
+
+	require.Len(t, resp.Frames, 1)
+	fields := resp.Frames[0].Fields
+	require.Len(t, fields, 3)
+	assert.Equal(t, "ts", fields[0].Name)
+	assert.Equal(t, data.FieldTypeNullableTime, fields[0].Type())
+	assert.Equal(t, "value", fields[1].Name)
+	assert.Equal(t, "ts_raw", fields[2].Name)
+	assert.Equal(t, data.FieldTypeNullableInt64, fields[2].Type())
+	assert.Equal(t, int64(1700000000000), *fields[2].At(0).(*int64))
+	assert.Equal(t, fields[0].At(0).(*time.Time).UnixMilli(), *fields[2].At(0).(*int64))
+	assert.Equal(t, map[string]interface{}{"viz": true, "legend": true, "tooltip": false}, fields[2].Config.Custom["hideFrom"])
+	assert.Equal(t, "LONG", fields[2].Config.Custom["pinotType"])
+	assert.NotContains(t, fields[0].Config.Custom, "hideFrom")
+}
+
+func TestDataSource_executeQuery_TimeSeriesLegendColumn(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_legend", QueryModel{
+		RawSQL:       "SELECT ts, host, cpu FROM metrics WHERE host = 'web-1'",
+		Format:       FormatTimeSeries,
+		TimeColumn:   "ts",
+		LegendColumn: "host",
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","host","cpu"],"columnDataTypes":["LONG","STRING","DOUBLE"]},"rows":[[1700000060000,"web-1",0.5],[1700000000000,"web-1",0.25]]}}`)
+
+	require.Len(t, resp.Frames, 1)
+	fields := resp.Frames[0].Fields
+	require.Len(t, fields, 3)
+	assert.Equal(t, data.Labels{"host": "web-1"}, fields[2].Labels)
+	assert.Equal(t, "web-1", fields[2].Config.DisplayNameFromDS)
+	assert.Nil(t, fields[1].Labels)
+	// The host column stays a string field, so the frame is a long one
+	assert.Equal(t, data.FrameTypeTimeSeriesLong, resp.Frames[0].Meta.Type)
+}
+
+func TestDataSource_executeQuery_TimeSeriesAutoLabels(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_auto_labels", QueryModel{
+		RawSQL:     "SELECT ts, host, cpu FROM metrics",
+		Format:     FormatTimeSeries,
+		TimeColumn: "ts",
+		AutoLabels: true,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","host","cpu"],"columnDataTypes":["LONG","STRING","DOUBLE"]},"rows":[[1700000060000,"web-2",0.75],[1700000000000,"web-1",0.25],[1700000000000,"web-2",0.5],[1700000060000,"web-1",0.3]]}}`)
+
+	require.Len(t, resp.Frames, 1)
+	fields := resp.Frames[0].Fields
+	require.Len(t, fields, 3)
+	assert.Equal(t, "ts", fields[0].Name)
+	assert.Equal(t, 2, fields[0].Len())
+	assert.Equal(t, data.Labels{"host": "web-1"}, fields[1].Labels)
+	assert.Equal(t, data.Labels{"host": "web-2"}, fields[2].Labels)
+	assert.Equal(t, 0.3, *fields[1].At(1).(*float64))
+	assert.Equal(t, "A", resp.Frames[0].RefID)
+}
+
+func TestDataSource_executeQuery_Stats(t *testing.T) {
+	resp := runGoldenQuery(t, "stats", QueryModel{
+		RawSQL: "SELECT COUNT(*) FROM events",
+		Format: FormatStats,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["count(*)"],"columnDataTypes":["LONG"]},"rows":[[42]]},"timeUsedMs":17,"numDocsScanned":42,"totalDocs":1000,"numSegmentsQueried":3}`)
+
+	require.Len(t, resp.Frames, 1)
+	fields := resp.Frames[0].Fields
+	require.Len(t, fields, 4)
+	assert.Equal(t, "timeUsedMs", fields[0].Name)
+	assert.Equal(t, int64(17), fields[0].At(0))
+	assert.Equal(t, "ms", fields[0].Config.Unit)
+	assert.Equal(t, int64(3), fields[3].At(0))
+}
+
+func TestDataSource_executeQuery_TimeSeriesReduceLast(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_reduce_last", QueryModel{
+		RawSQL:     "SELECT ts, host, cpu FROM metrics",
+		Format:     FormatTimeSeries,
+		TimeColumn: "ts",
+		AutoLabels: true,
+		Reduce:     ReduceLast,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","host","cpu"],"columnDataTypes":["LONG","STRING","DOUBLE"]},"rows":[[1700000060000,"web-2",0.75],[1700000000000,"web-1",0.25],[1700000000000,"web-2",0.5],[1700000120000,"web-1",0.3]]}}`)
+
+	// One frame per series, each with the time of its own last point
+	require.Len(t, resp.Frames, 2)
+	for _, frame := range resp.Frames {
+		require.Len(t, frame.Fields, 2)
+		assert.Equal(t, 1, frame.Rows())
+	}
+	web1, web2 := resp.Frames[0].Fields, resp.Frames[1].Fields
+	assert.Equal(t, time.UnixMilli(1700000120000).UTC(), web1[0].At(0).(time.Time).UTC())
+	assert.Equal(t, data.Labels{"host": "web-1"}, web1[1].Labels)
+	assert.Equal(t, 0.3, *web1[1].At(0).(*float64))
+	// web-2 has no point at the latest time, so its last value keeps its own time
+	assert.Equal(t, time.UnixMilli(1700000060000).UTC(), web2[0].At(0).(time.Time).UTC())
+	assert.Equal(t, data.Labels{"host": "web-2"}, web2[1].Labels)
+	assert.Equal(t, 0.75, *web2[1].At(0).(*float64))
+}
+
+func TestDataSource_executeQuery_TimeSeriesReduceLastTrailingNulls(t *testing.T) {
+	resp := runGoldenQuery(t, "timeseries_reduce_last_nulls", QueryModel{
+		RawSQL:     "SELECT ts, cpu, mem, disk FROM metrics",
+		Format:     FormatTimeSeries,
+		TimeColumn: "ts",
+		Reduce:     ReduceLast,
+	}, `{"resultTable":{"dataSchema":{"columnNames":["ts","cpu","mem","disk"],"columnDataTypes":["LONG","DOUBLE","DOUBLE","DOUBLE"]},"rows":[[1700000000000,0.25,512,null],[1700000060000,0.5,null,null],[1700000120000,0.75,null,null]]}}`)
+
+	require.Len(t, resp.Frames, 3)
+	expected := []struct {
+		name  string
+		time  int64
+		value float64
+	}{
+		{"cpu", 1700000120000, 0.75},
+		{"mem", 1700000000000, 512},
+	}
+	for idx, tt := range expected {
+		fields := resp.Frames[idx].Fields
+		require.Len(t, fields, 2)
+		assert.Equal(t, tt.name, fields[1].Name)
+		assert.Equal(t, tt.time, fields[0].At(0).(*time.Time).UnixMilli())
+		assert.Equal(t, tt.value, *fields[1].At(0).(*float64))
+	}
+	// A series without values is kept without rows
+	assert.Equal(t, "disk", resp.Frames[2].Fields[1].Name)
+	assert.Equal(t, 0, resp.Frames[2].Rows())
+}
+
+func TestDataSource_executeQuery_TimeSeriesAutoLabelsSkipped(t *testing.T) {
+	tests := []struct {
+		name      string
+		columns   string
+		types     string
+		row       string
+		expected  int
+		frameType data.FrameType
+	}{
+		{"without string column", `["ts","cpu"]`, `["LONG","DOUBLE"]`, `[1700000000000,0.5]`, 2, data.FrameTypeTimeSeriesWide},
+		{"with several string columns", `["ts","host","region","cpu"]`, `["LONG","STRING","STRING","DOUBLE"]`, `[1700000000000,"web-1","eu",0.5]`, 4, data.FrameTypeTimeSeriesLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":`+tt.columns+`,"columnDataTypes":`+tt.types+`},"rows":[`+tt.row+`]}}`))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT * FROM metrics", Format: FormatTimeSeries, TimeColumn: "ts", AutoLabels: true}))
+
+			require.NoError(t, resp.Error)
+			require.Len(t, resp.Frames, 1)
+			assert.Len(t, resp.Frames[0].Fields, tt.expected)
+			assert.Equal(t, tt.frameType, resp.Frames[0].Meta.Type)
+			for _, field := range resp.Frames[0].Fields {
+				assert.Nil(t, field.Labels)
+			}
+		})
+	}
+}
+
+func TestDataSource_executeQuery_LegendColumnNotFound(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["value"],"columnDataTypes":["DOUBLE"]},"rows":[[1.5]]}}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT value FROM metrics", LegendColumn: "host"}))
+
+	require.Error(t, resp.Error)
+	assert.Contains(t, resp.Error.Error(), `legend column "host" not found`)
+}
+
+func TestDataSource_executeQuery_TimeSeriesMissingTimeColumn(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+	}{
+		{name: "falls back to a table with a warning", strict: false},
+		{name: "fails in strict mode", strict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.StrictTimeSeries = tt.strict
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["host","value"],"columnDataTypes":["STRING","DOUBLE"]},"rows":[["a",1.5],["b",2.5]]}}`))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT host, value FROM metrics", Format: FormatTimeSeries}))
+
+			if tt.strict {
+				require.Error(t, resp.Error)
+				assert.Contains(t, resp.Error.Error(), "time column not found")
+				return
+			}
+			require.NoError(t, resp.Error)
+			require.Len(t, resp.Frames, 1)
+
+			frame := resp.Frames[0]
+			require.Len(t, frame.Fields, 2)
+			assert.Equal(t, "host", frame.Fields[0].Name)
+			assert.Equal(t, 2, frame.Rows())
+			assert.Equal(t, 2.5, *frame.Fields[1].At(1).(*float64))
+
+			require.Len(t, frame.Meta.Notices, 1)
+			assert.Equal(t, data.NoticeSeverityWarning, frame.Meta.Notices[0].Severity)
+			assert.Contains(t, frame.Meta.Notices[0].Text, "returned as a table")
+		})
+	}
+}
+
+func TestDataSource_executeQuery_AutoTimeSeries(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.AutoTimeSeries = true
+	ds.config.DefaultTimeColumn = "ts"
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["ts","value"],"columnDataTypes":["TIMESTAMP","DOUBLE"]},"rows":[[1700000000000,1.5]]}}`))
+
+	t.Run("injects the default time column", func(t *testing.T) {
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT value FROM metrics", Format: FormatTimeSeries}))
+
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		assert.Equal(t, "SELECT ts, value FROM metrics ORDER BY ts", resp.Frames[0].Meta.ExecutedQueryString)
+	})
+
+	t.Run("query time column takes precedence", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+			httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["eventTime","value"],"columnDataTypes":["TIMESTAMP","DOUBLE"]},"rows":[[1700000000000,1.5]]}}`))
+
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT value FROM metrics", Format: FormatTimeSeries, TimeColumn: "eventTime"}))
+
+		require.NoError(t, resp.Error)
+		assert.Equal(t, "SELECT eventTime, value FROM metrics ORDER BY eventTime", resp.Frames[0].Meta.ExecutedQueryString)
+	})
+
+	t.Run("table queries are not rewritten", func(t *testing.T) {
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT value FROM metrics", Format: FormatTable}))
+
+		require.NoError(t, resp.Error)
+		assert.Equal(t, "SELECT value FROM metrics", resp.Frames[0].Meta.ExecutedQueryString)
+	})
+}
+
+func TestDataSource_executeQuery_OptionsComment(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	var payload map[string]string
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+		return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`), nil
+	})
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{
+		RawSQL:       "-- options: {\"useMultiStageEngine\":true,\"timeoutMs\":5000}\nSELECT a FROM t",
+		QueryOptions: map[string]interface{}{"timeoutMs": 1000, "maxRowsInJoin": 10},
+	}))
+
+	require.NoError(t, resp.Error)
+	assert.Equal(t, "SELECT a FROM t", payload["sql"])
+	assert.Equal(t, "maxRowsInJoin=10;timeoutMs=5000;useMultiStageEngine=true", payload["queryOptions"])
+	assert.Equal(t, "SELECT a FROM t", resp.Frames[0].Meta.ExecutedQueryString)
+}
+
+func TestDataSource_executeQuery_EnableNullHandling(t *testing.T) {
+	tests := []struct {
+		name            string
+		datasource      bool
+		query           QueryModel
+		expectedOptions string
+	}{
+		{
+			name:            "not sent by default",
+			query:           QueryModel{RawSQL: "SELECT a FROM t"},
+			expectedOptions: "",
+		},
+		{
+			name:            "enabled for the datasource",
+			datasource:      true,
+			query:           QueryModel{RawSQL: "SELECT a FROM t"},
+			expectedOptions: "enableNullHandling=true",
+		},
+		{
+			name:            "enabled for the query",
+			query:           QueryModel{RawSQL: "SELECT a FROM t", EnableNullHandling: true, QueryOptions: map[string]interface{}{"timeoutMs": 500}},
+			expectedOptions: "enableNullHandling=true;timeoutMs=500",
+		},
+		{
+			name:            "explicit option wins",
+			datasource:      true,
+			query:           QueryModel{RawSQL: "-- options: {\"enableNullHandling\": false}\nSELECT a FROM t"},
+			expectedOptions: "enableNullHandling=false",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.EnableNullHandling = tt.datasource
+			var payload map[string]string
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+				return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[null]]}}`), nil
+			})
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expectedOptions, payload["queryOptions"])
+		})
+	}
+}
+
+func TestDataSource_BrokerTenant(t *testing.T) {
+	tests := []struct {
+		name            string
+		tenant          string
+		query           QueryModel
+		expectedOptions string
+	}{
+		{
+			name:            "not sent by default",
+			query:           QueryModel{RawSQL: "SELECT a FROM t"},
+			expectedOptions: "",
+		},
+		{
+			name:            "sent with every query",
+			tenant:          "tenantA",
+			query:           QueryModel{RawSQL: "SELECT a FROM t", QueryOptions: map[string]interface{}{"timeoutMs": 500}},
+			expectedOptions: "brokerTenant=tenantA;timeoutMs=500",
+		},
+		{
+			name:            "explicit option wins",
+			tenant:          "tenantA",
+			query:           QueryModel{RawSQL: "-- options: {\"brokerTenant\": \"tenantB\"}\nSELECT a FROM t"},
+			expectedOptions: "brokerTenant=tenantB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.BrokerTenant = tt.tenant
+			var payload map[string]string
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+				require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+				return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`), nil
+			})
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expectedOptions, payload["queryOptions"])
+		})
+	}
+
+	t.Run("sent with resource queries", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+
+		ds := newMockedDataSource(t)
+		ds.config.BrokerTenant = "tenantA"
+		var payload map[string]string
+		httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql", func(req *http.Request) (*http.Response, error) {
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+			return httpmock.NewStringResponse(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`), nil
+		})
+
+		resp := callResource(t, ds, "POST", "/query", []byte(`{"sql":"SELECT a FROM t"}`))
+
+		require.Equal(t, http.StatusOK, resp.Status)
+		assert.Equal(t, "brokerTenant=tenantA", payload["queryOptions"])
+	})
+}
+
+func TestParseOptionsComment(t *testing.T) {
+	tests := []struct {
+		name            string
+		sql             string
+		expectedSQL     string
+		expectedOptions map[string]interface{}
+		expectError     bool
+	}{
+		{
+			name:        "no comment",
+			sql:         "SELECT 1",
+			expectedSQL: "SELECT 1",
+		},
+		{
+			name:            "options comment",
+			sql:             "-- options: {\"useMultiStageEngine\":true, \"timeoutMs\": 5000}\nSELECT 1",
+			expectedSQL:     "SELECT 1",
+			expectedOptions: map[string]interface{}{"useMultiStageEngine": true, "timeoutMs": json.Number("5000")},
+		},
+		{
+			name:            "case insensitive prefix",
+			sql:             "-- OPTIONS: {\"trace\":\"true\"}\r\nSELECT 1",
+			expectedSQL:     "SELECT 1",
+			expectedOptions: map[string]interface{}{"trace": "true"},
+		},
+		{
+			name:        "other leading comment is kept",
+			sql:         "-- daily flights\nSELECT 1",
+			expectedSQL: "-- daily flights\nSELECT 1",
+		},
+		{
+			name:        "invalid JSON",
+			sql:         "-- options: {useMultiStageEngine}\nSELECT 1",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, options, err := parseOptionsComment(tt.sql)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "invalid options comment")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedSQL, sql)
+			assert.Equal(t, tt.expectedOptions, options)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_PinotErrorCode(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"exceptions":[{"errorCode":190,"message":"TableDoesNotExistError"},{"errorCode":200,"message":"other"}]}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT * FROM missing"}))
+
+	require.Error(t, resp.Error)
+	assert.Equal(t, "Pinot query error (code 190): TableDoesNotExistError", resp.Error.Error())
+	assert.Equal(t, backend.ErrorSourceDownstream, resp.ErrorSource)
+
+	require.Len(t, resp.Frames, 1)
+	custom, ok := resp.Frames[0].Meta.Custom.(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, 190, custom["errorCode"])
+	assert.Equal(t, "SELECT * FROM missing", resp.Frames[0].Meta.ExecutedQueryString)
+}
+
+func TestDataSource_executeQuery_DefaultLimit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.DefaultLimit = 500
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name     string
+		noLimit  bool
+		expected string
+	}{
+		{"appends the datasource default", false, "SELECT a FROM t LIMIT 500"},
+		{"noLimit skips the default", true, "SELECT a FROM t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t", NoLimit: tt.noLimit}))
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_DefaultLimitFromCluster(t *testing.T) {
+	response := `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`
+
+	tests := []struct {
+		name           string
+		config         DataSourceConfig
+		clusterConfigs map[string]interface{}
+		clusterErr     error
+		expected       string
+		clusterCalls   int
+	}{
+		{
+			name:           "appends the cluster default",
+			config:         DataSourceConfig{DefaultLimitFromCluster: true},
+			clusterConfigs: map[string]interface{}{ClusterDefaultLimitKey: "250", "allowParticipantTagAssignment": "true"},
+			expected:       "SELECT a FROM t LIMIT 250",
+			clusterCalls:   1,
+		},
+		{
+			name:           "prefers the datasource default",
+			config:         DataSourceConfig{DefaultLimitFromCluster: true, DefaultLimit: 500},
+			clusterConfigs: map[string]interface{}{ClusterDefaultLimitKey: "250"},
+			expected:       "SELECT a FROM t LIMIT 500",
+		},
+		{
+			name:           "ignores the cluster default unless enabled",
+			clusterConfigs: map[string]interface{}{ClusterDefaultLimitKey: "250"},
+			expected:       "SELECT a FROM t",
+		},
+		{
+			name:           "appends no limit without a cluster default",
+			config:         DataSourceConfig{DefaultLimitFromCluster: true},
+			clusterConfigs: map[string]interface{}{ClusterDefaultLimitKey: "-1"},
+			expected:       "SELECT a FROM t",
+			clusterCalls:   1,
+		},
+		{
+			name:         "appends no limit when the cluster config fails",
+			config:       DataSourceConfig{DefaultLimitFromCluster: true},
+			clusterErr:   ErrControllerNotConfigured,
+			expected:     "SELECT a FROM t",
+			clusterCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: response, clusterConfigs: tt.clusterConfigs, clusterConfigsErr: tt.clusterErr}
+			ds := &DataSource{client: client, config: tt.config}
+
+			// The second query reuses the cached cluster default
+			for i := 0; i < 2; i++ {
+				resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+				require.NoError(t, resp.Error)
+				assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+			}
+			assert.Equal(t, tt.clusterCalls, client.clusterConfigCalls)
+		})
+	}
+}
+
+// blockingClusterAPI holds the first ClusterConfigs call until released
+type blockingClusterAPI struct {
+	*fakePinotAPI
+	mu      sync.Mutex
+	calls   int
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingClusterAPI) ClusterConfigs(ctx context.Context) (map[string]interface{}, error) {
+	b.mu.Lock()
+	b.calls++
+	first := b.calls == 1
+	b.mu.Unlock()
+	if first {
+		close(b.started)
+		<-b.release
+	}
+	return map[string]interface{}{ClusterDefaultLimitKey: "250"}, nil
+}
+
+func TestDataSource_defaultLimit_ConcurrentFetch(t *testing.T) {
+	client := &blockingClusterAPI{fakePinotAPI: &fakePinotAPI{}, started: make(chan struct{}), release: make(chan struct{})}
+	ds := &DataSource{client: client, config: DataSourceConfig{DefaultLimitFromCluster: true}}
+
+	blocked := make(chan int)
+	go func() { blocked <- ds.defaultLimit(context.Background()) }()
+	<-client.started
+
+	// A slow controller call does not hold the other queries back
+	done := make(chan int)
+	go func() { done <- ds.defaultLimit(context.Background()) }()
+	select {
+	case limit := <-done:
+		assert.Equal(t, 250, limit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("defaultLimit waited for the pending cluster config call")
+	}
+
+	close(client.release)
+	assert.Equal(t, 250, <-blocked)
+	assert.Equal(t, 250, ds.defaultLimit(context.Background()))
+	assert.Equal(t, 2, client.calls)
+}
+
+func TestDataSource_executeQuery_LimitToMaxDataPoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   DataSourceConfig
+		query    QueryModel
+		expected string
+	}{
+		{
+			name:     "limits table queries to the max data points",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true},
+			query:    QueryModel{RawSQL: "SELECT a FROM t"},
+			expected: "SELECT a FROM t LIMIT 300",
+		},
+		{
+			name:     "keeps a smaller default limit",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true, DefaultLimit: 100},
+			query:    QueryModel{RawSQL: "SELECT a FROM t", Format: FormatTable},
+			expected: "SELECT a FROM t LIMIT 100",
+		},
+		{
+			name:     "replaces a larger default limit",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true, DefaultLimit: 1000},
+			query:    QueryModel{RawSQL: "SELECT a FROM t", Format: FormatTable},
+			expected: "SELECT a FROM t LIMIT 300",
+		},
+		{
+			name:     "keeps an explicit limit",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true},
+			query:    QueryModel{RawSQL: "SELECT a FROM t LIMIT 5000"},
+			expected: "SELECT a FROM t LIMIT 5000",
+		},
+		{
+			name:     "leaves timeseries queries to the interval",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true},
+			query:    QueryModel{RawSQL: "SELECT a FROM t", Format: FormatTimeSeries},
+			expected: "SELECT a FROM t",
+		},
+		{
+			name:     "is disabled by default",
+			query:    QueryModel{RawSQL: "SELECT a FROM t"},
+			expected: "SELECT a FROM t",
+		},
+		{
+			name:     "noLimit skips it",
+			config:   DataSourceConfig{LimitToMaxDataPoints: true},
+			query:    QueryModel{RawSQL: "SELECT a FROM t", NoLimit: true},
+			expected: "SELECT a FROM t",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: `{"resultTable":{"dataSchema":{"columnNames":["ts","a"],"columnDataTypes":["TIMESTAMP","INT"]},"rows":[[1700000000000,1]]}}`}
+			ds := &DataSource{client: client, config: tt.config}
+
+			query := newDataQuery(t, "A", tt.query)
+			query.MaxDataPoints = 300
+			resp := ds.executeQuery(context.Background(), query)
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, []string{tt.expected}, client.queries)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_MandatoryFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected string
+		errorMsg string
+	}{
+		{
+			name:     "filters a query without WHERE",
+			sql:      "SELECT a FROM events",
+			expected: "SELECT a FROM events WHERE (account_id = 42)",
+		},
+		{
+			name:     "filters a query with WHERE",
+			sql:      "SELECT a FROM events WHERE a > 1 OR a < -1",
+			expected: "SELECT a FROM events WHERE (account_id = 42) AND (a > 1 OR a < -1)",
+		},
+		{
+			name:     "rejects a query it cannot filter",
+			sql:      "SELECT a FROM events JOIN other ON events.id = other.id",
+			errorMsg: "the mandatory filter of the datasource cannot be applied to a JOIN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakePinotAPI{queryResponse: `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`}
+			ds := &DataSource{client: client, config: DataSourceConfig{MandatoryFilter: "account_id = 42"}}
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: tt.sql}))
+
+			if tt.errorMsg != "" {
+				require.Error(t, resp.Error)
+				assert.Equal(t, backend.StatusBadRequest, resp.Status)
+				assert.Contains(t, resp.Error.Error(), tt.errorMsg)
+				assert.Empty(t, client.queries)
+				return
+			}
+			require.NoError(t, resp.Error)
+			assert.Equal(t, []string{tt.expected}, client.queries)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+
+	t.Run("reports the filtered SQL of Pinot errors", func(t *testing.T) {
+		client := &fakePinotAPI{queryResponse: `{"exceptions":[{"errorCode":710,"message":"Unknown column a"}]}`}
+		ds := &DataSource{client: client, config: DataSourceConfig{MandatoryFilter: "account_id = 42"}}
+
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM events"}))
+
+		require.Error(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		assert.Equal(t, "SELECT a FROM events WHERE (account_id = 42)", resp.Frames[0].Meta.ExecutedQueryString)
+	})
+
+	t.Run("counts the filter in the SQL length", func(t *testing.T) {
+		client := &fakePinotAPI{queryResponse: `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`}
+		sql := "SELECT a FROM events"
+		ds := &DataSource{client: client, config: DataSourceConfig{MandatoryFilter: "account_id = 42", MaxSQLLength: len(sql) + 10}}
+
+		resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: sql}))
+
+		require.Error(t, resp.Error)
+		assert.Equal(t, backend.StatusBadRequest, resp.Status)
+		assert.Contains(t, resp.Error.Error(), fmt.Sprintf("query is %d bytes long", len("SELECT a FROM events WHERE (account_id = 42)")))
+		assert.Empty(t, client.queries)
+	})
+
+	t.Run("filters resource queries", func(t *testing.T) {
+		client := &fakePinotAPI{queryResponse: `{"resultTable":{"dataSchema":{"columnNames":["carrier"],"columnDataTypes":["STRING"]},"rows":[["AA"]]}}`}
+		ds := &DataSource{client: client, config: DataSourceConfig{MandatoryFilter: "account_id = 42"}}
+
+		resp := callResource(t, ds, "GET", "table/events/values?key=carrier", nil)
+
+		assert.Equal(t, http.StatusOK, resp.Status)
+		assert.Equal(t, []string{`SELECT DISTINCT "carrier" FROM "events" WHERE (account_id = 42) LIMIT 1000`}, client.queries)
+	})
+}
+
+func TestDataSource_executeQuery_Pagination(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.DefaultLimit = 500
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name     string
+		query    QueryModel
+		expected string
+		errorMsg string
+	}{
+		{"appends the page", QueryModel{RawSQL: "SELECT a FROM t", Offset: 40, Limit: 20}, "SELECT a FROM t LIMIT 40, 20", ""},
+		{"expands the page macros", QueryModel{RawSQL: "SELECT a FROM t LIMIT $__offset, $__limit", Offset: 40, Limit: 20}, "SELECT a FROM t LIMIT 40, 20", ""},
+		{"falls back to the default limit", QueryModel{RawSQL: "SELECT a FROM t", Offset: 40}, "SELECT a FROM t LIMIT 500", ""},
+		{"rejects a negative offset", QueryModel{RawSQL: "SELECT a FROM t", Offset: -1, Limit: 20}, "", "offset and limit must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			if tt.errorMsg != "" {
+				require.Error(t, resp.Error)
+				assert.Contains(t, resp.Error.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_Sort(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.DefaultLimit = 500
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name     string
+		query    QueryModel
+		expected string
+		errorMsg string
+	}{
+		{"sorts ascending", QueryModel{RawSQL: "SELECT carrier, flights FROM t", SortColumn: "flights"}, "SELECT carrier, flights FROM t ORDER BY flights LIMIT 500", ""},
+		{"sorts descending before the page", QueryModel{RawSQL: "SELECT carrier, flights FROM t", SortColumn: "flights", SortDesc: true, Offset: 20, Limit: 10}, "SELECT carrier, flights FROM t ORDER BY flights DESC LIMIT 20, 10", ""},
+		{"rejects an unselected column", QueryModel{RawSQL: "SELECT carrier FROM t", SortColumn: "flights"}, "", `sort column "flights" is not selected by the query`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			if tt.errorMsg != "" {
+				require.Error(t, resp.Error)
+				assert.Contains(t, resp.Error.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_DefaultDatabase(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.DefaultDatabase = "analytics"
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name     string
+		query    QueryModel
+		expected string
+	}{
+		{"qualifies an unqualified table", QueryModel{RawSQL: "SELECT a FROM events"}, "SELECT a FROM analytics.events"},
+		{"keeps a qualified table", QueryModel{RawSQL: "SELECT a FROM other.events"}, "SELECT a FROM other.events"},
+		{"expands the table macro", QueryModel{RawSQL: "SELECT a FROM $__table(events)"}, "SELECT a FROM analytics.events"},
+		{"qualifies the typed table", QueryModel{RawSQL: "SELECT a FROM events", TableType: TableTypeOffline}, "SELECT a FROM analytics.events_OFFLINE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_MaxSQLLength(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	values := make([]string, 500)
+	for i := range values {
+		values[i] = fmt.Sprintf("'host-%03d'", i)
+	}
+	oversized := "SELECT a FROM t WHERE host IN (" + strings.Join(values, ", ") + ")"
+
+	ds := newMockedDataSource(t)
+	ds.config.MaxSQLLength = 1024
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: oversized}))
+	require.Error(t, resp.Error)
+	assert.Equal(t, backend.StatusBadRequest, resp.Status)
+	assert.Contains(t, resp.Error.Error(), fmt.Sprintf("query is %d bytes long, over the 1024 bytes allowed by the datasource", len(oversized)))
+	assert.Contains(t, resp.Error.Error(), "narrow the filter")
+	assert.Zero(t, httpmock.GetTotalCallCount())
+
+	resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t WHERE host IN ('host-001')"}))
+	require.NoError(t, resp.Error)
+
+	ds.config.MaxSQLLength = 0
+	resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: oversized}))
+	require.NoError(t, resp.Error)
+}
+
+func TestDataSource_executeQuery_Comments(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name         string
+		query        QueryModel
+		keepComments bool
+		expected     string
+	}{
+		{"strips a trailing line comment", QueryModel{RawSQL: "SELECT a FROM t -- WHERE $__timeFilter()"}, false, "SELECT a FROM t"},
+		{"strips a leading line comment", QueryModel{RawSQL: "-- daily flights\nSELECT a FROM t"}, false, "SELECT a FROM t"},
+		{"strips a block comment", QueryModel{RawSQL: "SELECT a /* , $__timeGroup() */ FROM t"}, false, "SELECT a   FROM t"},
+		{"keeps comment-like literals", QueryModel{RawSQL: "SELECT a FROM t WHERE b = '-- not /* a */ comment'"}, false, "SELECT a FROM t WHERE b = '-- not /* a */ comment'"},
+		{"ignores tables in comments", QueryModel{RawSQL: "SELECT a FROM t /* FROM other */", TableType: TableTypeOffline}, false, "SELECT a FROM t_OFFLINE"},
+		{"keeps comments when configured", QueryModel{RawSQL: "SELECT a FROM t -- note"}, true, "SELECT a FROM t -- note"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newMockedDataSource(t)
+			ds.config.KeepComments = tt.keepComments
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", tt.query))
+
+			require.NoError(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Frames[0].Meta.ExecutedQueryString)
+		})
+	}
+}
+
+func TestDataSource_executeQuery_TimeGroupTimezone(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	ds.config.Timezone = "Asia/Kolkata"
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	tests := []struct {
+		name     string
+		timezone string
+		expected string
+		errorMsg string
+	}{
+		{"uses the query timezone", "Europe/Berlin", "'Europe/Berlin'", ""},
+		{"uses the datasource timezone for browser time", "browser", "'Asia/Kolkata'", ""},
+		{"uses the datasource timezone without a query timezone", "", "'Asia/Kolkata'", ""},
+		{"normalizes utc", "utc", "'UTC'", ""},
+		{"rejects unknown timezones", "Mars/Olympus", "", "invalid timezone \"Mars/Olympus\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{
+				RawSQL:   "SELECT $__timeGroup(ts, '1d'), COUNT(*) FROM t GROUP BY 1",
+				Timezone: tt.timezone,
+			}))
+
+			if tt.errorMsg != "" {
+				require.Error(t, resp.Error)
+				assert.Contains(t, resp.Error.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, resp.Error)
+			assert.Contains(t, resp.Frames[0].Meta.ExecutedQueryString, "DATETRUNC('DAY', ts, 'MILLISECONDS', "+tt.expected)
+		})
+	}
+}
+
+func TestInterpretQueryResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		expectedCode int
+		expectedMsg  string
+	}{
+		{
+			name:         "exceptions in a 200 response",
+			status:       200,
+			body:         `{"exceptions":[{"errorCode":150,"message":"SQLParsingError"}]}`,
+			expectedCode: 150,
+			expectedMsg:  "Pinot query error (code 150): SQLParsingError",
+		},
+		{
+			name:         "exceptions with an error status",
+			status:       400,
+			body:         `{"exceptions":[{"errorCode":150,"message":"SQLParsingError"}]}`,
+			expectedCode: 150,
+			expectedMsg:  "Pinot query error (code 150): SQLParsingError",
+		},
+		{
+			name:        "error status without exceptions",
+			status:      500,
+			body:        `Internal Server Error`,
+			expectedMsg: "query failed with status 500: Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewStringResponder(tt.status, tt.body))
+
+			pinotResp, err := interpretQueryResponse(ds.client.Query(context.Background(), "SELECT 1"))
+
+			assert.Nil(t, pinotResp)
+			require.Error(t, err)
+			assert.Equal(t, tt.expectedMsg, err.Error())
+
+			var pinotErr *PinotException
+			if tt.expectedCode == 0 {
+				var statusErr *StatusError
+				assert.False(t, errors.As(err, &pinotErr))
+				assert.True(t, errors.As(err, &statusErr))
+				return
+			}
+			require.True(t, errors.As(err, &pinotErr))
+			assert.Equal(t, tt.expectedCode, pinotErr.ErrorCode)
+
+			// Panel queries report both shapes the same way
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT 1"}))
+			require.Error(t, resp.Error)
+			assert.Equal(t, tt.expectedMsg, resp.Error.Error())
+			assert.Equal(t, tt.expectedCode, resp.Frames[0].Meta.Custom.(map[string]interface{})["errorCode"])
+		})
+	}
+}
+
+func TestInterpretQueryResponse_NullRows(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		expectTable bool
+	}{
+		{"null rows become an empty result", `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":null}}`, true},
+		{"missing rows become an empty result", `{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]}}}`, true},
+		{"a missing result table stays missing", `{"numDocsScanned":0}`, false},
+		{"null rows of several tables become empty results", `{"resultTables":[{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":null},{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":null}]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinotResp, err := interpretQueryResponse(httpmock.NewStringResponse(200, tt.body), nil)
+
+			require.NoError(t, err)
+			if !tt.expectTable {
+				assert.Nil(t, pinotResp.ResultTable)
+				return
+			}
+			require.NotNil(t, pinotResp.ResultTable)
+			assert.NotNil(t, pinotResp.ResultTable.Rows)
+			assert.Empty(t, pinotResp.ResultTable.Rows)
+			assert.Equal(t, []string{"a"}, pinotResp.ResultTable.DataSchema.ColumnNames)
+			for _, table := range pinotResp.ResultTables {
+				assert.NotNil(t, table.Rows)
+			}
+		})
+	}
+}
+
+func TestDataSource_executeQuery_ExceptionStackTrace(t *testing.T) {
+	message := "QueryExecutionError:\norg.apache.pinot.spi.exception.BadQueryRequestException: Unknown column: dealy\n" +
+		"\tat org.apache.pinot.core.query.QueryValidator.validate(QueryValidator.java:42)\n" +
+		"\tat org.apache.pinot.broker.BaseBrokerRequestHandler.handleRequest(BaseBrokerRequestHandler.java:301)\n" +
+		"Caused by: java.lang.IllegalArgumentException: dealy\n" +
+		"\t... 12 more"
+	body, err := json.Marshal(map[string]interface{}{
+		"exceptions": []map[string]interface{}{{"errorCode": 710, "message": message}},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		debug    bool
+		expected string
+	}{
+		{
+			name:     "concise message by default",
+			expected: "Pinot query error (code 710): QueryExecutionError:\norg.apache.pinot.spi.exception.BadQueryRequestException: Unknown column: dealy\nCaused by: java.lang.IllegalArgumentException: dealy",
+		},
+		{
+			name:     "full stack trace in debug mode",
+			debug:    true,
+			expected: "Pinot query error (code 710): " + message,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			ds := newMockedDataSource(t)
+			ds.config.DebugErrors = tt.debug
+			httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+				httpmock.NewBytesResponder(200, body))
+
+			resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT dealy FROM airlineStats"}))
+
+			require.Error(t, resp.Error)
+			assert.Equal(t, tt.expected, resp.Error.Error())
+		})
+	}
+}
+
+func TestDataSource_executeQuery_ResponseBOM(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	ds := newMockedDataSource(t)
+	httpmock.RegisterResponder("POST", "http://test-broker:8099/query/sql",
+		httpmock.NewStringResponder(200, "\xEF\xBB\xBF\r\n  "+`{"resultTable":{"dataSchema":{"columnNames":["a"],"columnDataTypes":["INT"]},"rows":[[1]]}}`))
+
+	resp := ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT a FROM t"}))
+
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	assert.Equal(t, int64(1), *resp.Frames[0].Fields[0].At(0).(*int64))
+}
+
+func TestDataSource_executeQuery_RecoversFromPanic(t *testing.T) {
+	// A datasource without a client panics on the nil dereference during execution
+	ds := &DataSource{}
+
+	var resp backend.DataResponse
+	require.NotPanics(t, func() {
+		resp = ds.executeQuery(context.Background(), newDataQuery(t, "A", QueryModel{RawSQL: "SELECT 1"}))
+	})
+	require.Error(t, resp.Error)
+	assert.Contains(t, resp.Error.Error(), "internal error while processing query")
+	assert.Equal(t, backend.StatusInternal, resp.Status)
+}
+
+func TestPinotResponse_JSON(t *testing.T) {
+	jsonStr := `{
+		"resultTable": {"dataSchema": {"columnNames": ["a"], "columnDataTypes": ["INT"]}, "rows": [[1]]},
+		"exceptions": [],
+		"numDocsScanned": 10,
+		"totalDocs": 100,
+		"numSegmentsQueried": 2,
+		"timeUsedMs": 5,
+		"requestId": "42",
+		"brokerId": "Broker_localhost_8099"
+	}`
+
+	var resp PinotResponse
+	err := json.Unmarshal([]byte(jsonStr), &resp)
+	require.NoError(t, err)
+	require.NotNil(t, resp.ResultTable)
+	assert.Equal(t, []string{"a"}, resp.ResultTable.DataSchema.ColumnNames)
+	assert.Equal(t, int64(10), resp.NumDocsScanned)
+	assert.Equal(t, int64(100), resp.TotalDocs)
+	assert.Equal(t, "42", resp.RequestID)
+	assert.Equal(t, "Broker_localhost_8099", resp.BrokerID)
+}
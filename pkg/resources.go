@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ============================================================================
+// TYPES - Resource Requests
+// ============================================================================
+
+// ResourceTimeRange is a time range expressed in epoch milliseconds
+type ResourceTimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// DefaultAdHocValuesLimit caps the distinct values returned for an ad-hoc filter key
+const DefaultAdHocValuesLimit = 1000
+
+// QueryResourceRequest is the body of the query resource
+type QueryResourceRequest struct {
+	SQL           string            `json:"sql"`
+	TimeRange     ResourceTimeRange `json:"timeRange"`
+	MaxDataPoints int64             `json:"maxDataPoints"` // Drives the interval macros, see computeInterval
+}
+
+// DefaultPreviewRange is the time range, ending now, of previewed queries without from/to
+const DefaultPreviewRange = time.Hour
+
+// QueryPreviewRequest is the body of the query preview resource: a query model with the panel
+// settings driving its interval macros
+type QueryPreviewRequest struct {
+	QueryModel
+	IntervalMs    int64 `json:"intervalMs"`    // Grafana interval of the panel, the minimum of the computed interval
+	MaxDataPoints int64 `json:"maxDataPoints"` // Drives the interval macros, see computeInterval
+}
+
+// QueryPreviewResponse is the body of the query preview resource
+type QueryPreviewResponse struct {
+	SQL          string                 `json:"sql"`
+	QueryOptions map[string]interface{} `json:"queryOptions,omitempty"`
+}
+
+// QueryCostResponse is the body of the query cost resource: an estimate of the work of a query
+// derived from its explain plan, without running it
+type QueryCostResponse struct {
+	SQL      string              `json:"sql"`      // Explained query, after macros
+	Columns  []string            `json:"columns"`  // Columns read by the query
+	Indexes  []string            `json:"indexes"`  // Indexes used by the filters, e.g. inverted_index
+	FullScan bool                `json:"fullScan"` // Whether a filter scans the column values of whole segments
+	Segments int64               `json:"segments"` // Segments the plan applies to, when reported
+	Plan     []QueryPlanOperator `json:"plan"`
+}
+
+// QueryPlanOperator is a row of an explain plan
+type QueryPlanOperator struct {
+	Operator string `json:"operator"`
+	ID       int64  `json:"id"`
+	ParentID int64  `json:"parentId"`
+}
+
+// RedactedValue replaces secrets in resource responses
+const RedactedValue = "[redacted]"
+
+// ConfigResponse is the body of the config resource: the datasource settings as provisioned, with
+// credentials in URLs redacted, and the secrets that are set, always with a redacted value
+type ConfigResponse struct {
+	DataSourceConfig
+	SecureFields map[string]string `json:"secureFields"`
+}
+
+// ============================================================================
+// RESOURCES - Routing
+// ============================================================================
+
+// CallResource handles resource calls from the Grafana frontend
+func (ds *DataSource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return httpadapter.New(ds.newResourceMux()).CallResource(ctx, req, sender)
+}
+
+// newResourceMux registers the resource routes of the datasource
+func (ds *DataSource) newResourceMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /query", ds.handleQuery)
+	mux.HandleFunc("POST /query/csv", ds.handleQueryCSV)
+	mux.HandleFunc("POST /query/preview", ds.handleQueryPreview)
+	mux.HandleFunc("POST /query/cost", ds.handleQueryCost)
+	mux.HandleFunc("GET /config", ds.handleConfig)
+	mux.HandleFunc("GET /cluster/configs", ds.handleClusterConfigs)
+	mux.HandleFunc("GET /instances", ds.handleInstances)
+	mux.HandleFunc("GET /table/{name}/keys", ds.handleTableKeys)
+	mux.HandleFunc("GET /table/{name}/values", ds.handleTableValues)
+	mux.HandleFunc("GET /table/{name}/size", ds.handleTableSize)
+	return mux
+}
+
+// ============================================================================
+// RESOURCES - Handlers
+// ============================================================================
+
+// handleQuery runs an arbitrary SQL query outside the QueryData flow and returns the frames as JSON
+func (ds *DataSource) handleQuery(w http.ResponseWriter, r *http.Request) {
+	frames, status, err := ds.runResourceQuery(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, frames)
+}
+
+// handleQueryCSV runs an arbitrary SQL query like handleQuery and returns the result as CSV
+// Responses holding several result tables are rejected rather than truncated to the first one
+func (ds *DataSource) handleQueryCSV(w http.ResponseWriter, r *http.Request) {
+	frames, status, err := ds.runResourceQuery(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	if len(frames) > 1 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query returned %d result tables, CSV supports a single one", len(frames)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := writeFrameCSV(w, frames[0]); err != nil {
+		// Headers are already sent, the truncated body is all that can be reported
+		backend.Logger.Warn("Failed to write CSV response", "error", err)
+	}
+}
+
+// handleQueryPreview returns the SQL a QueryModel would send to the broker, without running it
+// Time macros use the from/to range of the model (the last DefaultPreviewRange without one), and the
+// mandatory filter is applied like runQuery
+func (ds *DataSource) handleQueryPreview(w http.ResponseWriter, r *http.Request) {
+	var body QueryPreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to parse request: %w", err))
+		return
+	}
+	qm := body.QueryModel
+
+	timeRange := ResourceTimeRange{From: qm.From, To: qm.To}.toBackend()
+	if qm.From == 0 && qm.To == 0 {
+		now := time.Now().UTC()
+		timeRange = backend.TimeRange{From: now.Add(-DefaultPreviewRange), To: now}
+	}
+
+	sql, err := ds.buildSQL(r.Context(), &qm, timeRange, body.MaxDataPoints, time.Duration(body.IntervalMs)*time.Millisecond)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if sql == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("rawSql is required"))
+		return
+	}
+	if sql, err = ds.guardSQL(sql); err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, QueryPreviewResponse{SQL: sql, QueryOptions: qm.QueryOptions})
+}
+
+// handleQueryCost explains a query like the one of handleQuery and returns its estimated cost
+func (ds *DataSource) handleQueryCost(w http.ResponseWriter, r *http.Request) {
+	sql, status, err := ds.resourceSQL(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	pinotResp, err := ds.runQuery(r.Context(), explainSQL(sql), nil)
+	if err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	cost, err := parseExplainPlan(pinotResp)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	cost.SQL = sql
+
+	writeJSON(w, http.StatusOK, cost)
+}
+
+// handleClusterConfigs returns the controller's cluster configuration, such as broker query defaults
+func (ds *DataSource) handleClusterConfigs(w http.ResponseWriter, r *http.Request) {
+	configs, err := ds.client.ClusterConfigs(r.Context())
+	if err != nil {
+		writeError(w, controllerErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, configs)
+}
+
+// handleConfig returns the effective datasource configuration with secrets redacted, so users can
+// verify provisioning
+func (ds *DataSource) handleConfig(w http.ResponseWriter, r *http.Request) {
+	config := ds.config
+	config.Broker = redactEndpointConfig(config.Broker)
+	config.Controller = redactEndpointConfig(config.Controller)
+
+	secureFields := map[string]string{}
+	clients := map[string]*HTTPClient{}
+	if client, ok := ds.client.(*PinotClient); ok {
+		clients["broker"] = client.brokerClient
+		clients["controller"] = client.controllerClient
+	}
+	for prefix, client := range clients {
+		if client == nil {
+			continue
+		}
+		if client.password != "" {
+			secureFields[prefix+"Password"] = RedactedValue
+		}
+		if client.token != "" {
+			secureFields[prefix+"Token"] = RedactedValue
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ConfigResponse{DataSourceConfig: config, SecureFields: secureFields})
+}
+
+// redactEndpointConfig returns a copy of the endpoint settings with any password in the URL redacted
+func redactEndpointConfig(endpoint *HTTPClientConfig) *HTTPClientConfig {
+	if endpoint == nil {
+		return nil
+	}
+
+	redacted := *endpoint
+	if parsed, err := url.Parse(endpoint.Url); err == nil {
+		redacted.Url = parsed.Redacted()
+	} else {
+		redacted.Url = RedactedValue
+	}
+	return &redacted
+}
+
+// handleInstances returns the instance IDs of the cluster for operational overviews
+func (ds *DataSource) handleInstances(w http.ResponseWriter, r *http.Request) {
+	instances, err := ds.client.Instances(r.Context())
+	if err != nil {
+		writeError(w, controllerErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, instances)
+}
+
+// handleTableKeys returns the dimension column names of a table, used as ad-hoc filter keys
+func (ds *DataSource) handleTableKeys(w http.ResponseWriter, r *http.Request) {
+	schema, err := ds.client.TableSchema(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, controllerErrorStatus(err), err)
+		return
+	}
+
+	keys := make([]string, 0, len(schema.DimensionFieldSpecs))
+	for _, spec := range schema.DimensionFieldSpecs {
+		keys = append(keys, spec.Name)
+	}
+
+	writeJSON(w, http.StatusOK, keys)
+}
+
+// handleTableValues returns the distinct values of a column, used as ad-hoc filter values
+func (ds *DataSource) handleTableValues(w http.ResponseWriter, r *http.Request) {
+	table := r.PathValue("name")
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("key is required"))
+		return
+	}
+	if strings.Contains(table, `"`) || strings.Contains(key, `"`) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("table and key must not contain double quotes"))
+		return
+	}
+
+	limit := DefaultAdHocValuesLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", raw))
+			return
+		}
+		limit = n
+	}
+
+	sql := fmt.Sprintf(`SELECT DISTINCT "%s" FROM "%s" LIMIT %d`, key, table, limit)
+	pinotResp, err := ds.runQuery(r.Context(), sql, nil)
+	if err != nil {
+		writeError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	values := []string{}
+	if pinotResp.ResultTable != nil {
+		for _, row := range pinotResp.ResultTable.Rows {
+			if len(row) > 0 && row[0] != nil {
+				values = append(values, convertToString(row[0]))
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, values)
+}
+
+// handleTableSize returns the storage size of a table reported by the controller
+func (ds *DataSource) handleTableSize(w http.ResponseWriter, r *http.Request) {
+	size, err := ds.client.TableSize(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, controllerErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, size)
+}
+
+// ============================================================================
+// RESOURCES - Helpers
+// ============================================================================
+
+// resourceSQL decodes a QueryResourceRequest and returns its SQL with macros applied
+// On failure it returns the response status matching the error
+func (ds *DataSource) resourceSQL(r *http.Request) (string, int, error) {
+	var body QueryResourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to parse request: %w", err)
+	}
+
+	rawSQL := ds.removeComments(strings.TrimSpace(body.SQL))
+	if rawSQL == "" {
+		return "", http.StatusBadRequest, fmt.Errorf("sql is required")
+	}
+
+	timezone, err := ds.macroTimezone("")
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+
+	timeRange := body.TimeRange.toBackend()
+	sql, err := applyMacros(rawSQL, macroContext{
+		timeRange: timeRange,
+		interval:  computeInterval(timeRange, body.MaxDataPoints, 0),
+		database:  ds.config.DefaultDatabase,
+		timezone:  timezone,
+	})
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	return applyDefaultDatabase(sql, ds.config.DefaultDatabase), http.StatusOK, nil
+}
+
+// runResourceQuery runs the query of a QueryResourceRequest, see resourceSQL, and converts the result
+// On failure it returns the response status matching the error
+func (ds *DataSource) runResourceQuery(r *http.Request) (data.Frames, int, error) {
+	sql, status, err := ds.resourceSQL(r)
+	if err != nil {
+		return nil, status, err
+	}
+	if sql, err = ds.guardSQL(sql); err != nil {
+		return nil, queryErrorStatus(err), err
+	}
+
+	pinotResp, err := ds.sendQuery(r.Context(), sql, nil)
+	if err != nil {
+		return nil, queryErrorStatus(err), err
+	}
+
+	frames, err := convertToDataFrames("A", pinotResp, QueryModel{}, ds.conversionOptions())
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to convert query response: %w", err)
+	}
+	setFrameMeta(frames, sql, pinotResp)
+
+	return frames, http.StatusOK, nil
+}
+
+// explainSQL prefixes the last statement of the SQL with EXPLAIN PLAN FOR, so leading SET
+// statements still apply. Queries that are already explained are returned unchanged
+func explainSQL(sql string) string {
+	statements := splitStatements(sql)
+	last := len(statements) - 1
+	for last > 0 && strings.TrimSpace(statements[last]) == "" {
+		last--
+	}
+	if leadingKeyword(statements[last]) != "EXPLAIN" {
+		statements[last] = " EXPLAIN PLAN FOR " + strings.TrimSpace(statements[last])
+	}
+	return strings.TrimSpace(strings.Join(statements[:last+1], ";"))
+}
+
+// explainIndexRegex matches the index lookups reported by filter operators, e.g. indexLookUp:inverted_index
+var explainIndexRegex = regexp.MustCompile(`indexLookUp:(\w+)`)
+
+// parseExplainPlan summarizes the Operator, Operator_Id and Parent_Id rows of an explain plan
+// Columns come from the PROJECT operators and indexes from the FILTER_*_INDEX operators or their
+// index lookups; FILTER_FULL_SCAN marks a full scan
+func parseExplainPlan(resp *PinotResponse) (*QueryCostResponse, error) {
+	if resp.ResultTable == nil || len(resp.ResultTable.DataSchema.ColumnNames) < 3 ||
+		!strings.EqualFold(resp.ResultTable.DataSchema.ColumnNames[0], "Operator") {
+		return nil, fmt.Errorf("unsupported explain plan output")
+	}
+
+	cost := &QueryCostResponse{Columns: []string{}, Indexes: []string{}, Plan: []QueryPlanOperator{}}
+	for _, row := range resp.ResultTable.Rows {
+		if len(row) < 3 {
+			continue
+		}
+		operator := convertToString(row[0])
+		id, _ := convertToInt64(row[1])
+		parentID, _ := convertToInt64(row[2])
+		cost.Plan = append(cost.Plan, QueryPlanOperator{Operator: operator, ID: id, ParentID: parentID})
+
+		name, args, _ := strings.Cut(strings.TrimSuffix(operator, ")"), "(")
+		switch {
+		case name == "PROJECT":
+			for _, column := range strings.Split(args, ",") {
+				if column = strings.TrimSpace(column); column != "" && !slices.Contains(cost.Columns, column) {
+					cost.Columns = append(cost.Columns, column)
+				}
+			}
+		case name == "PLAN_START":
+			if _, segments, ok := strings.Cut(args, "numSegmentsForThisPlan:"); ok {
+				count, _ := strconv.ParseInt(strings.TrimSpace(segments), 10, 64)
+				cost.Segments += count
+			}
+		case name == "FILTER_FULL_SCAN":
+			cost.FullScan = true
+		case strings.HasPrefix(name, "FILTER_"):
+			index := ""
+			if match := explainIndexRegex.FindStringSubmatch(args); match != nil {
+				index = strings.ToLower(match[1])
+			} else if strings.HasSuffix(name, "_INDEX") {
+				index = strings.ToLower(strings.TrimPrefix(name, "FILTER_"))
+			}
+			if index != "" && !slices.Contains(cost.Indexes, index) {
+				cost.Indexes = append(cost.Indexes, index)
+			}
+		}
+	}
+	return cost, nil
+}
+
+// writeFrameCSV writes the frame as CSV with a header row of field names
+// Null values are written as empty cells and times as RFC 3339 in UTC
+func writeFrameCSV(w io.Writer, frame *data.Frame) error {
+	writer := csv.NewWriter(w)
+
+	record := make([]string, len(frame.Fields))
+	for idx, field := range frame.Fields {
+		record[idx] = field.Name
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+
+	for rowIdx := 0; rowIdx < frame.Rows(); rowIdx++ {
+		for idx, field := range frame.Fields {
+			record[idx] = formatCSVValue(field, rowIdx)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatCSVValue renders a single field value as a CSV cell
+func formatCSVValue(field *data.Field, rowIdx int) string {
+	value, ok := field.ConcreteAt(rowIdx)
+	if !ok {
+		return ""
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// controllerErrorStatus maps a controller operation error to a resource response status
+func controllerErrorStatus(err error) int {
+	if errors.Is(err, ErrControllerNotConfigured) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// queryErrorStatus maps a query error to a resource response status
+func queryErrorStatus(err error) int {
+	if errors.Is(err, ErrReadOnlyQuery) || errors.Is(err, ErrMandatoryFilter) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// toBackend converts the epoch milliseconds range into a Grafana time range
+func (tr ResourceTimeRange) toBackend() backend.TimeRange {
+	return backend.TimeRange{
+		From: time.UnixMilli(tr.From).UTC(),
+		To:   time.UnixMilli(tr.To).UTC(),
+	}
+}
+
+// writeJSON writes the value as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to encode response: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// writeError writes the error as a JSON response with the given status
+func writeError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ============================================================================
+// TYPES - Table References
+// ============================================================================
+
+// Pinot table types of a hybrid table
+const (
+	TableTypeOffline  = "OFFLINE"
+	TableTypeRealtime = "REALTIME"
+)
+
+// tableReference locates a table name following a FROM keyword in the SQL
+type tableReference struct {
+	start int // Offset of the name, excluding quotes
+	end   int
+	name  string
+}
+
+// ============================================================================
+// SQL REWRITING - Table References
+// ============================================================================
+
+var fromTableRegex = regexp.MustCompile(`(?i)\bFROM\s+("?)([A-Za-z_][\w.]*)("?)`)
+
+// findTableReferences returns the tables referenced by FROM clauses of the query and its subqueries
+// FROM keywords inside string literals or function calls (e.g. EXTRACT(DAY FROM ts)) are ignored
+func findTableReferences(sql string) []tableReference {
+	scope := scanSQLScope(sql)
+
+	var refs []tableReference
+	for _, match := range fromTableRegex.FindAllStringSubmatchIndex(sql, -1) {
+		if !scope[match[0]] {
+			continue
+		}
+		// Reject unbalanced quotes such as FROM "table
+		if (match[3] > match[2]) != (match[7] > match[6]) {
+			continue
+		}
+		refs = append(refs, tableReference{
+			start: match[4],
+			end:   match[5],
+			name:  sql[match[4]:match[5]],
+		})
+	}
+	return refs
+}
+
+// scanSQLScope reports, for each byte of the SQL, whether it belongs to a query scope where a
+// FROM keyword introduces a table: outside string literals and quoted identifiers, and either at
+// the top level or directly inside a parenthesized subquery
+func scanSQLScope(sql string) []bool {
+	scope := make([]bool, len(sql))
+	var parens []bool // For each open parenthesis, whether it starts a subquery
+
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = literalEnd(sql, i) - 1
+		case c == '(':
+			rest := strings.TrimLeft(sql[i+1:], " \t\r\n")
+			parens = append(parens, len(rest) >= 6 && strings.EqualFold(rest[:6], "SELECT"))
+		case c == ')':
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+		default:
+			scope[i] = len(parens) == 0 || parens[len(parens)-1]
+		}
+	}
+	return scope
+}
+
+// cteNameRegex matches the names defined by a WITH clause, e.g. WITH recent AS (...)
+var cteNameRegex = regexp.MustCompile(`(?i)\b([A-Za-z_]\w*)\s+AS\s*\(`)
+
+// applyDefaultDatabase qualifies the bare FROM tables of the query with the database
+// (e.g. events becomes analytics.events). Tables that are already qualified and the
+// names of common table expressions are left unchanged.
+func applyDefaultDatabase(sql, database string) string {
+	if database == "" {
+		return sql
+	}
+
+	cteNames := map[string]bool{}
+	for _, match := range cteNameRegex.FindAllStringSubmatch(sql, -1) {
+		cteNames[strings.ToLower(match[1])] = true
+	}
+
+	// Rewrite from the end so earlier offsets stay valid
+	refs := findTableReferences(sql)
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		if strings.Contains(ref.name, ".") || cteNames[strings.ToLower(ref.name)] {
+			continue
+		}
+		start := ref.start
+		if start > 0 && sql[start-1] == '"' {
+			start-- // Keep the quotes around the table name only
+		}
+		sql = sql[:start] + database + "." + sql[start:]
+	}
+	return sql
+}
+
+// qualifyTable prefixes the table with the database, unless the table is already qualified
+func qualifyTable(table, database string) string {
+	if database == "" || strings.Contains(table, ".") {
+		return table
+	}
+	return database + "." + table
+}
+
+// applyTableType rewrites the FROM table of the query to the typed name of a hybrid table half
+// (e.g. airlineStats becomes airlineStats_OFFLINE). An existing type suffix is replaced.
+func applyTableType(sql, tableType string) (string, error) {
+	if tableType == "" {
+		return sql, nil
+	}
+
+	tableType = strings.ToUpper(tableType)
+	if tableType != TableTypeOffline && tableType != TableTypeRealtime {
+		return "", fmt.Errorf("invalid table type %q, expected %s or %s", tableType, TableTypeOffline, TableTypeRealtime)
+	}
+
+	refs := findTableReferences(sql)
+	if len(refs) == 0 {
+		return "", fmt.Errorf("table type %s requires a FROM table reference in the query", tableType)
+	}
+
+	baseName := stripTableType(refs[0].name)
+	for _, ref := range refs[1:] {
+		if !strings.EqualFold(stripTableType(ref.name), baseName) {
+			return "", fmt.Errorf("table type %s requires a single table, found %s and %s", tableType, refs[0].name, ref.name)
+		}
+	}
+
+	// Rewrite from the end so earlier offsets stay valid
+	for i := len(refs) - 1; i >= 0; i-- {
+		ref := refs[i]
+		sql = sql[:ref.start] + stripTableType(ref.name) + "_" + tableType + sql[ref.end:]
+	}
+
+	return sql, nil
+}
+
+// stripTableType removes an _OFFLINE or _REALTIME suffix from a table name
+func stripTableType(name string) string {
+	upper := strings.ToUpper(name)
+	for _, suffix := range []string{"_" + TableTypeOffline, "_" + TableTypeRealtime} {
+		if strings.HasSuffix(upper, suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+	return name
+}
+
+// ============================================================================
+// SQL REWRITING - Auto Timeseries
+// ============================================================================
+
+var (
+	selectKeywordRegex  = regexp.MustCompile(`(?i)\bSELECT\s+(DISTINCT\s+)?`)
+	fromKeywordRegex    = regexp.MustCompile(`(?i)\bFROM\b`)
+	groupByKeywordRegex = regexp.MustCompile(`(?i)\bGROUP\s+BY\s+`)
+	orderByKeywordRegex = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+	limitKeywordRegex   = regexp.MustCompile(`(?i)\bLIMIT\b`)
+	aggregateCallRegex  = regexp.MustCompile(`(?i)\b(COUNT|SUM|AVG|MIN|MAX|MINMAXRANGE|MODE|DISTINCT(COUNT|SUM|AVG)\w*|PERCENTILE\w*|SEGMENTPARTITIONEDDISTINCTCOUNT|(FIRST|LAST)WITHTIME)\s*\(`)
+	trailingTerminators = " \t\r\n;"
+)
+
+// applyAutoTimeSeries adds the time column to a timeseries query that does not select it, e.g.
+// SELECT value FROM t becomes SELECT ts, value FROM t ORDER BY ts. The column is also added to
+// a GROUP BY clause, and the ORDER BY is only added when the query has none.
+// Queries selecting the column, or *, are returned unchanged, and so are aggregations without a
+// GROUP BY (e.g. SELECT COUNT(*) FROM t), where the column could be neither grouped nor aggregated.
+func applyAutoTimeSeries(sql, timeColumn string) string {
+	if timeColumn == "" {
+		return sql
+	}
+
+	topLevel := scanTopLevel(sql)
+	selectMatch := findTopLevel(selectKeywordRegex, sql, topLevel)
+	fromMatch := findTopLevel(fromKeywordRegex, sql, topLevel)
+	if selectMatch == nil || fromMatch == nil || fromMatch[0] < selectMatch[1] {
+		return sql
+	}
+
+	projection := sql[selectMatch[1]:fromMatch[0]]
+	if strings.TrimSpace(projection) == "*" || containsIdentifier(projection, timeColumn) {
+		return sql
+	}
+	if aggregateCallRegex.MatchString(projection) && findTopLevel(groupByKeywordRegex, sql, topLevel) == nil {
+		return sql
+	}
+
+	rewritten := sql[:selectMatch[1]] + timeColumn + ", " + sql[selectMatch[1]:]
+	topLevel = scanTopLevel(rewritten)
+
+	if groupBy := findTopLevel(groupByKeywordRegex, rewritten, topLevel); groupBy != nil {
+		rewritten = rewritten[:groupBy[1]] + timeColumn + ", " + rewritten[groupBy[1]:]
+		topLevel = scanTopLevel(rewritten)
+	}
+
+	if findTopLevel(orderByKeywordRegex, rewritten, topLevel) == nil {
+		rewritten = insertOrderBy(rewritten, topLevel, timeColumn)
+	}
+
+	return rewritten
+}
+
+// insertOrderBy adds the ORDER BY clause before the top-level LIMIT, or at the end of the SQL
+func insertOrderBy(sql string, topLevel []bool, orderBy string) string {
+	if limit := findTopLevel(limitKeywordRegex, sql, topLevel); limit != nil {
+		head := strings.TrimRight(sql[:limit[0]], " \t\r\n")
+		return head + clauseSeparator(head) + "ORDER BY " + orderBy + " " + sql[limit[0]:]
+	}
+	trimmed := strings.TrimRight(sql, trailingTerminators)
+	return trimmed + clauseSeparator(trimmed) + "ORDER BY " + orderBy
+}
+
+// ============================================================================
+// SQL REWRITING - Sorting
+// ============================================================================
+
+// sortColumnRegex matches the column names accepted for sorting: plain or double-quoted identifiers
+var sortColumnRegex = regexp.MustCompile(`^([A-Za-z_][\w.]*|"[^"]+")$`)
+
+// applySort adds ORDER BY column [DESC] to a SELECT query without an ORDER BY at the top level,
+// e.g. for server-side sorting of table panels. The column must be selected by the query (as a
+// column or an alias) unless it selects *; queries with an ORDER BY are returned unchanged.
+func applySort(sql, column string, desc bool) (string, error) {
+	if column == "" {
+		return sql, nil
+	}
+	if !sortColumnRegex.MatchString(column) {
+		return "", fmt.Errorf("invalid sort column %q", column)
+	}
+
+	topLevel := scanTopLevel(sql)
+	selectMatch := findTopLevel(selectKeywordRegex, sql, topLevel)
+	fromMatch := findTopLevel(fromKeywordRegex, sql, topLevel)
+	if selectMatch == nil || fromMatch == nil || fromMatch[0] < selectMatch[1] {
+		return "", fmt.Errorf("sorting requires a SELECT ... FROM query")
+	}
+
+	projection := sql[selectMatch[1]:fromMatch[0]]
+	if strings.TrimSpace(projection) != "*" && !containsIdentifier(projection, column) {
+		return "", fmt.Errorf("sort column %q is not selected by the query", column)
+	}
+	if findTopLevel(orderByKeywordRegex, sql, topLevel) != nil {
+		return sql, nil
+	}
+
+	orderBy := column
+	if desc {
+		orderBy += " DESC"
+	}
+	return insertOrderBy(sql, topLevel, orderBy), nil
+}
+
+// ============================================================================
+// SQL REWRITING - Default Limit and Pagination
+// ============================================================================
+
+// applyDefaultLimit appends a LIMIT to a SELECT query without one at the top level
+// Only the last statement is considered, so SET options before the query are kept
+func applyDefaultLimit(sql string, limit int) string {
+	if limit <= 0 {
+		return sql
+	}
+	return appendLimit(sql, fmt.Sprintf("LIMIT %d", limit))
+}
+
+// applyPagination appends the page of the query as Pinot's LIMIT offset, count to a SELECT query
+// without a LIMIT at the top level, e.g. for server-side paging of table panels
+func applyPagination(sql string, offset, limit int64) string {
+	if limit <= 0 {
+		return sql
+	}
+	return appendLimit(sql, limitClause(offset, limit))
+}
+
+// limitClause returns the LIMIT clause of a page, omitting a zero offset
+func limitClause(offset, limit int64) string {
+	if offset <= 0 {
+		return fmt.Sprintf("LIMIT %d", limit)
+	}
+	return fmt.Sprintf("LIMIT %d, %d", offset, limit)
+}
+
+// appendLimit appends the LIMIT clause to the last statement when it is a SELECT without a LIMIT
+// at the top level
+func appendLimit(sql, clause string) string {
+	trimmed := strings.TrimRight(sql, trailingTerminators)
+	statements := splitStatements(trimmed)
+	last := stripComments(statements[len(statements)-1])
+	if leadingKeyword(last) != "SELECT" || findTopLevel(limitKeywordRegex, last, scanTopLevel(last)) != nil {
+		return sql
+	}
+
+	return trimmed + clauseSeparator(trimmed) + clause
+}
+
+// clauseSeparator returns the separator to append a clause to the SQL with: a newline when the
+// last line holds a line comment, which would swallow the clause, and a space otherwise
+func clauseSeparator(sql string) string {
+	if strings.Contains(sql[strings.LastIndexByte(sql, '\n')+1:], "--") {
+		return "\n"
+	}
+	return " "
+}
+
+// findTopLevel returns the first match of the regex starting outside string literals and parentheses
+func findTopLevel(re *regexp.Regexp, sql string, topLevel []bool) []int {
+	for _, match := range re.FindAllStringIndex(sql, -1) {
+		if topLevel[match[0]] {
+			return match
+		}
+	}
+	return nil
+}
+
+// scanTopLevel reports, for each byte of the SQL, whether it is outside string literals, quoted
+// identifiers and parentheses
+func scanTopLevel(sql string) []bool {
+	topLevel := make([]bool, len(sql))
+	depth := 0
+	for i := 0; i < len(sql); i++ {
+		switch sql[i] {
+		case '\'', '"':
+			i = literalEnd(sql, i) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		default:
+			topLevel[i] = depth == 0
+		}
+	}
+	return topLevel
+}
+
+// containsIdentifier reports whether the SQL fragment references the identifier, ignoring case and quotes
+func containsIdentifier(fragment, identifier string) bool {
+	pattern := `(?i)(^|[^\w.])"?` + regexp.QuoteMeta(strings.Trim(identifier, `"`)) + `"?($|[^\w])`
+	return regexp.MustCompile(pattern).MatchString(fragment)
+}
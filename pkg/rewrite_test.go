@@ -0,0 +1,347 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ============================================================================
+// Table Reference Tests
+// ============================================================================
+
+func TestFindTableReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		expected []string
+	}{
+		{"simple select", "SELECT * FROM airlineStats", []string{"airlineStats"}},
+		{"quoted table", `SELECT * FROM "airlineStats" LIMIT 10`, []string{"airlineStats"}},
+		{"qualified table", "SELECT * FROM db.airlineStats", []string{"db.airlineStats"}},
+		{"subquery", "SELECT * FROM (SELECT carrier FROM airlineStats) t", []string{"airlineStats"}},
+		{"ignores FROM in function calls", "SELECT EXTRACT(DAY FROM ts) FROM airlineStats", []string{"airlineStats"}},
+		{"ignores FROM in string literals", "SELECT * FROM airlineStats WHERE note = 'from elsewhere'", []string{"airlineStats"}},
+		{"no table", "SELECT 1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, ref := range findTableReferences(tt.sql) {
+				names = append(names, ref.name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
+
+func TestApplyTableType(t *testing.T) {
+	tests := []struct {
+		name        string
+		sql         string
+		tableType   string
+		expected    string
+		expectError bool
+		errorMsg    string
+	}{
+		{
+			name:     "leaves the query untouched without a table type",
+			sql:      "SELECT * FROM airlineStats",
+			expected: "SELECT * FROM airlineStats",
+		},
+		{
+			name:      "appends the offline suffix",
+			sql:       "SELECT COUNT(*) FROM airlineStats WHERE Carrier = 'AA'",
+			tableType: "OFFLINE",
+			expected:  "SELECT COUNT(*) FROM airlineStats_OFFLINE WHERE Carrier = 'AA'",
+		},
+		{
+			name:      "appends the realtime suffix",
+			sql:       "SELECT COUNT(*) FROM airlineStats",
+			tableType: "realtime",
+			expected:  "SELECT COUNT(*) FROM airlineStats_REALTIME",
+		},
+		{
+			name:      "keeps quoted identifiers quoted",
+			sql:       `SELECT * FROM "airlineStats"`,
+			tableType: "OFFLINE",
+			expected:  `SELECT * FROM "airlineStats_OFFLINE"`,
+		},
+		{
+			name:      "replaces an existing suffix",
+			sql:       "SELECT * FROM airlineStats_REALTIME",
+			tableType: "OFFLINE",
+			expected:  "SELECT * FROM airlineStats_OFFLINE",
+		},
+		{
+			name:      "rewrites every reference to the same table",
+			sql:       "SELECT * FROM airlineStats WHERE ts = (SELECT MAX(ts) FROM airlineStats)",
+			tableType: "OFFLINE",
+			expected:  "SELECT * FROM airlineStats_OFFLINE WHERE ts = (SELECT MAX(ts) FROM airlineStats_OFFLINE)",
+		},
+		{
+			name:        "fails without a table reference",
+			sql:         "SELECT 1",
+			tableType:   "OFFLINE",
+			expectError: true,
+			errorMsg:    "requires a FROM table reference",
+		},
+		{
+			name:        "fails with several tables",
+			sql:         "SELECT * FROM a WHERE x IN (SELECT x FROM b)",
+			tableType:   "OFFLINE",
+			expectError: true,
+			errorMsg:    "requires a single table, found a and b",
+		},
+		{
+			name:        "fails with an invalid table type",
+			sql:         "SELECT * FROM airlineStats",
+			tableType:   "HYBRID",
+			expectError: true,
+			errorMsg:    "invalid table type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applyTableType(tt.sql, tt.tableType)
+
+			if tt.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestApplyAutoTimeSeries(t *testing.T) {
+	tests := []struct {
+		name       string
+		sql        string
+		timeColumn string
+		expected   string
+	}{
+		{
+			name:       "bare metric select",
+			sql:        "SELECT value FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics ORDER BY ts",
+		},
+		{
+			name:       "time column already selected",
+			sql:        "SELECT ts, value FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics",
+		},
+		{
+			name:       "quoted time column already selected",
+			sql:        `SELECT "TS" AS t, value FROM metrics`,
+			timeColumn: "ts",
+			expected:   `SELECT "TS" AS t, value FROM metrics`,
+		},
+		{
+			name:       "star projection",
+			sql:        "SELECT * FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT * FROM metrics",
+		},
+		{
+			name:       "column name as prefix of another column",
+			sql:        "SELECT ts_label, value FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT ts, ts_label, value FROM metrics ORDER BY ts",
+		},
+		{
+			name:       "distinct",
+			sql:        "SELECT DISTINCT value FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT DISTINCT ts, value FROM metrics ORDER BY ts",
+		},
+		{
+			name:       "group by",
+			sql:        "SELECT AVG(value) FROM metrics GROUP BY host",
+			timeColumn: "ts",
+			expected:   "SELECT ts, AVG(value) FROM metrics GROUP BY ts, host ORDER BY ts",
+		},
+		{
+			name:       "aggregation without group by",
+			sql:        "SELECT COUNT(*) FROM metrics WHERE host = 'a'",
+			timeColumn: "ts",
+			expected:   "SELECT COUNT(*) FROM metrics WHERE host = 'a'",
+		},
+		{
+			name:       "nested aggregation without group by",
+			sql:        "SELECT ROUND(avg (value), 2) AS mean FROM metrics",
+			timeColumn: "ts",
+			expected:   "SELECT ROUND(avg (value), 2) AS mean FROM metrics",
+		},
+		{
+			name:       "aggregation in a subquery",
+			sql:        "SELECT value FROM metrics WHERE value > (SELECT AVG(value) FROM metrics)",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics WHERE value > (SELECT AVG(value) FROM metrics) ORDER BY ts",
+		},
+		{
+			name:       "existing order by is kept",
+			sql:        "SELECT value FROM metrics ORDER BY value DESC",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics ORDER BY value DESC",
+		},
+		{
+			name:       "order by placed before limit",
+			sql:        "SELECT value FROM metrics WHERE host = 'a' LIMIT 100",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics WHERE host = 'a' ORDER BY ts LIMIT 100",
+		},
+		{
+			name:       "trailing semicolon",
+			sql:        "SELECT value FROM metrics;",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics ORDER BY ts",
+		},
+		{
+			name:       "keywords in subquery and literals are ignored",
+			sql:        "SELECT value FROM metrics WHERE host IN (SELECT host FROM hosts ORDER BY host LIMIT 5) AND note <> 'order by x'",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics WHERE host IN (SELECT host FROM hosts ORDER BY host LIMIT 5) AND note <> 'order by x' ORDER BY ts",
+		},
+		{
+			name:       "trailing line comment",
+			sql:        "SELECT value FROM metrics -- all hosts",
+			timeColumn: "ts",
+			expected:   "SELECT ts, value FROM metrics -- all hosts\nORDER BY ts",
+		},
+		{
+			name:       "no time column",
+			sql:        "SELECT value FROM metrics",
+			timeColumn: "",
+			expected:   "SELECT value FROM metrics",
+		},
+		{
+			name:       "no from clause",
+			sql:        "SELECT 1",
+			timeColumn: "ts",
+			expected:   "SELECT 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, applyAutoTimeSeries(tt.sql, tt.timeColumn))
+		})
+	}
+}
+
+func TestApplyDefaultLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		limit    int
+		expected string
+	}{
+		{"appends the limit", "SELECT * FROM t", 1000, "SELECT * FROM t LIMIT 1000"},
+		{"disabled without a limit", "SELECT * FROM t", 0, "SELECT * FROM t"},
+		{"keeps an existing limit", "SELECT * FROM t limit 10", 1000, "SELECT * FROM t limit 10"},
+		{"ignores a subquery limit", "SELECT * FROM (SELECT a FROM t LIMIT 5)", 1000, "SELECT * FROM (SELECT a FROM t LIMIT 5) LIMIT 1000"},
+		{"ignores limit in literals", "SELECT * FROM t WHERE note = 'no limit'", 1000, "SELECT * FROM t WHERE note = 'no limit' LIMIT 1000"},
+		{"strips a trailing semicolon", "SELECT * FROM t;\n", 1000, "SELECT * FROM t LIMIT 1000"},
+		{"applies to the query after SET options", "SET useMultiStageEngine = true; SELECT * FROM t", 1000, "SET useMultiStageEngine = true; SELECT * FROM t LIMIT 1000"},
+		{"moves past a trailing line comment", "SELECT * FROM t -- all rows", 1000, "SELECT * FROM t -- all rows\nLIMIT 1000"},
+		{"ignores a commented limit", "SELECT * FROM t /* LIMIT 5 */", 1000, "SELECT * FROM t /* LIMIT 5 */ LIMIT 1000"},
+		{"leaves other statements", "EXPLAIN PLAN FOR SELECT * FROM t", 1000, "EXPLAIN PLAN FOR SELECT * FROM t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, applyDefaultLimit(tt.sql, tt.limit))
+		})
+	}
+}
+
+func TestApplyDefaultDatabase(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		database string
+		expected string
+	}{
+		{"qualifies a bare table", "SELECT * FROM events", "analytics", "SELECT * FROM analytics.events"},
+		{"disabled without a database", "SELECT * FROM events", "", "SELECT * FROM events"},
+		{"keeps a qualified table", "SELECT * FROM other.events", "analytics", "SELECT * FROM other.events"},
+		{"qualifies a quoted table", `SELECT * FROM "events"`, "analytics", `SELECT * FROM analytics."events"`},
+		{"qualifies subquery tables", "SELECT * FROM (SELECT a FROM events)", "analytics", "SELECT * FROM (SELECT a FROM analytics.events)"},
+		{"keeps common table expressions", "WITH recent AS (SELECT * FROM events) SELECT * FROM recent", "analytics", "WITH recent AS (SELECT * FROM analytics.events) SELECT * FROM recent"},
+		{"ignores FROM in literals", "SELECT * FROM events WHERE note = 'from x'", "analytics", "SELECT * FROM analytics.events WHERE note = 'from x'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, applyDefaultDatabase(tt.sql, tt.database))
+		})
+	}
+}
+
+func TestApplyPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		offset   int64
+		limit    int64
+		expected string
+	}{
+		{"appends offset and count", "SELECT * FROM t", 100, 50, "SELECT * FROM t LIMIT 100, 50"},
+		{"omits a zero offset", "SELECT * FROM t", 0, 50, "SELECT * FROM t LIMIT 50"},
+		{"disabled without a limit", "SELECT * FROM t", 100, 0, "SELECT * FROM t"},
+		{"keeps an existing limit", "SELECT * FROM t LIMIT 10", 100, 50, "SELECT * FROM t LIMIT 10"},
+		{"ignores a subquery limit", "SELECT * FROM (SELECT a FROM t LIMIT 5)", 20, 10, "SELECT * FROM (SELECT a FROM t LIMIT 5) LIMIT 20, 10"},
+		{"leaves other statements", "EXPLAIN PLAN FOR SELECT * FROM t", 100, 50, "EXPLAIN PLAN FOR SELECT * FROM t"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, applyPagination(tt.sql, tt.offset, tt.limit))
+		})
+	}
+}
+
+func TestApplySort(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		column   string
+		desc     bool
+		expected string
+		errorMsg string
+	}{
+		{"appends ascending order", "SELECT carrier, flights FROM t", "flights", false, "SELECT carrier, flights FROM t ORDER BY flights", ""},
+		{"appends descending order", "SELECT carrier, flights FROM t;", "flights", true, "SELECT carrier, flights FROM t ORDER BY flights DESC", ""},
+		{"inserts before the limit", "SELECT carrier, COUNT(*) AS cnt FROM t GROUP BY carrier LIMIT 10", "cnt", true, "SELECT carrier, COUNT(*) AS cnt FROM t GROUP BY carrier ORDER BY cnt DESC LIMIT 10", ""},
+		{"accepts any column of a star query", "SELECT * FROM t", `"Flight Count"`, false, `SELECT * FROM t ORDER BY "Flight Count"`, ""},
+		{"keeps an existing order", "SELECT carrier FROM t ORDER BY carrier", "carrier", true, "SELECT carrier FROM t ORDER BY carrier", ""},
+		{"ignores a subquery order", "SELECT a FROM (SELECT a FROM t ORDER BY a LIMIT 5)", "a", true, "SELECT a FROM (SELECT a FROM t ORDER BY a LIMIT 5) ORDER BY a DESC", ""},
+		{"moves past a trailing line comment", "SELECT a FROM t -- all rows", "a", false, "SELECT a FROM t -- all rows\nORDER BY a", ""},
+		{"moves past a line comment before the limit", "SELECT a FROM t -- all rows\nLIMIT 10", "a", true, "SELECT a FROM t -- all rows\nORDER BY a DESC LIMIT 10", ""},
+		{"disabled without a column", "SELECT a FROM t", "", true, "SELECT a FROM t", ""},
+		{"rejects an unselected column", "SELECT carrier FROM t", "flights", false, "", `sort column "flights" is not selected by the query`},
+		{"rejects an expression", "SELECT a FROM t", "a; DROP TABLE t", false, "", "invalid sort column"},
+		{"requires a select", "SHOW TABLES", "a", false, "", "sorting requires a SELECT ... FROM query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := applySort(tt.sql, tt.column, tt.desc)
+			if tt.errorMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}